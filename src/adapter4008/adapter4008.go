@@ -0,0 +1,98 @@
+package adapter4008
+
+import (
+	"common"
+	"encoding/json"
+	"events"
+	"interfaces"
+	"supportcommon"
+)
+
+const BusWidth = 4
+
+// Adapter4008 is a model of the Intel 4008 address latch and 4009 I/O and
+// program memory converter pair. It connects a generic memory block to the
+// same bus and control lines as a 4001
+type Adapter4008 struct {
+	interfaces.ClockedElement
+	Core   supportcommon.RamRom
+	busInt common.Bus // Internal Data Bus for address/data
+}
+
+func (a *Adapter4008) Init(busExt *common.Bus, sync *common.Signal, cm *common.Signal) {
+	a.busInt.Init(BusWidth, "4008 Internal")
+	a.Core.Init(busExt, &a.busInt, sync, cm, BusWidth, supportcommon.PageSize)
+	a.Core.SetChipType(supportcommon.ChipTypeStdMem)
+}
+
+// SetMemory connects the memory block. Its first byte is at the start of basePage
+func (a *Adapter4008) SetMemory(mem *supportcommon.MemoryBlock, basePage int) {
+	a.Core.SetMemory(mem, basePage)
+}
+
+// SetPortBus connects the bus for one of the 16 I/O ports
+func (a *Adapter4008) SetPortBus(port int, bus *common.Bus) {
+	a.Core.SetPortBus(port, bus)
+}
+
+// SetPagePort selects the I/O port which holds the page for WPM and RPM
+func (a *Adapter4008) SetPagePort(port int) {
+	a.Core.SetPagePort(port)
+}
+
+// SetBusMonitor attaches a monitor to the internal bus
+func (a *Adapter4008) SetBusMonitor(m *common.BusMonitor) {
+	a.Core.SetBusMonitor(m)
+}
+
+// SetEvents raises the chip events through the dispatcher
+func (a *Adapter4008) SetEvents(d *events.Dispatcher) {
+	a.Core.SetEvents(d)
+}
+
+// SetResetLine connects the RESET input
+func (a *Adapter4008) SetResetLine(reset *common.Signal) {
+	a.Core.SetResetLine(reset)
+}
+
+// MarshalCheckpoint returns the state of the chips and the memory, for the board checkpoints
+func (a *Adapter4008) MarshalCheckpoint() ([]byte, error) {
+	return json.Marshal(a.Core.Checkpoint())
+}
+
+// CheckCheckpoint returns the error UnmarshalCheckpoint would return, without
+// changing anything
+func (a *Adapter4008) CheckCheckpoint(data []byte) error {
+	var c supportcommon.Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	return a.Core.CheckRestore(c)
+}
+
+// UnmarshalCheckpoint restores the chips and the memory from a board checkpoint
+func (a *Adapter4008) UnmarshalCheckpoint(data []byte) error {
+	var c supportcommon.Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	return a.Core.Restore(c)
+}
+
+func (a *Adapter4008) GetClockCount() int {
+	return a.Core.GetClockCount()
+}
+
+func (a *Adapter4008) Reset() {
+	a.Core.Reset()
+}
+
+// ClockIn clock in external inputs to the Core
+func (a *Adapter4008) ClockIn() {
+	a.Core.ClockIn()
+}
+
+// ClockOut clock external outputs to their respective busses/logic lines
+func (a *Adapter4008) ClockOut() {
+	a.Core.ClockOut()
+}
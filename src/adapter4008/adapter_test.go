@@ -0,0 +1,282 @@
+package adapter4008
+
+import (
+	"common"
+	"cpucore"
+	"instruction"
+	"os"
+	"supportcommon"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "adapter_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+const testDriver = common.NamedDriver("Jig")
+
+type adapterTestJig struct {
+	adapter Adapter4008
+	memory  supportcommon.MemoryBlock
+	dataBus common.Bus
+	ioBus   common.Bus
+	sync    common.Signal
+	cmRom   common.Signal
+	reset   common.Signal
+}
+
+func createTestJig(size int, writable bool, basePage int) *adapterTestJig {
+	jig := adapterTestJig{}
+	jig.dataBus.Init(4, "JIG external bus")
+	jig.ioBus.Init(4, "Port 3")
+	jig.memory.Init(size, writable, "Test memory")
+	jig.adapter.Init(&jig.dataBus, &jig.sync, &jig.cmRom)
+	jig.adapter.SetMemory(&jig.memory, basePage)
+	jig.adapter.SetPortBus(3, &jig.ioBus)
+	jig.adapter.SetResetLine(&jig.reset)
+	syncAdapter(&jig)
+	return &jig
+}
+
+func syncAdapter(jig *adapterTestJig) {
+	for i := 0; i < 8; i++ {
+		if (i % 8) == 7 {
+			jig.sync.Drive("Jig", 0)
+		} else {
+			jig.sync.Drive("Jig", 1)
+		}
+		jig.adapter.ClockIn()
+		jig.adapter.ClockOut()
+		jig.dataBus.Reset()
+		jig.dataBus.Write(testDriver, 0)
+	}
+}
+
+// runCycle runs one instruction cycle fetching from addr. If x2x3 is not nil,
+// its upper 4 bits are driven in X2 and the lower 4 bits in X3. If ioRead is
+// true, the data driven by the adapter in X2 is returned instead of the fetched byte
+func runCycle(jig *adapterTestJig, addr uint64, x2x3 *uint64, ioRead bool) uint8 {
+	var data uint64
+	for i := 0; i < 8; i++ {
+		jig.sync.Drive("Jig", 1)
+		switch i {
+		case 0, 1, 2:
+			jig.dataBus.Reset()
+			jig.dataBus.Write(testDriver, (addr>>(uint64(i)*4))&0xf)
+		case 6:
+			if x2x3 != nil {
+				jig.dataBus.Reset()
+				jig.dataBus.Write(testDriver, (*x2x3>>4)&0xf)
+			}
+		case 7:
+			if x2x3 != nil {
+				jig.dataBus.Reset()
+				jig.dataBus.Write(testDriver, *x2x3&0xf)
+			}
+			jig.sync.Drive("Jig", 0)
+		}
+		jig.adapter.ClockIn()
+		jig.dataBus.Reset()
+		jig.adapter.ClockOut()
+		// NOTE: these indicies are one earlier than the actual clock cycle number
+		switch i {
+		case 2:
+			data = jig.dataBus.Read() << 4
+		case 3:
+			data = data | jig.dataBus.Read()
+		case 5:
+			if ioRead {
+				data = jig.dataBus.Read()
+			}
+		}
+	}
+	return uint8(data)
+}
+
+func TestFetch(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(2*supportcommon.PageSize, false, 2)
+	image := make([]uint8, 2*supportcommon.PageSize)
+	for i := range image {
+		image[i] = 0x5A
+	}
+	image[0x000] = 0x12
+	image[0x1ff] = 0xfe
+	jig.memory.Load(image)
+
+	for _, addr := range []uint64{0x200, 0x3ff, 0x2aa} {
+		exp := image[addr-0x200]
+		if data := runCycle(jig, addr, nil, false); data != exp {
+			t.Errorf("Fetch mismatch at %03X. Exp %02X, got %02X", addr, exp, data)
+		}
+	}
+	// Outside of our pages, or without CM-ROM, nothing is driven
+	for _, addr := range []uint64{0x1aa, 0x4aa} {
+		if data := runCycle(jig, addr, nil, false); data == 0x5A {
+			t.Errorf("Fetch at %03X was driven", addr)
+		}
+	}
+	jig.cmRom.Drive("Jig", 1)
+	if data := runCycle(jig, 0x2aa, nil, false); data == 0x5A {
+		t.Error("Fetch was driven without CM-ROM")
+	}
+}
+
+// writeProgramMemory writes a byte with two WPMs. The code is at address 0-2
+func writeProgramMemory(jig *adapterTestJig, srcAddr uint64, value uint64) {
+	runCycle(jig, 0x000, &srcAddr, false)
+	// The accumulator is sent in X2
+	upper := value & 0xf0
+	lower := (value & 0xf) << 4
+	runCycle(jig, 0x001, &upper, false)
+	runCycle(jig, 0x002, &lower, false)
+}
+
+func TestWPM(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(2*supportcommon.PageSize, true, 0)
+	image := []uint8{instruction.SRC, instruction.WPM, instruction.WPM, instruction.WRR}
+	jig.memory.Load(image)
+
+	writeProgramMemory(jig, 0x34, 0xC5)
+	if jig.memory.Read(0x34) != 0xC5 {
+		t.Errorf("WPM mismatch. Exp C5, got %02X", jig.memory.Read(0x34))
+	}
+	if data := runCycle(jig, 0x034, nil, false); data != 0xC5 {
+		t.Errorf("Fetch mismatch after WPM. Exp C5, got %02X", data)
+	}
+
+	// The page comes from the page port
+	jig.adapter.SetPagePort(0xf)
+	port := uint64(0xf0)
+	runCycle(jig, 0x000, &port, false)
+	page := uint64(1 << 4)
+	runCycle(jig, 0x003, &page, false)
+	writeProgramMemory(jig, 0x34, 0x7E)
+	if jig.memory.Read(0x134) != 0x7E || jig.memory.Read(0x34) != 0xC5 {
+		t.Errorf("WPM with page mismatch. Got %02X, %02X", jig.memory.Read(0x134), jig.memory.Read(0x34))
+	}
+}
+
+func TestWPMReadOnly(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(supportcommon.PageSize, false, 0)
+	image := []uint8{instruction.SRC, instruction.WPM, instruction.WPM}
+	jig.memory.Load(image)
+	writeProgramMemory(jig, 0x34, 0xC5)
+	if jig.memory.Read(0x34) != 0 {
+		t.Errorf("WPM wrote to ROM. Got %02X", jig.memory.Read(0x34))
+	}
+}
+
+func TestRPM(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(supportcommon.PageSize, false, 0)
+	image := make([]uint8, supportcommon.PageSize)
+	image[0] = instruction.SRC
+	image[1] = instruction.RPM
+	image[2] = instruction.RPM
+	image[0x80] = 0x9B
+	jig.memory.Load(image)
+
+	srcAddr := uint64(0x80)
+	runCycle(jig, 0x000, &srcAddr, false)
+	if data := runCycle(jig, 0x001, nil, true); data != 0x9 {
+		t.Errorf("RPM upper mismatch. Exp 9, got %X", data)
+	}
+	if data := runCycle(jig, 0x002, nil, true); data != 0xB {
+		t.Errorf("RPM lower mismatch. Exp B, got %X", data)
+	}
+}
+
+func TestIOPorts(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(supportcommon.PageSize, false, 0)
+	image := []uint8{instruction.SRC, instruction.WRR, instruction.RDR}
+	jig.memory.Load(image)
+
+	srcAddr := uint64(0x30)
+	runCycle(jig, 0x000, &srcAddr, false)
+	value := uint64(0x9 << 4)
+	runCycle(jig, 0x001, &value, false)
+	if jig.ioBus.Read() != 0x9 {
+		t.Errorf("I/O port mismatch. Exp 9, got %X", jig.ioBus.Read())
+	}
+	jig.ioBus.Reset()
+	jig.ioBus.Write(testDriver, 0x6)
+	if data := runCycle(jig, 0x002, nil, true); data != 0x6 {
+		t.Errorf("I/O read mismatch. Exp 6, got %X", data)
+	}
+}
+
+func TestReset(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig(supportcommon.PageSize, true, 0)
+	image := []uint8{instruction.SRC, instruction.WPM, instruction.WPM}
+	jig.memory.Load(image)
+
+	// Leave the toggle set after the upper 4 bits
+	srcAddr := uint64(0x34)
+	runCycle(jig, 0x000, &srcAddr, false)
+	upper := uint64(0x1 << 4)
+	runCycle(jig, 0x001, &upper, false)
+
+	jig.reset.Drive("Jig", 1)
+	runCycle(jig, 0x000, nil, false)
+	jig.reset.Drive("Jig", 0)
+	// Wait for SYNC after RESET
+	runCycle(jig, 0x000, nil, false)
+
+	writeProgramMemory(jig, 0x34, 0xC5)
+	if jig.memory.Read(0x34) != 0xC5 {
+		t.Errorf("WPM mismatch after RESET. Exp C5, got %02X", jig.memory.Read(0x34))
+	}
+}
+
+// TestCopyProgramMemory runs a 4040 program from RAM, which copies a byte of
+// program memory with RPM and WPM
+func TestCopyProgramMemory(t *testing.T) {
+	SetupLogger()
+	core := cpucore.Core{}
+	core.InitModel(cpucore.Model4040)
+	memory := supportcommon.MemoryBlock{}
+	memory.Init(supportcommon.PageSize, true, "Program RAM")
+	adapter := Adapter4008{}
+	adapter.Init(&core.ExternalDataBus, &core.Sync, &core.CmROM)
+	adapter.SetMemory(&memory, 0)
+	adapter.SetResetLine(&core.ResetIn)
+
+	program := []uint8{
+		instruction.FIM, 0x80, // P0 = 0x80
+		instruction.SRC,
+		instruction.RPM, instruction.XCH | 2, // Upper 4 bits into R2
+		instruction.RPM, instruction.XCH | 3, // Lower 4 bits into R3
+		instruction.FIM, 0x90, // P0 = 0x90
+		instruction.SRC,
+		instruction.LD | 2, instruction.WPM,
+		instruction.LD | 3, instruction.WPM,
+		instruction.JUN, 0x0e, // Loop here
+	}
+	image := make([]uint8, supportcommon.PageSize)
+	copy(image, program)
+	image[0x80] = 0xA7
+	memory.Load(image)
+
+	for i := 0; i < 8*40; i++ {
+		core.Calculate()
+		core.ClockIn()
+		adapter.ClockIn()
+		core.ClockOut()
+		adapter.ClockOut()
+	}
+	if memory.Read(0x90) != 0xA7 {
+		t.Errorf("Program memory copy mismatch. Exp A7, got %02X", memory.Read(0x90))
+	}
+}
@@ -2,26 +2,59 @@ package addressstack
 
 import (
 	"common"
+	"fmt"
 
 	"github.com/romana/rlog"
 )
 
-// AddressStack contains the program counter, stack pointer and stack address registers
+// Stack events reported in strict mode
+const (
+	EventOverflow  = iota // A push overwrote the oldest return address
+	EventUnderflow        // A pop was done with no return address on the stack
+)
+
+// StackEvent describes a stack overflow or underflow
+type StackEvent struct {
+	Type int    // EventOverflow or EventUnderflow
+	PC   uint64 // The program counter after the push or pop
+}
+
+// AddressStack contains the program counter and the stack address registers.
+// Like the real 4004, they are one circular register file, and the register
+// selected by the stack pointer is the program counter. A push just moves the
+// stack pointer to the next register, so the fourth nested JMS overwrites the
+// oldest return address
 type AddressStack struct {
-	pc           common.Register
-	stack        []common.Register
-	stackPointer int
+	regs         []common.Register
+	stackPointer int // The register which is the program counter
+	levels       int // How many return addresses are on the stack
 	dataBus      *common.Bus
 	width        int
 	mask         uint64
-	drivingBus   bool
+	drivingBus   bool                   // The program counter drove the data bus during this clock
+	eventHandler func(event StackEvent) // Strict mode is on if this is set
+}
+
+// Checkpoint is the saved state of the address stack
+type Checkpoint struct {
+	Regs         []uint64
+	StackPointer int
+	Levels       int
+	DrivingBus   bool
+}
+
+// State is a snapshot of the address stack
+type State struct {
+	// The registers, labeled with their stack level. The program counter is selected
+	Regs         []common.RegisterState
+	StackPointer int
+	DrivingBus   bool
 }
 
 func (s *AddressStack) Init(dataBus *common.Bus, width int, depth int) {
-	s.pc.Init(nil, width, "PC    ")
-	s.stack = make([]common.Register, depth)
-	for i := 0; i < depth; i++ {
-		s.stack[i].Init(nil, width, "Level "+string(1))
+	s.regs = make([]common.Register, depth+1)
+	for i := range s.regs {
+		s.regs[i].Init(nil, width, "")
 	}
 	s.width = width
 	for i := 0; i < width; i++ {
@@ -29,20 +62,48 @@ func (s *AddressStack) Init(dataBus *common.Bus, width int, depth int) {
 		s.mask = s.mask | 1
 	}
 
-	s.stackPointer = 0
 	s.dataBus = dataBus
-	s.pc.WriteDirect(0)
+	s.Reset()
+}
+
+// Reset clears the program counter and all the stack levels
+func (s *AddressStack) Reset() {
+	for i := range s.regs {
+		s.regs[i].WriteDirect(0)
+	}
+	s.stackPointer = 0
+	s.levels = 0
+}
+
+// SetStrictMode reports stack overflows and underflows to the handler.
+// A nil handler turns strict mode off
+func (s *AddressStack) SetStrictMode(handler func(event StackEvent)) {
+	s.eventHandler = handler
 }
 
 // GetProgramCounter is for debugging
 func (s *AddressStack) GetProgramCounter() uint64 {
-	return s.pc.Reg
+	return s.pc().Reg
+}
+
+// GetStackPointer returns which register is the program counter
+func (s *AddressStack) GetStackPointer() int {
+	return s.stackPointer
+}
+
+func (s *AddressStack) pc() *common.Register {
+	return &s.regs[s.stackPointer]
+}
+
+// DriverName returns the name for bus collision reports
+func (s *AddressStack) DriverName() string {
+	return "PC"
 }
 
 // ReadProgramCounter reads the program counter one nybble at a time
 func (s *AddressStack) ReadProgramCounter(nybble uint64) {
-	value := s.pc.Reg >> (nybble * 4) & 0xf
-	s.dataBus.Write(value)
+	value := s.pc().Reg >> (nybble * 4) & 0xf
+	s.dataBus.Write(s, value)
 	s.drivingBus = true
 }
 
@@ -50,8 +111,8 @@ func (s *AddressStack) ReadProgramCounter(nybble uint64) {
 func (s *AddressStack) WriteProgramCounterDirect(nybble uint64, in uint64) {
 	var mask uint64
 	mask = 0xf << (nybble * 4)
-	value := ((s.pc.Reg & ^mask) | (in << (nybble * 4) & mask)) & s.mask
-	s.pc.WriteDirect(value)
+	value := ((s.pc().Reg & ^mask) | (in << (nybble * 4) & mask)) & s.mask
+	s.pc().WriteDirect(value)
 	rlog.Debugf("AddressStack: Direct Wrote program counter nybble %d. New value=%03X", nybble, value)
 }
 
@@ -60,32 +121,111 @@ func (s *AddressStack) WriteProgramCounter(nybble uint64) {
 	busValue := s.dataBus.Read()
 	var mask uint64
 	mask = 0xf << (nybble * 4)
-	value := ((s.pc.Reg & ^mask) | (busValue << (nybble * 4) & mask)) & s.mask
-	s.pc.WriteDirect(value)
+	value := ((s.pc().Reg & ^mask) | (busValue << (nybble * 4) & mask)) & s.mask
+	s.pc().WriteDirect(value)
 	rlog.Debugf("AddressStack: Wrote program counter nybble %d. New value=%03X", nybble, value)
 }
 
 // IncProgramCounter increments the program counter
 func (s *AddressStack) IncProgramCounter() {
-	s.pc.Increment()
+	s.pc().Increment()
 }
 
+// StackPush leaves the current address in its register and moves to the next one.
+// The new program counter starts with the same address, until the jump address is loaded
 func (s *AddressStack) StackPush() {
-	if s.stackPointer == len(s.stack) {
-		rlog.Warn("Stack overflow")
-		return
+	addr := s.pc().Reg
+	s.stackPointer = (s.stackPointer + 1) % len(s.regs)
+	s.pc().WriteDirect(addr)
+	rlog.Infof("Stack PUSH: SP=%d (post), PC=%03X", s.stackPointer, addr)
+	if s.levels == len(s.regs)-1 {
+		s.report(EventOverflow, "Stack overflow")
+	} else {
+		s.levels++
 	}
-	rlog.Infof("Stack PUSH: SP=%d (pre), PC=%03X", s.stackPointer, s.pc.Reg)
-	s.stack[s.stackPointer].WriteDirect(s.pc.Reg)
-	s.stackPointer++
 }
 
+// StackPop moves back to the previous register, which contains the return address
 func (s *AddressStack) StackPop() {
-	if s.stackPointer == 0 {
-		rlog.Warn("Stack underflow")
+	s.stackPointer = (s.stackPointer + len(s.regs) - 1) % len(s.regs)
+	rlog.Infof("Stack POP: SP=%d (post), PC=%03X", s.stackPointer, s.pc().Reg)
+	if s.levels == 0 {
+		s.report(EventUnderflow, "Stack underflow")
+	} else {
+		s.levels--
+	}
+}
+
+// report sends an event in strict mode. Otherwise, the stack just wraps around
+// like the real CPU
+func (s *AddressStack) report(eventType int, msg string) {
+	if s.eventHandler == nil {
+		rlog.Debugf("%s: SP=%d, PC=%03X", msg, s.stackPointer, s.pc().Reg)
 		return
 	}
-	s.pc.WriteDirect(s.stack[s.stackPointer].ReadDirect())
-	rlog.Infof("Stack POP: SP=%d (pre), PC=%03X", s.stackPointer, s.pc.Reg)
-	s.stackPointer--
+	rlog.Warnf("%s: SP=%d, PC=%03X", msg, s.stackPointer, s.pc().Reg)
+	s.eventHandler(StackEvent{eventType, s.pc().Reg})
+}
+
+// State returns a snapshot of the address stack. The registers are labeled
+// with their stack level, since the program counter moves around the register file
+func (s *AddressStack) State() State {
+	state := State{
+		Regs:         make([]common.RegisterState, len(s.regs)),
+		StackPointer: s.stackPointer,
+		DrivingBus:   s.drivingBus,
+	}
+	for i := range s.regs {
+		level := (s.stackPointer - i + len(s.regs)) % len(s.regs)
+		reg := s.regs[i].State()
+		if level == 0 {
+			reg.Name = "PC    "
+		} else {
+			reg.Name = fmt.Sprintf("Level %d ", level)
+		}
+		reg.Selected = level == 0
+		state.Regs[i] = reg
+	}
+	return state
+}
+
+// Checkpoint returns the state of the address stack, to restore it later
+func (s *AddressStack) Checkpoint() Checkpoint {
+	c := Checkpoint{
+		Regs:         make([]uint64, len(s.regs)),
+		StackPointer: s.stackPointer,
+		Levels:       s.levels,
+		DrivingBus:   s.drivingBus,
+	}
+	for i := range s.regs {
+		c.Regs[i] = s.regs[i].ReadDirect()
+	}
+	return c
+}
+
+// CheckRestore returns the error Restore would return, without changing anything
+func (s *AddressStack) CheckRestore(c Checkpoint) error {
+	if len(c.Regs) != len(s.regs) {
+		return fmt.Errorf("address stack: checkpoint has %d registers, expected %d", len(c.Regs), len(s.regs))
+	}
+	return nil
+}
+
+// Restore puts the address stack back in a saved state
+func (s *AddressStack) Restore(c Checkpoint) error {
+	if err := s.CheckRestore(c); err != nil {
+		return err
+	}
+	for i := range s.regs {
+		s.regs[i].WriteDirect(c.Regs[i])
+	}
+	s.stackPointer = c.StackPointer
+	s.levels = c.Levels
+	s.drivingBus = c.DrivingBus
+	return nil
+}
+
+// ClearDrivingBus is called at the start of each clock
+func (s *AddressStack) ClearDrivingBus() {
+	s.drivingBus = false
 }
@@ -10,7 +10,6 @@ import (
 
 // Renderer contains all the rendering code of our address stack
 type Renderer struct {
-	as     *AddressStack
 	dirty  int
 	bounds image.Rectangle
 
@@ -19,9 +18,8 @@ type Renderer struct {
 	registerRenderers []common.RegisterRenderer
 }
 
-// InitRender Initializes the renderer
-func (r *Renderer) InitRender(as *AddressStack, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.as = as
+// InitRender Initializes the renderer for a stack of numRegs registers, including the program counter
+func (r *Renderer) InitRender(numRegs int, canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 
@@ -29,19 +27,15 @@ func (r *Renderer) InitRender(as *AddressStack, canvas *canvas.Canvas, bounds im
 	busHeight := 80
 	busWidth := 20
 
-	r.dataBusRenderer.InitRender(r.as.dataBus,
+	r.dataBusRenderer.InitRender(
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Min.Y},
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Min.Y + busHeight},
 		busWidth)
 
-	r.registerRenderers = make([]common.RegisterRenderer, len(as.stack)+1)
+	r.registerRenderers = make([]common.RegisterRenderer, numRegs)
 
 	for i := range r.registerRenderers {
-		reg := &r.as.pc
-		if i > 0 {
-			reg = &r.as.stack[i-1]
-		}
-		r.registerRenderers[i].InitRender(reg, image.Rectangle{
+		r.registerRenderers[i].InitRender(image.Rectangle{
 			image.Point{r.bounds.Min.X, (i)*int(css.RegisterHeight) + r.bounds.Min.Y + busHeight},
 			image.Point{r.bounds.Max.X,
 				(i+1)*int(css.RegisterHeight) + r.bounds.Min.Y + busHeight},
@@ -49,14 +43,12 @@ func (r *Renderer) InitRender(as *AddressStack, canvas *canvas.Canvas, bounds im
 	}
 }
 
-// Render the contents to the screen
-func (r *Renderer) Render(canvas *canvas.Canvas) {
-
-	r.dataBusRenderer.DrivingBus = &r.as.drivingBus
-	r.dataBusRenderer.Render(canvas)
+// Render the state to the screen. The data bus is the internal bus of the CPU
+func (r *Renderer) Render(canvas *canvas.Canvas, state State, dataBus common.BusState) {
+	r.dataBusRenderer.Render(canvas, dataBus, state.DrivingBus)
 
 	for i := range r.registerRenderers {
-		r.registerRenderers[i].Render(canvas)
+		r.registerRenderers[i].Render(canvas, state.Regs[i])
 	}
 
 	canvas.SetFillStyle("#000")
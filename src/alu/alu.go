@@ -16,11 +16,7 @@ type Alu struct {
 	dataBus         *common.Bus
 	width           int
 	mask            uint64
-	accBus          common.Bus
-	tempBus         common.Bus
-	flagBus         common.Bus
-	coreBus         common.Bus
-	accumDrivingBus bool
+	accumDrivingBus bool // The registers drove the data bus during this clock
 	tempDrivingBus  bool
 	flagDrivingBus  bool
 	coreDrivingBus  bool
@@ -29,6 +25,35 @@ type Alu struct {
 const FlagPosZero = uint64(0x2)
 const FlagPosCarry = uint64(0x4)
 
+// State is a snapshot of the ALU
+type State struct {
+	Accumulator common.RegisterState
+	Temp        common.RegisterState
+	Flags       common.RegisterState
+	Mode        string // The operation of the ALU core, like "+"
+
+	AccumDrivingBus bool
+	TempDrivingBus  bool
+	FlagDrivingBus  bool
+	CoreDrivingBus  bool
+}
+
+// Checkpoint is the saved state of the ALU
+type Checkpoint struct {
+	Accumulator uint64
+	Temp        uint64
+	Flags       uint64
+	Output      uint64 // The output register of the ALU core
+	Carry       uint64 // The carry of the ALU core
+	Mode        string
+	RamBank     uint64
+
+	AccumDrivingBus bool
+	TempDrivingBus  bool
+	FlagDrivingBus  bool
+	CoreDrivingBus  bool
+}
+
 type AluFlags struct {
 	Zero  int // The accumulator is zero
 	Carry int // The carry bit is set
@@ -38,9 +63,13 @@ type AluFlags struct {
 const AluIntModeNone = 0
 const AluIntModeAdd = 1
 const AluIntModeSub = 2
+const AluIntModeOr = 3  // 4040 only
+const AluIntModeAnd = 4 // 4040 only
 
 const AluAdd = "+"
 const AluSub = "-"
+const AluOr = "|"
+const AluAnd = "&"
 const AluNone = ""
 
 // Accumulator instructions
@@ -62,6 +91,10 @@ const DCL = 0xD // Designate command line
 var instStrings = []string{"CLB", "CLC", "IAC", "CMC", "CMA", "RAL", "RAR", "TCC", "DAC", "TCS", "STC", "DAA", "KBP", "DCL"}
 
 func accInstToString(inst uint64) string {
+	if inst >= uint64(len(instStrings)) {
+		// 0xFE and 0xFF are not used, and do nothing
+		return "NOP"
+	}
 	return instStrings[inst]
 
 }
@@ -81,12 +114,83 @@ func (a *Alu) Init(dataBus *common.Bus, width int) {
 	a.updateFlags()
 }
 
+// Reset clears the accumulator, carry and the RAM bank selected by DCL
+func (a *Alu) Reset() {
+	a.accumulator.WriteDirect(0)
+	a.tempRegister.WriteDirect(0)
+	a.aluCore.SetCarry(0)
+	a.aluCore.SetMode(AluNone)
+	a.currentRamBank = 0
+	a.updateFlags()
+}
+
 func (a *Alu) WriteAccumulator() {
 	rlog.Debugf("Wrote Accumulator with 0x%X", a.dataBus.Read())
 	a.accumulator.Write()
 	a.updateFlags()
 }
 
+// WriteAccumulatorDirect writes the accumulator without using the bus
+func (a *Alu) WriteAccumulatorDirect(value uint64) {
+	rlog.Debugf("Direct wrote Accumulator with 0x%X", value)
+	a.accumulator.WriteDirect(value)
+	a.updateFlags()
+}
+
+// State returns a snapshot of the ALU
+func (a *Alu) State() State {
+	return State{
+		Accumulator:     a.accumulator.State(),
+		Temp:            a.tempRegister.State(),
+		Flags:           a.flagRegister.State(),
+		Mode:            a.aluCore.mode,
+		AccumDrivingBus: a.accumDrivingBus,
+		TempDrivingBus:  a.tempDrivingBus,
+		FlagDrivingBus:  a.flagDrivingBus,
+		CoreDrivingBus:  a.coreDrivingBus,
+	}
+}
+
+// Checkpoint returns the state of the ALU, to restore it later
+func (a *Alu) Checkpoint() Checkpoint {
+	return Checkpoint{
+		Accumulator:     a.accumulator.ReadDirect(),
+		Temp:            a.tempRegister.ReadDirect(),
+		Flags:           a.flagRegister.ReadDirect(),
+		Output:          a.aluCore.outputReg.ReadDirect(),
+		Carry:           a.aluCore.Carry,
+		Mode:            a.aluCore.mode,
+		RamBank:         a.currentRamBank,
+		AccumDrivingBus: a.accumDrivingBus,
+		TempDrivingBus:  a.tempDrivingBus,
+		FlagDrivingBus:  a.flagDrivingBus,
+		CoreDrivingBus:  a.coreDrivingBus,
+	}
+}
+
+// Restore puts the ALU back in a saved state
+func (a *Alu) Restore(c Checkpoint) {
+	a.accumulator.WriteDirect(c.Accumulator)
+	a.tempRegister.WriteDirect(c.Temp)
+	a.flagRegister.WriteDirect(c.Flags)
+	a.aluCore.outputReg.WriteDirect(c.Output)
+	a.aluCore.Carry = c.Carry
+	a.aluCore.mode = c.Mode
+	a.currentRamBank = c.RamBank
+	a.accumDrivingBus = c.AccumDrivingBus
+	a.tempDrivingBus = c.TempDrivingBus
+	a.flagDrivingBus = c.FlagDrivingBus
+	a.coreDrivingBus = c.CoreDrivingBus
+}
+
+// ClearDrivingBus is called at the start of each clock
+func (a *Alu) ClearDrivingBus() {
+	a.accumDrivingBus = false
+	a.tempDrivingBus = false
+	a.flagDrivingBus = false
+	a.coreDrivingBus = false
+}
+
 func (a *Alu) ReadAccumulator() {
 	a.accumulator.Read()
 	a.accumDrivingBus = true
@@ -124,6 +228,10 @@ func (a *Alu) SetMode(mode int) {
 		a.aluCore.SetMode(AluAdd)
 	case AluIntModeSub:
 		a.aluCore.SetMode(AluSub)
+	case AluIntModeOr:
+		a.aluCore.SetMode(AluOr)
+	case AluIntModeAnd:
+		a.aluCore.SetMode(AluAnd)
 	default:
 		rlog.Warnf("** Invalid ALU mode %d", mode)
 	}
@@ -154,6 +262,11 @@ func (a *Alu) GetCurrentRamBank() uint64 {
 	return a.currentRamBank
 }
 
+// SetCurrentRamBank restores the RAM bank selected by DCL
+func (a *Alu) SetCurrentRamBank(bank uint64) {
+	a.currentRamBank = bank & 0x7
+}
+
 func (a *Alu) updateFlags() {
 	accum := a.accumulator.ReadDirect()
 	flags := a.flagRegister.ReadDirect()
@@ -182,9 +295,10 @@ func (a *Alu) ExectuteAccInst(inst uint64) {
 	case CLC:
 		a.aluCore.SetCarry(0)
 	case IAC:
-		a.aluCore.SetMode(AluAdd)
-		a.aluCore.Evaluate(a.accumulator.ReadDirect(), 1)
-		a.accumulator.WriteDirect(a.aluCore.ReadOutputDirect())
+		// The carry is set on overflow
+		accum, carry := a.aluCore.add(a.accumulator.ReadDirect(), 1, 0)
+		a.accumulator.WriteDirect(accum)
+		a.aluCore.SetCarry(carry)
 	case CMC:
 		a.aluCore.ComplimentCarry()
 	case CMA:
@@ -225,9 +339,18 @@ func (a *Alu) ExectuteAccInst(inst uint64) {
 		}
 		a.aluCore.SetCarry(0)
 	case DAC:
-		a.aluCore.SetMode(AluSub)
-		a.aluCore.Evaluate(a.accumulator.ReadDirect(), 1)
-		a.accumulator.WriteDirect(a.aluCore.ReadOutputDirect())
+		// Add 0xF. The carry is set if there was no borrow
+		accum, carry := a.aluCore.add(a.accumulator.ReadDirect(), a.mask, 0)
+		a.accumulator.WriteDirect(accum)
+		a.aluCore.SetCarry(carry)
+	case TCS:
+		// Used in decimal subtraction. 9 without a carry, 10 with a carry
+		if a.GetFlags().Carry != 0 {
+			a.accumulator.WriteDirect(10)
+		} else {
+			a.accumulator.WriteDirect(9)
+		}
+		a.aluCore.SetCarry(0)
 	case STC:
 		a.aluCore.SetCarry(1)
 	case DAA:
@@ -274,7 +397,6 @@ type aluCore struct {
 	mask      uint64
 	carryMask uint64 // Which bit position means a carry
 	mode      string
-	changed   bool
 }
 
 // Init initialize the ALU
@@ -288,7 +410,6 @@ func (a *aluCore) Init(dataBus *common.Bus, width int) {
 	a.carryMask = 1 << uint64(width)
 	a.outputReg.Init(dataBus, width, "ALU=")
 	a.mode = AluNone
-	a.changed = true // force a render
 }
 
 func (a *aluCore) ReadOutput() {
@@ -313,32 +434,35 @@ func (a *aluCore) ComplimentCarry() {
 
 func (a *aluCore) SetMode(mode string) {
 	a.mode = mode
-	a.changed = true
 	rlog.Debugf("** ALU: Set mode to %s", mode)
 }
 
+// add returns the sum of the inputs and the carry out
+func (a *aluCore) add(accIn uint64, tmpIn uint64, carryIn uint64) (out uint64, carry uint64) {
+	out = (accIn & a.mask) + (tmpIn & a.mask) + (carryIn & 0x1)
+	if (out & a.carryMask) != 0 {
+		carry = 1
+	}
+	return out & a.mask, carry
+}
+
 func (a *aluCore) Evaluate(accIn uint64, tmpIn uint64) {
 	out := accIn
 	prevCarry := a.Carry
 	switch a.mode {
 	case AluAdd:
-		out = accIn + tmpIn
-		if (out & a.carryMask) != 0 {
-			a.Carry = 1
-		} else {
-			a.Carry = 0
-		}
+		// The carry is added in
+		out, a.Carry = a.add(accIn, tmpIn, prevCarry)
 	case AluSub:
-		// We set the carry bit to indicate NO borrow
-		if tmpIn > accIn {
-			a.Carry = 0
-		} else {
-			a.Carry = 1
-		}
-		out = accIn - tmpIn
-		if prevCarry != 0 {
-			out++
-		}
+		// The 4004 adds the complement of the operand and the inverted carry.
+		// The carry is set to indicate NO borrow, but on the way in, a set carry
+		// means the previous digit had a borrow
+		out, a.Carry = a.add(accIn, ^tmpIn, ^prevCarry)
+	case AluOr:
+		// The logic operations do not affect the carry
+		out = accIn | tmpIn
+	case AluAnd:
+		out = accIn & tmpIn
 	}
 	out = out & a.mask
 	a.outputReg.WriteDirect(out)
@@ -20,9 +20,11 @@ func SetupLogger() {
 	}
 }
 
+const testDriver = common.NamedDriver("Test")
+
 func writeBus(val uint64, bus *common.Bus, t *testing.T) {
 	bus.Reset()
-	bus.Write(val)
+	bus.Write(testDriver, val)
 	bus.Reset()
 }
 
@@ -159,10 +161,11 @@ func TestSub(t *testing.T) {
 	flagsVal = FlagPosCarry
 	verifyFlags(flagsVal, &alu, &bus, t)
 
-	// Subtract a second time
+	// Subtract a second time. The carry is set, which the 4004 treats as a
+	// borrow from the previous digit, so one more is subtracted
 	alu.Evaluate()
 	alu.ReadEval()
-	expVal = (accumVal - tempVal - tempVal) & 0xF
+	expVal = (accumVal - tempVal - tempVal - 1) & 0xF
 	// Write this back to the accumulator
 	alu.WriteAccumulator()
 
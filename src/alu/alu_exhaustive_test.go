@@ -0,0 +1,134 @@
+package alu
+
+import (
+	"common"
+	"testing"
+)
+
+// These tests check every accumulator/operand/carry combination against the
+// 4004 definitions of the instructions
+
+func setupExhaustive(acc uint64, carry uint64) (*Alu, *common.Bus) {
+	alu := &Alu{}
+	bus := &common.Bus{}
+	bus.Init(4, "Test Bus")
+	alu.Init(bus, 4)
+	writeBus(acc, bus, nil)
+	alu.WriteAccumulator()
+	alu.aluCore.SetCarry(carry)
+	alu.updateFlags()
+	return alu, bus
+}
+
+func verifyResult(name string, acc uint64, op uint64, carry uint64, alu *Alu, expAcc uint64, expCarry uint64, t *testing.T) {
+	gotAcc := alu.ReadAccumulatorDirect()
+	flags := alu.GetFlags()
+	if gotAcc != expAcc || uint64(flags.Carry) != expCarry {
+		t.Errorf("%s: A=%X, op=%X, C=%d. Exp A=%X, C=%d. Got A=%X, C=%d",
+			name, acc, op, carry, expAcc, expCarry, gotAcc, flags.Carry)
+	}
+	expZero := 0
+	if expAcc == 0 {
+		expZero = 1
+	}
+	if flags.Zero != expZero {
+		t.Errorf("%s: A=%X, op=%X, C=%d. Zero flag mismatch. Exp %d, got %d",
+			name, acc, op, carry, expZero, flags.Zero)
+	}
+}
+
+func evaluate(mode int, acc uint64, op uint64, carry uint64) *Alu {
+	alu, bus := setupExhaustive(acc, carry)
+	writeBus(op, bus, nil)
+	alu.WriteTemp()
+	alu.SetMode(mode)
+	alu.Evaluate()
+	bus.Reset()
+	alu.ReadEval()
+	alu.WriteAccumulator()
+	return alu
+}
+
+func TestAddExhaustive(t *testing.T) {
+	for acc := uint64(0); acc < 16; acc++ {
+		for op := uint64(0); op < 16; op++ {
+			for carry := uint64(0); carry < 2; carry++ {
+				// A = A + R + C. The carry is set on overflow
+				sum := acc + op + carry
+				expCarry := uint64(0)
+				if sum > 15 {
+					expCarry = 1
+				}
+				alu := evaluate(AluIntModeAdd, acc, op, carry)
+				verifyResult("ADD", acc, op, carry, alu, sum&0xf, expCarry, t)
+			}
+		}
+	}
+}
+
+func TestSubExhaustive(t *testing.T) {
+	for acc := uint64(0); acc < 16; acc++ {
+		for op := uint64(0); op < 16; op++ {
+			for carry := uint64(0); carry < 2; carry++ {
+				// A = A + ~R + ~C, which is A - R - C.
+				// The carry is set if there was no borrow
+				diff := int(acc) - int(op) - int(carry)
+				expCarry := uint64(0)
+				if diff >= 0 {
+					expCarry = 1
+				}
+				alu := evaluate(AluIntModeSub, acc, op, carry)
+				verifyResult("SUB", acc, op, carry, alu, uint64(diff)&0xf, expCarry, t)
+			}
+		}
+	}
+}
+
+func TestAccInstExhaustive(t *testing.T) {
+	kbp := []uint64{0, 1, 2, 0xf, 3, 0xf, 0xf, 0xf, 4, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf, 0xf}
+	for acc := uint64(0); acc < 16; acc++ {
+		for carry := uint64(0); carry < 2; carry++ {
+			// IAC: the carry is set on overflow
+			alu, _ := setupExhaustive(acc, carry)
+			alu.ExectuteAccInst(IAC)
+			expCarry := uint64(0)
+			if acc == 0xf {
+				expCarry = 1
+			}
+			verifyResult("IAC", acc, 0, carry, alu, (acc+1)&0xf, expCarry, t)
+
+			// DAC: the carry is set if there was no borrow
+			alu, _ = setupExhaustive(acc, carry)
+			alu.ExectuteAccInst(DAC)
+			expCarry = 0
+			if acc != 0 {
+				expCarry = 1
+			}
+			verifyResult("DAC", acc, 0, carry, alu, (acc-1)&0xf, expCarry, t)
+
+			// TCS: 9 or 10 depending on the carry, which is cleared
+			alu, _ = setupExhaustive(acc, carry)
+			alu.ExectuteAccInst(TCS)
+			verifyResult("TCS", acc, 0, carry, alu, 9+carry, 0, t)
+
+			// DAA: add 6 if the accumulator is over 9 or the carry is set.
+			// The carry is only ever set, never cleared
+			alu, _ = setupExhaustive(acc, carry)
+			alu.ExectuteAccInst(DAA)
+			expAcc := acc
+			expCarry = carry
+			if acc > 9 || carry != 0 {
+				expAcc = (acc + 6) & 0xf
+				if acc+6 > 15 {
+					expCarry = 1
+				}
+			}
+			verifyResult("DAA", acc, 0, carry, alu, expAcc, expCarry, t)
+
+			// KBP: the carry is not affected
+			alu, _ = setupExhaustive(acc, carry)
+			alu.ExectuteAccInst(KBP)
+			verifyResult("KBP", acc, 0, carry, alu, kbp[acc], carry, t)
+		}
+	}
+}
@@ -10,7 +10,6 @@ import (
 
 // Renderer contains all the rendering code of our cpu
 type Renderer struct {
-	alu    *Alu
 	dirty  int
 	bounds image.Rectangle
 
@@ -28,8 +27,7 @@ type Renderer struct {
 }
 
 // InitRender Initializes the renderer
-func (r *Renderer) InitRender(alu *Alu, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.alu = alu
+func (r *Renderer) InitRender(canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 
@@ -44,93 +42,84 @@ func (r *Renderer) InitRender(alu *Alu, canvas *canvas.Canvas, bounds image.Rect
 	aluCoreW := 125
 	aluCoreH := 150
 
-	r.accumulatorDataBusRenderer.InitRender(&r.alu.accBus,
-		image.Point{int(css.RegisterWidth/2) + accRegX + r.bounds.Min.X, r.bounds.Min.Y},
+	r.accumulatorDataBusRenderer.InitRender(image.Point{int(css.RegisterWidth/2) + accRegX + r.bounds.Min.X, r.bounds.Min.Y},
 		image.Point{int(css.RegisterWidth/2) + accRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		busWidth)
 
-	r.tempDataBusRenderer.InitRender(&r.alu.tempBus,
-		image.Point{int(css.RegisterWidth/2) + tmpRegX + r.bounds.Min.X, r.bounds.Min.Y},
+	r.tempDataBusRenderer.InitRender(image.Point{int(css.RegisterWidth/2) + tmpRegX + r.bounds.Min.X, r.bounds.Min.Y},
 		image.Point{int(css.RegisterWidth/2) + tmpRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		busWidth)
 
-	r.flagDataBusRenderer.InitRender(&r.alu.flagBus,
-		image.Point{int(css.RegisterWidth/2) + flagRegX + r.bounds.Min.X, r.bounds.Min.Y},
+	r.flagDataBusRenderer.InitRender(image.Point{int(css.RegisterWidth/2) + flagRegX + r.bounds.Min.X, r.bounds.Min.Y},
 		image.Point{int(css.RegisterWidth/2) + flagRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		busWidth)
 
-	r.accumulatorRenderer.InitRender(&r.alu.accumulator, image.Rectangle{
+	r.accumulatorRenderer.InitRender(image.Rectangle{
 		image.Point{accRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		image.Point{accRegX + r.bounds.Min.X + int(css.RegisterWidth), r.bounds.Min.Y + busHeight + int(css.RegisterHeight)}})
 
-	r.tempRenderer.InitRender(&r.alu.tempRegister, image.Rectangle{
+	r.tempRenderer.InitRender(image.Rectangle{
 		image.Point{tmpRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		image.Point{tmpRegX + r.bounds.Min.X + int(css.RegisterWidth), r.bounds.Min.Y + busHeight + int(css.RegisterHeight)}})
 
-	r.flagRenderer.InitRender(&r.alu.flagRegister, image.Rectangle{
+	r.flagRenderer.InitRender(image.Rectangle{
 		image.Point{flagRegX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		image.Point{flagRegX + r.bounds.Min.X + int(css.RegisterWidth), r.bounds.Min.Y + busHeight + int(css.RegisterHeight)}})
 
-	r.aluCoreRenderer.InitRender(&r.alu.aluCore, canvas, image.Rectangle{
+	r.aluCoreRenderer.InitRender(canvas, image.Rectangle{
 		image.Point{aluCoreX + r.bounds.Min.X, r.bounds.Min.Y + aluCoreY},
 		image.Point{aluCoreX + r.bounds.Min.X + aluCoreW, r.bounds.Min.Y + aluCoreY + aluCoreH}})
 
 	coreBusTop := r.bounds.Min.Y + busHeight + int(css.RegisterHeight)
-	r.accumCoreDataBusRenderer.InitRender(&r.alu.accBus,
-		image.Point{int(css.RegisterWidth/2) + accRegX + r.bounds.Min.X, coreBusTop},
+	r.accumCoreDataBusRenderer.InitRender(image.Point{int(css.RegisterWidth/2) + accRegX + r.bounds.Min.X, coreBusTop},
 		image.Point{aluCoreX + r.bounds.Min.X, r.bounds.Min.Y + aluCoreY + int(float64(aluCoreH)*0.80)},
 		busWidth)
 	r.accumCoreDataBusRenderer.NoStartArrow = true
 
-	r.tempCoreDataBusRenderer.InitRender(&r.alu.tempBus,
-		image.Point{int(css.RegisterWidth/2) + tmpRegX + r.bounds.Min.X, coreBusTop},
+	r.tempCoreDataBusRenderer.InitRender(image.Point{int(css.RegisterWidth/2) + tmpRegX + r.bounds.Min.X, coreBusTop},
 		image.Point{aluCoreX + r.bounds.Min.X, r.bounds.Min.Y + aluCoreY + int(float64(aluCoreH)*0.20)},
 		busWidth)
 	r.tempCoreDataBusRenderer.NoStartArrow = true
 
-	r.aluCoreDataBusRenderer.InitRender(&r.alu.tempBus,
-		image.Point{aluCoreX + r.bounds.Min.X + aluCoreW, r.bounds.Min.Y + aluCoreY + aluCoreH/2},
+	r.aluCoreDataBusRenderer.InitRender(image.Point{aluCoreX + r.bounds.Min.X + aluCoreW, r.bounds.Min.Y + aluCoreY + aluCoreH/2},
 		image.Point{r.bounds.Max.X, r.bounds.Min.Y},
 		busWidth)
 	r.aluCoreDataBusRenderer.NoStartArrow = true
 }
 
-// Render the contents to the screen
-func (r *Renderer) Render(canvas *canvas.Canvas) {
-	r.accumulatorDataBusRenderer.DrivingBus = &r.alu.accumDrivingBus
-	r.tempDataBusRenderer.DrivingBus = &r.alu.tempDrivingBus
-	r.flagDataBusRenderer.DrivingBus = &r.alu.flagDrivingBus
-	r.aluCoreDataBusRenderer.DrivingBus = &r.alu.coreDrivingBus
-	r.accumulatorDataBusRenderer.Render(canvas)
-	r.tempDataBusRenderer.Render(canvas)
-	r.flagDataBusRenderer.Render(canvas)
-	r.accumulatorRenderer.Render(canvas)
-	r.tempRenderer.Render(canvas)
-	r.flagRenderer.Render(canvas)
-	r.aluCoreRenderer.Render(canvas)
-	r.accumCoreDataBusRenderer.Render(canvas)
-	r.tempCoreDataBusRenderer.Render(canvas)
-	r.aluCoreDataBusRenderer.Render(canvas)
+// Render the state to the screen. The buses inside the ALU are not modeled,
+// so they only show which register drove the data bus
+func (r *Renderer) Render(canvas *canvas.Canvas, state State) {
+	r.accumulatorDataBusRenderer.Render(canvas, common.BusState{}, state.AccumDrivingBus)
+	r.tempDataBusRenderer.Render(canvas, common.BusState{}, state.TempDrivingBus)
+	r.flagDataBusRenderer.Render(canvas, common.BusState{}, state.FlagDrivingBus)
+	r.accumulatorRenderer.Render(canvas, state.Accumulator)
+	r.tempRenderer.Render(canvas, state.Temp)
+	r.flagRenderer.Render(canvas, state.Flags)
+	r.aluCoreRenderer.Render(canvas, state.Mode)
+	r.accumCoreDataBusRenderer.Render(canvas, common.BusState{}, false)
+	r.tempCoreDataBusRenderer.Render(canvas, common.BusState{}, false)
+	r.aluCoreDataBusRenderer.Render(canvas, common.BusState{}, state.CoreDrivingBus)
 }
 
 // CoreRenderer contains all the rendering code of our cpu
 type CoreRenderer struct {
-	aluCore *aluCore
-	bounds  image.Rectangle
-	dirty   int // if non-zero, render
+	bounds image.Rectangle
+	dirty  int    // if non-zero, render
+	mode   string // The mode rendered last
 }
 
 // InitRender Initializes the renderer
-func (r *CoreRenderer) InitRender(aluCore *aluCore, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.aluCore = aluCore
+func (r *CoreRenderer) InitRender(canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
+	r.dirty = 2
 }
 
-// Render the contents to the screen
-func (r *CoreRenderer) Render(canvas *canvas.Canvas) {
-	if r.aluCore.changed {
+// Render the mode of the ALU core to the screen
+func (r *CoreRenderer) Render(canvas *canvas.Canvas, mode string) {
+	if mode != r.mode {
 		r.dirty = 2
-		r.aluCore.changed = false
+		r.mode = mode
 	}
 	if r.dirty == 0 {
 		return
@@ -153,6 +142,6 @@ func (r *CoreRenderer) Render(canvas *canvas.Canvas) {
 	canvas.Fill()
 
 	canvas.SetFillStyle(css.RegisterTextNormal)
-	canvas.FillText(r.aluCore.mode, left+width*0.65, top+height*0.5+5)
+	canvas.FillText(mode, left+width*0.65, top+height*0.5+5)
 	r.dirty--
 }
@@ -0,0 +1,358 @@
+package board
+
+import (
+	"clock4201"
+	"common"
+	"cpucore"
+	"events"
+	"fmt"
+	"interfaces"
+	"ram4002"
+	"rom4001"
+	"supportcommon"
+
+	"github.com/romana/rlog"
+)
+
+// RamChipsPerBank is how many 4002s share one CM-RAM line
+const RamChipsPerBank = 4
+
+// MaxRams is how many 4002s can be selected with the CM-RAM decoder
+const MaxRams = cpucore.NumRamBanks * RamChipsPerBank
+
+// RamChip is the position of a 4002 in the system
+type RamChip struct {
+	Bank int // CM-RAM bank (0-7)
+	Chip int // Chip number within the bank (0-3)
+}
+
+// Config describes which chips are on the board
+type Config struct {
+	Model   int       // cpucore.Model4004 or cpucore.Model4040
+	Crystal float64   // Crystal frequency in Hz
+	NumRoms int       // Number of 4001 ROMs (1-16), with chip IDs 0..NumRoms-1
+	NumRams int       // Number of 4002 RAMs (0-32). Chips 0-3 are in bank 0, 4-7 in bank 1 etc.
+	RomIDs  []int     // If not empty, the chip IDs of the ROMs. Overrides NumRoms
+	Rams    []RamChip // If not empty, the positions of the RAMs. Overrides NumRams
+	Float   int       // What reads of the undriven external data bus return (common.FloatHold...)
+}
+
+// DefaultConfig is a 4004 with a full ROM array and one bank of RAM
+func DefaultConfig() Config {
+	return Config{
+		Model:   cpucore.Model4004,
+		Crystal: clock4201.DefaultCrystal,
+		NumRoms: rom4001.MaxChips,
+		NumRams: RamChipsPerBank,
+		Float:   common.FloatPrecharged,
+	}
+}
+
+// Board is a complete MCS-4 system. It owns the CPU, the ROM and RAM chips,
+// the buses between them and the clock which drives them all. The board must
+// not be copied after Init, since the chips point to its buses and lines
+type Board struct {
+	Core       cpucore.Core
+	Roms       rom4001.RomArray
+	Rams       []ram4002.Ram4002
+	RamIOBuses []common.Bus
+	Clock      clock4201.Clock4201
+	// Peripherals added by name, like the ones from a system file
+	Peripherals map[string]interfaces.ClockedElement
+	// Events raised by the chips. Subscribe here to trace or profile the system
+	Events   events.Dispatcher
+	config   Config
+	ramChips []RamChip // The positions of the RAMs
+
+	monitor    common.BusMonitor
+	strict     bool                        // Stop on the first bus collision or undriven read error
+	contention *common.BusCollision        // The collision which stopped a strict board
+	floating   *common.UndrivenRead        // The undriven read error which stopped a strict board
+	observers  []func(common.BusCollision) // Bus collision observers
+	undriven   []func(common.UndrivenRead) // Undriven read observers
+
+	inputObservers []func(in Input) // Input pin observers
+	replaying      bool             // The inputs are replayed, so they are not reported again
+	history        *History         // Records the checkpoints for stepping back. May be nil
+}
+
+// busMonitored is a chip with buses which can be monitored
+type busMonitored interface {
+	SetBusMonitor(m *common.BusMonitor)
+}
+
+// eventSource is a chip which raises events
+type eventSource interface {
+	SetEvents(d *events.Dispatcher)
+}
+
+// Init creates and connects the chips
+func (b *Board) Init(config Config) {
+	b.config = config
+	b.Core.InitModel(config.Model)
+	b.Core.ExternalDataBus.SetFloat(config.Float)
+	b.Peripherals = make(map[string]interfaces.ClockedElement)
+
+	if len(config.RomIDs) > 0 {
+		b.Roms.InitIDs(config.RomIDs, &b.Core.ExternalDataBus, &b.Core.Sync, &b.Core.CmROM)
+	} else {
+		b.Roms.Init(config.NumRoms, &b.Core.ExternalDataBus, &b.Core.Sync, &b.Core.CmROM)
+	}
+	b.Roms.SetResetLine(&b.Core.ResetIn)
+
+	rams := config.Rams
+	if len(rams) == 0 {
+		if config.NumRams < 0 || config.NumRams > MaxRams {
+			panic(fmt.Sprintf("Board: invalid number of RAMs %d", config.NumRams))
+		}
+		for i := 0; i < config.NumRams; i++ {
+			rams = append(rams, RamChip{i / RamChipsPerBank, i % RamChipsPerBank})
+		}
+	}
+	b.ramChips = rams
+	b.Rams = make([]ram4002.Ram4002, len(rams))
+	b.RamIOBuses = make([]common.Bus, len(rams))
+	for i, pos := range rams {
+		if pos.Bank < 0 || pos.Bank >= cpucore.NumRamBanks || pos.Chip < 0 || pos.Chip >= RamChipsPerBank {
+			panic(fmt.Sprintf("Board: invalid RAM bank %d, chip %d", pos.Bank, pos.Chip))
+		}
+		b.RamIOBuses[i].Init(ram4002.BusWidth, fmt.Sprintf("RAM %d.%d output port", pos.Bank, pos.Chip))
+		b.Rams[i].Init(&b.Core.ExternalDataBus, &b.Core.Sync, &b.Core.CmRAMBank[pos.Bank])
+		b.Rams[i].SetChipID(pos.Chip)
+		b.Rams[i].SetIOBus(&b.RamIOBuses[i])
+		b.Rams[i].SetResetLine(&b.Core.ResetIn)
+	}
+
+	b.monitor.Time = b.busTime
+	b.monitor.Handler = b.busCollision
+	b.monitor.Undriven = b.undrivenRead
+	b.Core.SetBusMonitor(&b.monitor)
+	b.Roms.SetBusMonitor(&b.monitor)
+	for i := range b.Rams {
+		b.Rams[i].SetBusMonitor(&b.monitor)
+		b.RamIOBuses[i].SetMonitor(&b.monitor)
+	}
+
+	b.watchInputs()
+
+	b.Events.Clock = b.Clock.GetClocks
+	b.Core.SetEvents(&b.Events)
+	b.Roms.SetEvents(&b.Events)
+	for i := range b.Rams {
+		b.Rams[i].SetEvents(&b.Events)
+		b.RamIOBuses[i].SetEvents(&b.Events)
+	}
+
+	// The clock runs the core before the peripherals in each phase, as it
+	// resets the external data bus
+	b.Clock.Init(config.Crystal)
+	b.Clock.Register(&b.Core)
+	b.Clock.Register(&b.Roms)
+	for i := range b.Rams {
+		b.Clock.Register(&b.Rams[i])
+	}
+}
+
+// GetConfig returns the configuration the board was created with
+func (b *Board) GetConfig() Config {
+	return b.config
+}
+
+// RamChips returns the positions of the RAMs, in the order of Rams
+func (b *Board) RamChips() []RamChip {
+	return b.ramChips
+}
+
+// AddPeripheral connects another chip to the clock. The caller connects its lines.
+// If name is not empty, the chip can be found in Peripherals
+func (b *Board) AddPeripheral(name string, e interfaces.ClockedElement) {
+	if name != "" {
+		b.Peripherals[name] = e
+	}
+	if m, ok := e.(busMonitored); ok {
+		m.SetBusMonitor(&b.monitor)
+	}
+	if s, ok := e.(eventSource); ok {
+		s.SetEvents(&b.Events)
+	}
+	b.Clock.Register(e)
+}
+
+// SetStrict stops the board on the first bus collision, or the first read of an
+// undriven bus in the common.FloatError mode, when enabled. Otherwise they are only logged
+func (b *Board) SetStrict(strict bool) {
+	b.strict = strict
+}
+
+// OnBusCollision subscribes an observer to the bus collisions
+func (b *Board) OnBusCollision(o func(c common.BusCollision)) {
+	b.observers = append(b.observers, o)
+}
+
+// Contention returns the bus collision which stopped a strict board, or nil
+func (b *Board) Contention() *common.BusCollision {
+	return b.contention
+}
+
+// OnUndrivenRead subscribes an observer to the reads of undriven buses
+func (b *Board) OnUndrivenRead(o func(u common.UndrivenRead)) {
+	b.undriven = append(b.undriven, o)
+}
+
+// UndrivenFault returns the undriven read which stopped a strict board, or nil
+func (b *Board) UndrivenFault() *common.UndrivenRead {
+	return b.floating
+}
+
+// ClearContention lets a stopped board run again
+func (b *Board) ClearContention() {
+	b.contention = nil
+	b.floating = nil
+}
+
+// stopped returns true if a strict board hit a bus error
+func (b *Board) stopped() bool {
+	return b.contention != nil || b.floating != nil
+}
+
+func (b *Board) busTime() (clock uint64, phase int, phi int) {
+	phi = 1
+	if b.Clock.Phi2 != 0 {
+		phi = 2
+	}
+	return b.Clock.GetClocks(), b.Core.GetClockCount(), phi
+}
+
+func (b *Board) busCollision(c common.BusCollision) {
+	for _, o := range b.observers {
+		o(c)
+	}
+	if b.strict {
+		rlog.Errorf("**** %v. Stopping", c)
+		if b.contention == nil {
+			b.contention = &c
+		}
+		return
+	}
+	rlog.Warnf("**** %v", c)
+}
+
+func (b *Board) undrivenRead(u common.UndrivenRead) {
+	for _, o := range b.undriven {
+		o(u)
+	}
+	if u.Float != common.FloatError {
+		return
+	}
+	if b.strict {
+		rlog.Errorf("**** %v. Stopping", u)
+		if b.floating == nil {
+			b.floating = &u
+		}
+		return
+	}
+	rlog.Errorf("**** %v", u)
+}
+
+// LoadProgram loads a program image into the ROMs
+func (b *Board) LoadProgram(data []uint8) error {
+	return b.Roms.LoadImage(data)
+}
+
+// Reset holds the RESET line long enough to clear the whole system. The 4002
+// memory takes longer to clear than the core
+func (b *Board) Reset() {
+	clocks := cpucore.ResetClocks
+	if b.Core.GetModel() == cpucore.Model4040 {
+		clocks = cpucore.ResetClocks4040
+	}
+	if clocks < supportcommon.RamResetCycles*8 {
+		clocks = supportcommon.RamResetCycles * 8
+	}
+	b.Core.ResetIn.Assert("Board")
+	b.Step(clocks)
+	b.Core.ResetIn.Deassert("Board")
+}
+
+// Step runs a number of clock periods. A strict board stops early on a bus error
+func (b *Board) Step(clocks int) {
+	for i := 0; i < clocks && !b.stopped(); i++ {
+		b.tick()
+	}
+}
+
+// StepInstruction runs until the end of the current instruction, including the
+// second cycle of two cycle instructions. It returns the number of clocks run.
+// A strict board stops early on a bus error
+func (b *Board) StepInstruction() int {
+	clocks := 0
+	for !b.stopped() {
+		b.tick()
+		clocks++
+		if b.AtInstructionBoundary() {
+			break
+		}
+	}
+	return clocks
+}
+
+// tick runs one clock period, and lets the history take its checkpoints
+func (b *Board) tick() {
+	b.Clock.Tick()
+	if b.history != nil {
+		b.history.ticked()
+	}
+}
+
+// AtInstructionBoundary returns true in the last clock of an instruction
+func (b *Board) AtInstructionBoundary() bool {
+	// SYNC is driven in the last clock of each instruction cycle
+	return b.Core.GetClockCount() == 7 && b.Core.Decoder.AtInstructionBoundary()
+}
+
+// Run runs until the until function returns true, checking it after every
+// instruction. It returns the number of clocks run. A strict board stops
+// early on a bus error
+func (b *Board) Run(until func(b *Board) bool) int {
+	clocks := 0
+	for !b.stopped() && !until(b) {
+		clocks += b.StepInstruction()
+	}
+	return clocks
+}
+
+// Snapshot is the state of the whole board between two clocks. It shares
+// nothing with the board, so it can be kept while the simulation runs on
+type Snapshot struct {
+	Clock      uint64 // Clock periods since the board was created
+	Core       cpucore.State
+	Roms       []supportcommon.State
+	Rams       []supportcommon.State
+	RomIOBuses []common.BusState
+	RamIOBuses []common.BusState
+}
+
+// Snapshot returns the state of the board
+func (b *Board) Snapshot() Snapshot {
+	s := Snapshot{
+		Clock:      b.Clock.GetClocks(),
+		Core:       b.Core.State(),
+		Roms:       make([]supportcommon.State, len(b.Roms.Chips)),
+		Rams:       make([]supportcommon.State, len(b.Rams)),
+		RomIOBuses: make([]common.BusState, len(b.Roms.IOBuses)),
+		RamIOBuses: make([]common.BusState, len(b.RamIOBuses)),
+	}
+	for i := range b.Roms.Chips {
+		s.Roms[i] = b.Roms.Chips[i].Core.State()
+	}
+	for i := range b.Rams {
+		s.Rams[i] = b.Rams[i].Core.State()
+	}
+	for i := range b.Roms.IOBuses {
+		s.RomIOBuses[i] = b.Roms.IOBuses[i].State()
+	}
+	for i := range b.RamIOBuses {
+		s.RamIOBuses[i] = b.RamIOBuses[i].State()
+	}
+	return s
+}
@@ -0,0 +1,684 @@
+package board
+
+import (
+	"bytes"
+	"common"
+	"cpucore"
+	"events"
+	"instruction"
+	"os"
+	"reflect"
+	"shift4003"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "board_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+// clockCounter counts how often it is clocked
+type clockCounter struct {
+	clocksIn  int
+	clocksOut int
+}
+
+func (c *clockCounter) Reset() {
+	c.clocksIn = 0
+	c.clocksOut = 0
+}
+
+func (c *clockCounter) ClockIn() {
+	c.clocksIn++
+}
+
+func (c *clockCounter) ClockOut() {
+	c.clocksOut++
+}
+
+// rogueDriver drives the external data bus in every clock
+type rogueDriver struct {
+	bus *common.Bus
+}
+
+func (r *rogueDriver) DriverName() string {
+	return "Rogue"
+}
+
+func (r *rogueDriver) Reset() {
+}
+
+func (r *rogueDriver) ClockIn() {
+}
+
+func (r *rogueDriver) ClockOut() {
+	r.bus.Write(r, 0x5)
+}
+
+func createBoard(config Config, program []uint8) *Board {
+	b := &Board{}
+	b.Init(config)
+	if err := b.LoadProgram(program); err != nil {
+		panic(err)
+	}
+	b.Reset()
+	return b
+}
+
+func TestStepInstruction(t *testing.T) {
+	SetupLogger()
+	program := []uint8{
+		instruction.FIM, 0x12,
+		instruction.NOP,
+		instruction.LDM | 5,
+		instruction.JUN, 0x00,
+	}
+	b := createBoard(DefaultConfig(), program)
+	steps := []struct {
+		clocks int
+		pc     uint64
+	}{{16, 2}, {8, 3}, {8, 4}, {16, 0}, {16, 2}}
+	for i, s := range steps {
+		if clocks := b.StepInstruction(); clocks != s.clocks {
+			t.Errorf("Step %d: clock count mismatch. Exp %d, got %d", i, s.clocks, clocks)
+		}
+		if b.Core.GetProgramCounter() != s.pc {
+			t.Errorf("Step %d: PC mismatch. Exp %X, got %X", i, s.pc, b.Core.GetProgramCounter())
+		}
+	}
+}
+
+func TestRamBanks(t *testing.T) {
+	SetupLogger()
+	program := []uint8{
+		instruction.LDM | 1, instruction.DCL, // RAM bank 1
+		instruction.FIM, 0x40, // Chip 1
+		instruction.SRC,
+		instruction.LDM | 9, instruction.WMP,
+		instruction.JUN, 0x07,
+	}
+	config := DefaultConfig()
+	config.NumRams = 2 * RamChipsPerBank
+	b := createBoard(config, program)
+	instructions := 0
+	b.Run(func(b *Board) bool {
+		instructions++
+		return b.Core.GetProgramCounter() == 0x007 || instructions > 20
+	})
+	for i := range b.RamIOBuses {
+		exp := uint64(0)
+		if i == RamChipsPerBank+1 {
+			exp = 9
+		}
+		if b.RamIOBuses[i].Read() != exp {
+			t.Errorf("RAM %d output mismatch. Exp %X, got %X", i, exp, b.RamIOBuses[i].Read())
+		}
+	}
+}
+
+func TestResetClearsRam(t *testing.T) {
+	SetupLogger()
+	// Read the first RAM character into R2, and then write 7 to it
+	program := []uint8{
+		instruction.FIM, 0x00,
+		instruction.SRC,
+		instruction.RDM,
+		instruction.XCH | 2,
+		instruction.LDM | 7, instruction.WRM,
+		instruction.JUN, 0x00,
+	}
+	b := createBoard(DefaultConfig(), program)
+	afterRead := func(b *Board) bool {
+		return b.Core.GetProgramCounter() == 0x005
+	}
+	readBack := func() uint64 {
+		c, err := b.Checkpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Core.Regs.Regs[2]
+	}
+	b.Run(afterRead)
+	b.StepInstruction()
+	b.Run(afterRead)
+	if v := readBack(); v != 7 {
+		t.Fatalf("RAM write mismatch. Exp 7, got %X", v)
+	}
+	b.Reset()
+	b.Run(afterRead)
+	if v := readBack(); v != 0 {
+		t.Errorf("The reset did not clear the RAM. Exp 0, got %X", v)
+	}
+}
+
+func TestModel4040(t *testing.T) {
+	SetupLogger()
+	config := DefaultConfig()
+	config.Model = cpucore.Model4040
+	config.NumRoms = 1
+	b := createBoard(config, []uint8{instruction.LDM | 0xA, instruction.OR4})
+	b.StepInstruction()
+	b.StepInstruction()
+	if b.Core.GetModel() != cpucore.Model4040 || b.Core.GetProgramCounter() != 2 {
+		t.Errorf("4040 board mismatch. Model=%d, PC=%X", b.Core.GetModel(), b.Core.GetProgramCounter())
+	}
+}
+
+func TestPeripheral(t *testing.T) {
+	SetupLogger()
+	b := createBoard(DefaultConfig(), []uint8{instruction.NOP})
+	c := &clockCounter{}
+	b.AddPeripheral("Counter", c)
+	b.Step(10)
+	if c.clocksIn != 10 || c.clocksOut != 10 {
+		t.Errorf("Peripheral clock mismatch. Exp 10/10, got %d/%d", c.clocksIn, c.clocksOut)
+	}
+}
+
+func TestNoContention(t *testing.T) {
+	SetupLogger()
+	b := createBoard(DefaultConfig(), instruction.LEDCountUsingAdd())
+	b.SetStrict(true)
+	collisions := 0
+	b.OnBusCollision(func(c common.BusCollision) {
+		collisions++
+	})
+	for i := 0; i < 100; i++ {
+		b.StepInstruction()
+	}
+	if b.Contention() != nil || collisions != 0 {
+		t.Errorf("Unexpected bus collision: %v", b.Contention())
+	}
+}
+
+func TestStrictContention(t *testing.T) {
+	SetupLogger()
+	b := createBoard(DefaultConfig(), []uint8{instruction.NOP})
+	b.SetStrict(true)
+	b.AddPeripheral("Rogue", &rogueDriver{&b.Core.ExternalDataBus})
+	// The CPU drives the address in A1
+	b.Run(func(b *Board) bool {
+		return false
+	})
+	c := b.Contention()
+	if c == nil {
+		t.Fatal("The contention did not stop the board")
+	}
+	if c.Bus != "External Data Bus" || c.Drivers[0] != "4004 Bus Buffer" || c.Drivers[1] != "Rogue" ||
+		c.Values[1] != 0x5 || c.Phase != 0 || c.Phi != 2 {
+		t.Errorf("Collision mismatch. Got %v", c)
+	}
+	clocks := b.Clock.GetClocks()
+	b.Step(10)
+	if b.Clock.GetClocks() != clocks {
+		t.Error("A stopped board kept running")
+	}
+
+	// Without strict mode, the board only reports the collisions
+	b.ClearContention()
+	b.SetStrict(false)
+	collisions := 0
+	b.OnBusCollision(func(c common.BusCollision) {
+		collisions++
+	})
+	b.Step(16)
+	if b.Contention() != nil || collisions == 0 {
+		t.Errorf("Collision report mismatch. Contention=%v, collisions=%d", b.Contention(), collisions)
+	}
+}
+
+func TestUndrivenRead(t *testing.T) {
+	SetupLogger()
+	// Read the I/O port of ROM 2, which is not on the board, and show it on ROM 0
+	program := []uint8{
+		instruction.FIM, 0x20,
+		instruction.SRC,
+		instruction.RDR,
+		instruction.FIM | 2, 0x00,
+		instruction.SRC | 2,
+		instruction.WRR,
+		instruction.JUN, 0x08,
+	}
+	config := DefaultConfig()
+	config.NumRoms = 1
+	b := createBoard(config, program)
+	b.Run(func(b *Board) bool {
+		return b.Core.GetProgramCounter() == 0x008
+	})
+	if b.Roms.IOBuses[0].Value() != 0xF {
+		t.Errorf("Precharged bus mismatch. Exp F, got %X", b.Roms.IOBuses[0].Value())
+	}
+
+	config.Float = common.FloatError
+	b = createBoard(config, program)
+	b.SetStrict(true)
+	reads := 0
+	b.OnUndrivenRead(func(u common.UndrivenRead) {
+		if u.Bus == "External Data Bus" {
+			reads++
+		}
+	})
+	b.Run(func(b *Board) bool {
+		return b.Core.GetProgramCounter() == 0x008
+	})
+	u := b.UndrivenFault()
+	if u == nil {
+		t.Fatal("The undriven read did not stop the board")
+	}
+	if u.Bus != "External Data Bus" || u.Phase != 6 || u.Phi != 1 || reads == 0 {
+		t.Errorf("Undriven read mismatch. Got %v, reads=%d", u, reads)
+	}
+}
+
+func TestPastEndOfRom(t *testing.T) {
+	SetupLogger()
+	// The precharged bus reads 0xFF past the end of the ROM, which does nothing
+	program := make([]uint8, 256)
+	program[0] = instruction.LDM | 5
+	config := DefaultConfig()
+	config.NumRoms = 1
+	b := createBoard(config, program)
+	for i := 0; i < 300; i++ {
+		b.StepInstruction()
+	}
+	if b.Core.GetProgramCounter() != 300 {
+		t.Errorf("PC mismatch. Exp %X, got %X", 300, b.Core.GetProgramCounter())
+	}
+	c, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Core.Alu.Accumulator != 5 || c.Core.Alu.Carry != 0 {
+		t.Errorf("ALU mismatch. Exp accumulator 5, carry 0, got %X, %X", c.Core.Alu.Accumulator, c.Core.Alu.Carry)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	SetupLogger()
+	program := []uint8{
+		instruction.FIM, 0x10, // Select ROM 1 and RAM 0
+		instruction.SRC,
+		instruction.LDM | 5,
+		instruction.WRR,
+		instruction.JMS, 0x0A,
+		instruction.JCN | 0x4, 0x0B, // Jump if ACC is 0
+		instruction.NOP,
+		instruction.BBL | 0,
+		instruction.JUN, 0x0B,
+	}
+	b := createBoard(DefaultConfig(), program)
+	var fetches []uint64
+	var retired []events.Retire
+	var others []events.Event
+	b.Events.Subscribe(events.KindFetch, func(clock uint64, e events.Event) {
+		fetches = append(fetches, e.(events.Fetch).PC)
+	})
+	b.Events.Subscribe(events.KindRetire, func(clock uint64, e events.Event) {
+		retired = append(retired, e.(events.Retire))
+	})
+	for _, kind := range []int{events.KindRegister, events.KindStackPush, events.KindStackPop,
+		events.KindIOPort, events.KindSrc, events.KindJump} {
+		b.Events.Subscribe(kind, func(clock uint64, e events.Event) {
+			others = append(others, e)
+		})
+	}
+	busWrites := 0
+	b.Events.Subscribe(events.KindBusWrite, func(clock uint64, e events.Event) {
+		busWrites++
+	})
+	for i := 0; i < 8; i++ {
+		b.StepInstruction()
+	}
+
+	expFetches := []uint64{0x0, 0x2, 0x3, 0x4, 0x5, 0xA, 0x7, 0xB}
+	if !reflect.DeepEqual(fetches, expFetches) {
+		t.Errorf("Fetch mismatch. Exp %X, got %X", expFetches, fetches)
+	}
+	if len(retired) != 8 || retired[0] != (events.Retire{PC: 0, Opcode: 0x2010, Cycles: 2}) ||
+		retired[2] != (events.Retire{PC: 3, Opcode: 0xD5, Cycles: 1}) {
+		t.Errorf("Retire mismatch. Got %+v", retired)
+	}
+	expOthers := []events.Event{
+		events.RegisterWrite{Register: "R0", Value: 1},
+		events.RegisterWrite{Register: "R1", Value: 0},
+		events.SrcLatch{Chip: "ROM #1", Address: 0x10},
+		events.SrcLatch{Chip: "RAM #0", Address: 0x10},
+		events.RegisterWrite{Register: "ACC", Value: 5},
+		events.IOPort{Chip: "ROM #1", Port: "ROM 1 I/O bus", Value: 5},
+		events.StackOp{Push: true, Address: 6},
+		events.StackOp{Push: false, Address: 6},
+		events.RegisterWrite{Register: "ACC", Value: 0},
+		events.Jump{PC: 7, Opcode: instruction.JCN | 0x4, Taken: true},
+	}
+	if !reflect.DeepEqual(others, expOthers) {
+		t.Errorf("Event mismatch.\nExp %+v\ngot %+v", expOthers, others)
+	}
+	if busWrites == 0 {
+		t.Error("No bus write events")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	SetupLogger()
+	program := instruction.LEDCountUsingAdd()
+	b := createBoard(DefaultConfig(), program)
+	ref := createBoard(DefaultConfig(), program)
+	first := b.Snapshot()
+	kept := b.Snapshot()
+	for i := 0; i < 400; i++ {
+		s := b.Snapshot()
+		if s.Clock != first.Clock+uint64(i) {
+			t.Fatalf("Clock mismatch. Exp %d, got %d", first.Clock+uint64(i), s.Clock)
+		}
+		pc := s.Core.Stack.Regs[s.Core.Stack.StackPointer]
+		if !pc.Selected || pc.Value != s.Core.PC {
+			t.Fatalf("Clock %d: program counter mismatch. PC=%X, stack register %+v", s.Clock, s.Core.PC, pc)
+		}
+		b.Step(1)
+		ref.Step(1)
+	}
+	if !reflect.DeepEqual(first, kept) {
+		t.Errorf("The snapshot changed while the board was running.\nExp %+v\ngot %+v", kept, first)
+	}
+	if reflect.DeepEqual(first, b.Snapshot()) {
+		t.Error("The snapshot did not follow the board")
+	}
+	if !reflect.DeepEqual(b.Snapshot(), ref.Snapshot()) {
+		t.Errorf("Taking snapshots changed the simulation.\nExp %+v\ngot %+v", ref.Snapshot(), b.Snapshot())
+	}
+}
+
+// createCheckpointBoard creates a board with a RAM, and a 4003 on the I/O port of ROM 0
+func createCheckpointBoard(model int) *Board {
+	program := []uint8{
+		instruction.FIM, 0x00, // Select ROM 0 and RAM 0
+		instruction.SRC,
+		instruction.LD | 2,
+		instruction.WRM,
+		instruction.WRR,
+		instruction.JMS, 0x0C,
+		instruction.ISZ | 1, 0x02,
+		instruction.JUN, 0x00,
+		instruction.INC | 2,
+		instruction.BBL | 0,
+	}
+	config := DefaultConfig()
+	config.Model = model
+	config.NumRams = 1
+	b := createBoard(config, program)
+	chain := &shift4003.Chain{}
+	chain.Init("LEDs", 1, shift4003.PortLine{Bus: &b.Roms.IOBuses[0], Bit: 0},
+		shift4003.PortLine{Bus: &b.Roms.IOBuses[0], Bit: 1}, nil)
+	b.AddPeripheral("LEDs", chain)
+	return b
+}
+
+func TestCheckpoint(t *testing.T) {
+	SetupLogger()
+	for _, model := range []int{cpucore.Model4004, cpucore.Model4040} {
+		b := createCheckpointBoard(model)
+		// Stop in the middle of an instruction
+		b.Step(1003)
+		var file bytes.Buffer
+		if err := b.SaveCheckpoint(&file); err != nil {
+			t.Fatalf("Model %d: save failed: %v", model, err)
+		}
+
+		restored := createCheckpointBoard(model)
+		if err := restored.LoadCheckpoint(&file); err != nil {
+			t.Fatalf("Model %d: load failed: %v", model, err)
+		}
+		for _, clocks := range []int{0, 3000} {
+			b.Step(clocks)
+			restored.Step(clocks)
+			exp, _ := b.Checkpoint()
+			got, _ := restored.Checkpoint()
+			if !reflect.DeepEqual(exp, got) {
+				t.Errorf("Model %d: state mismatch after %d clocks.\nExp %+v\ngot %+v", model, clocks, exp, got)
+			}
+			if !reflect.DeepEqual(b.Snapshot(), restored.Snapshot()) {
+				t.Errorf("Model %d: snapshot mismatch after %d clocks", model, clocks)
+			}
+		}
+		if b.Rams[0].Core.Checkpoint().Data[1] == 0 {
+			t.Errorf("Model %d: the program did not write the RAM", model)
+		}
+	}
+}
+
+func TestCheckpointErrors(t *testing.T) {
+	SetupLogger()
+	b := createCheckpointBoard(cpucore.Model4004)
+	c, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createCheckpointBoard(cpucore.Model4040).Restore(c); err == nil {
+		t.Error("A 4004 checkpoint was restored into a 4040")
+	}
+	if err := createBoard(DefaultConfig(), nil).Restore(c); err == nil {
+		t.Error("A checkpoint was restored into a board without its RAM")
+	}
+	c.Version++
+	if err := b.Restore(c); err == nil {
+		t.Error("A checkpoint with an unknown version was restored")
+	}
+}
+
+func TestCheckpointRestoreIsAtomic(t *testing.T) {
+	SetupLogger()
+	b := createCheckpointBoard(cpucore.Model4004)
+	c, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Step(500)
+	before, _ := b.Checkpoint()
+	c.Rams[0].Data = nil
+	if err := b.Restore(c); err == nil {
+		t.Fatal("A checkpoint with a bad RAM was restored")
+	}
+	if after, _ := b.Checkpoint(); !reflect.DeepEqual(before, after) {
+		t.Errorf("A failed restore changed the board.\nExp %+v\ngot %+v", before, after)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	SetupLogger()
+	b := createCheckpointBoard(cpucore.Model4004)
+	history := b.EnableHistory(100, 50)
+	ref := createCheckpointBoard(cpucore.Model4004)
+	b.Step(503)
+	b.Core.Test.Drive("TEST switch", 1)
+	b.Step(700)
+	if err := history.StepBack(450); err != nil {
+		t.Fatal(err)
+	}
+	ref.Step(503)
+	ref.Core.Test.Drive("TEST switch", 1)
+	ref.Step(250)
+	for _, clocks := range []int{0, 1000} {
+		b.Step(clocks)
+		ref.Step(clocks)
+		exp, _ := ref.Checkpoint()
+		got, _ := b.Checkpoint()
+		if !reflect.DeepEqual(exp, got) {
+			t.Errorf("State mismatch %d clocks after stepping back.\nExp %+v\ngot %+v", clocks, exp, got)
+		}
+	}
+
+	short := createCheckpointBoard(cpucore.Model4004)
+	history = short.EnableHistory(100, 5)
+	short.Step(1000)
+	now := short.Clock.GetClocks()
+	if err := history.StepBack(800); err == nil {
+		t.Error("Stepped back before the oldest checkpoint")
+	}
+	if short.Clock.GetClocks() != now {
+		t.Errorf("A failed step back moved the board to clock %d", short.Clock.GetClocks())
+	}
+}
+
+func TestStepBackInstruction(t *testing.T) {
+	SetupLogger()
+	b := createCheckpointBoard(cpucore.Model4004)
+	history := b.EnableHistory(100, 50)
+	wroteR2 := false
+	b.Events.Subscribe(events.KindRegister, func(clock uint64, e events.Event) {
+		if e.(events.RegisterWrite).Register == "R2" {
+			wroteR2 = true
+		}
+	})
+	var ends, r2Writes []uint64
+	for i := 0; i < 100; i++ {
+		wroteR2 = false
+		b.StepInstruction()
+		ends = append(ends, b.Clock.GetClocks())
+		if wroteR2 {
+			r2Writes = append(r2Writes, b.Clock.GetClocks())
+		}
+	}
+	// Start in the middle of an instruction
+	b.Step(3)
+	for i := len(ends) - 1; i > len(ends)-20; i-- {
+		if err := history.StepBackInstruction(); err != nil {
+			t.Fatal(err)
+		}
+		if b.Clock.GetClocks() != ends[i] {
+			t.Fatalf("Step back mismatch. Exp clock %d, got %d", ends[i], b.Clock.GetClocks())
+		}
+	}
+
+	// Run back to the last instruction which wrote R2
+	var exp uint64
+	for _, clock := range r2Writes {
+		if clock < b.Clock.GetClocks() {
+			exp = clock
+		}
+	}
+	err := history.RunBack(func(b *Board) bool {
+		w := wroteR2
+		wroteR2 = false
+		return w
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp == 0 || b.Clock.GetClocks() != exp {
+		t.Errorf("Run back mismatch. Exp clock %d, got %d", exp, b.Clock.GetClocks())
+	}
+}
+
+// createInputBoard creates a board running a program which reads the TEST pin
+// and the inputs of ROM 0
+func createInputBoard() *Board {
+	program := []uint8{
+		instruction.FIM, 0x00, // Select ROM 0
+		instruction.SRC,
+		instruction.RDR,
+		instruction.ADD | 2,
+		instruction.XCH | 2,
+		instruction.JCN | 0x1, 0x09, // Jump if TEST is low
+		instruction.INC | 3,
+		instruction.JUN, 0x02,
+	}
+	b := createBoard(DefaultConfig(), program)
+	b.Roms.Chips[0].SetIOMask(0, 0)
+	return b
+}
+
+func TestRecording(t *testing.T) {
+	SetupLogger()
+	b := createInputBoard()
+	recorder, err := b.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Step(203)
+	if err := b.SetRomInput(0, "Keys", 5); err != nil {
+		t.Fatal(err)
+	}
+	b.Step(300)
+	b.Core.Test.Drive("TEST switch", 1)
+	b.Step(200)
+	b.SetRomInput(0, "Keys", 0xA)
+	b.Reset()
+	b.Step(300)
+	b.Core.Test.Drive("TEST switch", 0)
+	b.Step(100)
+	if err := b.SetRomInput(16, "Keys", 1); err == nil {
+		t.Error("Drove the inputs of a missing ROM")
+	}
+
+	rec, err := recorder.Recording()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two port changes, two TEST changes and the two edges of the RESET pulse
+	if len(rec.Inputs) != 6 {
+		t.Errorf("Recorded %d inputs, expected 6: %+v", len(rec.Inputs), rec.Inputs)
+	}
+	var file bytes.Buffer
+	if err := rec.Save(&file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRecording(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := createInputBoard()
+	if err := replayed.Replay(loaded); err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := b.Checkpoint()
+	got, _ := replayed.Checkpoint()
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("Replay mismatch.\nExp %+v\ngot %+v", exp, got)
+	}
+
+	loaded.Inputs = nil
+	replayed.Replay(loaded)
+	got, _ = replayed.Checkpoint()
+	if reflect.DeepEqual(exp, got) {
+		t.Error("The inputs did not change the run")
+	}
+}
+
+func TestRecordingBeforeStart(t *testing.T) {
+	SetupLogger()
+	b := createInputBoard()
+	history := b.EnableHistory(100, 100)
+	b.Step(500)
+	recorder, err := b.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := b.Clock.GetClocks()
+	b.Step(200)
+	// Change the past of the recording
+	if err := history.StepBack(400); err != nil {
+		t.Fatal(err)
+	}
+	b.Core.Test.Drive("TEST switch", 1)
+	b.Step(600)
+	if _, err := recorder.Recording(); err == nil {
+		t.Error("Recorded an input before the start")
+	}
+
+	// A recording with an input which has passed
+	c, err := b.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &Recording{Version: RecordingVersion, Start: c, End: c.Clock.Clocks + 100,
+		Inputs: []Input{{Clock: start, Pin: b.Core.Test.Name, Level: 0, Driver: "TEST switch"}}}
+	if err := createInputBoard().Replay(rec); err == nil {
+		t.Error("Replayed an input which has passed")
+	}
+}
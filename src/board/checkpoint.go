@@ -0,0 +1,182 @@
+package board
+
+import (
+	"clock4201"
+	"common"
+	"cpucore"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"supportcommon"
+)
+
+// CheckpointVersion is the version of the checkpoint file format
+const CheckpointVersion = 1
+
+// Checkpoint is the complete state of a running board. Restoring it into a
+// board built from the same system resumes the simulation exactly where it
+// was saved
+type Checkpoint struct {
+	Version     int
+	Clock       clock4201.Checkpoint
+	Core        cpucore.Checkpoint
+	Roms        []supportcommon.Checkpoint
+	RomIOBuses  []common.BusCheckpoint
+	Rams        []supportcommon.Checkpoint
+	RamIOBuses  []common.BusCheckpoint
+	Peripherals map[string]json.RawMessage `json:",omitempty"` // By peripheral name
+}
+
+// CheckpointedPeripheral is a peripheral which saves its state in the board
+// checkpoints. CheckCheckpoint returns the error UnmarshalCheckpoint would
+// return, without changing anything
+type CheckpointedPeripheral interface {
+	MarshalCheckpoint() ([]byte, error)
+	CheckCheckpoint(data []byte) error
+	UnmarshalCheckpoint(data []byte) error
+}
+
+// Checkpoint returns the state of the board. Only the named peripherals which
+// implement CheckpointedPeripheral are saved
+func (b *Board) Checkpoint() (*Checkpoint, error) {
+	c := &Checkpoint{
+		Version:    CheckpointVersion,
+		Clock:      b.Clock.Checkpoint(),
+		Core:       b.Core.Checkpoint(),
+		Roms:       make([]supportcommon.Checkpoint, len(b.Roms.Chips)),
+		RomIOBuses: make([]common.BusCheckpoint, len(b.Roms.IOBuses)),
+		Rams:       make([]supportcommon.Checkpoint, len(b.Rams)),
+		RamIOBuses: make([]common.BusCheckpoint, len(b.RamIOBuses)),
+	}
+	for i := range b.Roms.Chips {
+		c.Roms[i] = b.Roms.Chips[i].Core.Checkpoint()
+		c.RomIOBuses[i] = b.Roms.IOBuses[i].Checkpoint()
+	}
+	for i := range b.Rams {
+		c.Rams[i] = b.Rams[i].Core.Checkpoint()
+		c.RamIOBuses[i] = b.RamIOBuses[i].Checkpoint()
+	}
+	for name, e := range b.Peripherals {
+		p, ok := e.(CheckpointedPeripheral)
+		if !ok {
+			continue
+		}
+		data, err := p.MarshalCheckpoint()
+		if err != nil {
+			return nil, fmt.Errorf("peripheral %s: %v", name, err)
+		}
+		if c.Peripherals == nil {
+			c.Peripherals = make(map[string]json.RawMessage)
+		}
+		c.Peripherals[name] = data
+	}
+	return c, nil
+}
+
+// Restore puts the board back in a saved state. The board must be built from
+// the same system as the one which was saved. Bus faults are cleared. Nothing
+// is changed if any part of the checkpoint does not fit
+func (b *Board) Restore(c *Checkpoint) error {
+	if err := b.checkRestore(c); err != nil {
+		return err
+	}
+	b.Core.Restore(c.Core)
+	for i := range b.Roms.Chips {
+		b.Roms.Chips[i].Core.Restore(c.Roms[i])
+		b.Roms.IOBuses[i].Restore(c.RomIOBuses[i])
+	}
+	for i := range b.Rams {
+		b.Rams[i].Core.Restore(c.Rams[i])
+		b.RamIOBuses[i].Restore(c.RamIOBuses[i])
+	}
+	for name, e := range b.Peripherals {
+		if p, ok := e.(CheckpointedPeripheral); ok {
+			if err := p.UnmarshalCheckpoint(c.Peripherals[name]); err != nil {
+				return fmt.Errorf("peripheral %s: %v", name, err)
+			}
+		}
+	}
+	b.Clock.Restore(c.Clock)
+	b.ClearContention()
+	return nil
+}
+
+// checkRestore returns the error Restore would return, without changing anything
+func (b *Board) checkRestore(c *Checkpoint) error {
+	if c.Version != CheckpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d, expected %d", c.Version, CheckpointVersion)
+	}
+	if len(c.Roms) != len(b.Roms.Chips) || len(c.RomIOBuses) != len(b.Roms.IOBuses) ||
+		len(c.Rams) != len(b.Rams) || len(c.RamIOBuses) != len(b.RamIOBuses) {
+		return fmt.Errorf("checkpoint has %d ROMs and %d RAMs, the board has %d and %d",
+			len(c.Roms), len(c.Rams), len(b.Roms.Chips), len(b.Rams))
+	}
+	for name, e := range b.Peripherals {
+		if _, ok := e.(CheckpointedPeripheral); ok && c.Peripherals[name] == nil {
+			return fmt.Errorf("checkpoint has no state for peripheral %s", name)
+		}
+	}
+	if err := b.Core.CheckRestore(c.Core); err != nil {
+		return err
+	}
+	for i := range b.Roms.Chips {
+		if err := b.Roms.Chips[i].Core.CheckRestore(c.Roms[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Rams {
+		if err := b.Rams[i].Core.CheckRestore(c.Rams[i]); err != nil {
+			return err
+		}
+	}
+	for name, e := range b.Peripherals {
+		if p, ok := e.(CheckpointedPeripheral); ok {
+			if err := p.CheckCheckpoint(c.Peripherals[name]); err != nil {
+				return fmt.Errorf("peripheral %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SaveCheckpoint writes the state of the board as JSON
+func (b *Board) SaveCheckpoint(w io.Writer) error {
+	c, err := b.Checkpoint()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(c)
+}
+
+// LoadCheckpoint reads a state written by SaveCheckpoint and restores it
+func (b *Board) LoadCheckpoint(r io.Reader) error {
+	c := &Checkpoint{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+	return b.Restore(c)
+}
+
+// SaveCheckpointFile writes the state of the board to a file
+func (b *Board) SaveCheckpointFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := b.SaveCheckpoint(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCheckpointFile restores the state of the board from a file
+func (b *Board) LoadCheckpointFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return b.LoadCheckpoint(f)
+}
@@ -0,0 +1,211 @@
+package board
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+)
+
+// History keeps checkpoints of the board at regular intervals, and the input
+// pin changes since the oldest one, so the simulation can step backwards. A
+// step back restores the last checkpoint before the target clock, and runs
+// forward again from there, replaying the inputs. The chips raise their events
+// again while replaying. Stepping back drops the history after the target, and
+// the simulation goes on from there with the input pins as they were then
+type History struct {
+	board       *Board
+	interval    uint64
+	depth       int
+	checkpoints []*Checkpoint // Oldest first
+	inputs      []Input       // Input changes since the oldest checkpoint
+}
+
+// EnableHistory takes a checkpoint every interval clocks, and keeps the last
+// depth of them. It replaces the previous history
+func (b *Board) EnableHistory(interval int, depth int) *History {
+	if interval < 1 || depth < 1 {
+		panic(fmt.Sprintf("Board: invalid history interval %d, depth %d", interval, depth))
+	}
+	h := &History{board: b, interval: uint64(interval), depth: depth}
+	b.history = h
+	b.OnInput(h.inputChanged)
+	h.checkpoint()
+	return h
+}
+
+// Oldest returns the clock of the oldest checkpoint. The board can step back to it
+func (h *History) Oldest() uint64 {
+	return h.checkpoints[0].Clock.Clocks
+}
+
+// StepBack goes back a number of clock periods
+func (h *History) StepBack(clocks int) error {
+	now := h.board.Clock.GetClocks()
+	if uint64(clocks) > now {
+		return fmt.Errorf("cannot step back %d clocks from clock %d", clocks, now)
+	}
+	return h.seek(now - uint64(clocks))
+}
+
+// StepBackInstruction goes back to the end of the previous instruction, where
+// StepInstruction would have stopped
+func (h *History) StepBackInstruction() error {
+	return h.RunBack(func(b *Board) bool { return true })
+}
+
+// RunBack goes back to the end of the last instruction for which until returns
+// true, like a breakpoint hit when running backwards. until is called at the end
+// of every instruction replayed
+func (h *History) RunBack(until func(b *Board) bool) error {
+	now := h.board.Clock.GetClocks()
+	for i := len(h.checkpoints) - 1; i >= 0; i-- {
+		if h.checkpoints[i].Clock.Clocks >= now {
+			continue
+		}
+		if found, ok := h.search(i, now, until); ok {
+			return h.seek(found)
+		}
+	}
+	// Put the board back where it was
+	if err := h.seek(now); err != nil {
+		return err
+	}
+	return fmt.Errorf("no instruction found since clock %d", h.Oldest())
+}
+
+// search replays from checkpoint i, and returns the end of the last
+// instruction before now for which until returns true. Only the instructions
+// which started after the checkpoint are checked. The last one is finished
+// after the next checkpoint, so every instruction is checked in one replay
+func (h *History) search(i int, now uint64, until func(b *Board) bool) (found uint64, ok bool) {
+	b := h.board
+	next := now
+	if i+1 < len(h.checkpoints) {
+		next = h.checkpoints[i+1].Clock.Clocks
+	}
+	r, err := h.start(i)
+	if err != nil {
+		rlog.Error(err)
+		return 0, false
+	}
+	started := b.AtInstructionBoundary()
+	if started {
+		// Let until forget what it saw in the previous replay
+		until(b)
+	}
+	for r.clock+1 < now {
+		r.tick()
+		if !b.AtInstructionBoundary() {
+			continue
+		}
+		if until(b) && started {
+			found, ok = r.clock, true
+		}
+		started = true
+		if r.clock >= next {
+			break
+		}
+	}
+	return
+}
+
+// seek restores the board at a clock, and drops the history after it
+func (h *History) seek(clock uint64) error {
+	if clock < h.Oldest() {
+		return fmt.Errorf("the history starts at clock %d", h.Oldest())
+	}
+	i := len(h.checkpoints) - 1
+	for h.checkpoints[i].Clock.Clocks > clock {
+		i--
+	}
+	r, err := h.start(i)
+	if err != nil {
+		return err
+	}
+	for r.clock < clock {
+		r.tick()
+	}
+	for len(h.checkpoints) > 0 && h.checkpoints[len(h.checkpoints)-1].Clock.Clocks > clock {
+		h.checkpoints = h.checkpoints[:len(h.checkpoints)-1]
+	}
+	for len(h.inputs) > 0 && h.inputs[len(h.inputs)-1].Clock > clock {
+		h.inputs = h.inputs[:len(h.inputs)-1]
+	}
+	return nil
+}
+
+// replayer runs the board forward from a checkpoint, with the recorded inputs
+type replayer struct {
+	h     *History
+	clock uint64
+	input int // The next input to apply
+}
+
+// start restores checkpoint i
+func (h *History) start(i int) (*replayer, error) {
+	c := h.checkpoints[i]
+	if err := h.board.Restore(c); err != nil {
+		return nil, err
+	}
+	r := &replayer{h: h, clock: c.Clock.Clocks}
+	for r.input < len(h.inputs) && h.inputs[r.input].Clock < r.clock {
+		r.input++
+	}
+	r.applyInputs()
+	return r, nil
+}
+
+// tick runs one clock period, and applies the inputs changed after it
+func (r *replayer) tick() {
+	r.h.board.Clock.Tick()
+	r.clock++
+	r.applyInputs()
+}
+
+func (r *replayer) applyInputs() {
+	for ; r.input < len(r.h.inputs) && r.h.inputs[r.input].Clock == r.clock; r.input++ {
+		if err := r.h.board.ApplyInput(r.h.inputs[r.input]); err != nil {
+			rlog.Error(err)
+		}
+	}
+}
+
+// Sync takes a checkpoint now. Call it when the board ran on without being
+// clocked, like with the functional backend, so a step back does not replay all
+// the way from the checkpoint before
+func (h *History) Sync() {
+	if h.checkpoints[len(h.checkpoints)-1].Clock.Clocks < h.board.Clock.GetClocks() {
+		h.checkpoint()
+	}
+}
+
+// ticked is called by the board after each clock period
+func (h *History) ticked() {
+	if h.board.Clock.GetClocks()%h.interval == 0 {
+		h.checkpoint()
+	}
+}
+
+// checkpoint adds a checkpoint, and drops the oldest one if the history is full
+func (h *History) checkpoint() {
+	c, err := h.board.Checkpoint()
+	if err != nil {
+		rlog.Errorf("History: %v", err)
+		return
+	}
+	h.checkpoints = append(h.checkpoints, c)
+	if len(h.checkpoints) <= h.depth {
+		return
+	}
+	h.checkpoints = h.checkpoints[1:]
+	oldest := h.Oldest()
+	for len(h.inputs) > 0 && h.inputs[0].Clock < oldest {
+		h.inputs = h.inputs[1:]
+	}
+}
+
+func (h *History) inputChanged(in Input) {
+	if h.board.history == h {
+		h.inputs = append(h.inputs, in)
+	}
+}
@@ -0,0 +1,102 @@
+package board
+
+import (
+	"common"
+	"fmt"
+)
+
+// Input is a change of an input pin of the CPU, or of the input lines of a ROM
+// I/O port, made from outside the simulation
+type Input struct {
+	Clock  uint64 // Clock periods run before the change
+	Pin    string // The signal name, like "TEST", or the port name, like "ROM 0 I/O bus"
+	Level  int    // The pin level, or the value of the port
+	Driver string
+}
+
+// inputDriver drives the input lines of the ROM I/O ports
+type inputDriver string
+
+func (d inputDriver) DriverName() string {
+	return string(d)
+}
+
+// inputPins returns the CPU pins which are driven from outside the board
+func (b *Board) inputPins() []*common.Signal {
+	return []*common.Signal{&b.Core.Test, &b.Core.ResetIn, &b.Core.Int, &b.Core.Stop}
+}
+
+// watchInputs reports the changes of the input pins to the input observers
+func (b *Board) watchInputs() {
+	for _, pin := range b.inputPins() {
+		pin.OnEdge(common.EdgeRising, b.inputChanged)
+		pin.OnEdge(common.EdgeFalling, b.inputChanged)
+	}
+}
+
+func (b *Board) inputChanged(s *common.Signal, edge int) {
+	if b.replaying {
+		return
+	}
+	in := Input{Clock: b.Clock.GetClocks(), Pin: s.Name, Level: s.Level(), Driver: s.Driver()}
+	for _, o := range b.inputObservers {
+		o(in)
+	}
+}
+
+// OnInput calls the observer for each change of the TEST, RESET, INT and STOP
+// pins, and of the ROM input lines
+func (b *Board) OnInput(o func(in Input)) {
+	b.inputObservers = append(b.inputObservers, o)
+}
+
+// SetRomInput drives the input lines of the I/O port of a ROM, like a switch
+// or a keyboard would. The output lines are left alone. RDR reads the value
+func (b *Board) SetRomInput(id int, driver string, value uint64) error {
+	i := b.Roms.FindChip(id)
+	if i < 0 {
+		return fmt.Errorf("there is no ROM %d", id)
+	}
+	b.setRomInput(i, driver, value)
+	return nil
+}
+
+func (b *Board) setRomInput(i int, driver string, value uint64) {
+	port := &b.Roms.IOBuses[i]
+	outputs := b.Roms.Chips[i].IOOutputs()
+	old := port.Value()
+	value = (old & outputs) | (value &^ outputs & 0xf)
+	if value == old {
+		return
+	}
+	port.Reset()
+	port.Write(inputDriver(driver), value)
+	if b.replaying {
+		return
+	}
+	in := Input{Clock: b.Clock.GetClocks(), Pin: port.Name, Level: int(value &^ outputs), Driver: driver}
+	for _, o := range b.inputObservers {
+		o(in)
+	}
+}
+
+// ApplyInput drives an input pin or port like the original change did. The
+// observers are not called
+func (b *Board) ApplyInput(in Input) error {
+	replaying := b.replaying
+	b.replaying = true
+	defer func() { b.replaying = replaying }()
+	for _, pin := range b.inputPins() {
+		if pin.Name == in.Pin {
+			pin.Drive(in.Driver, in.Level)
+			return nil
+		}
+	}
+	for i := range b.Roms.IOBuses {
+		if b.Roms.IOBuses[i].Name == in.Pin {
+			b.setRomInput(i, in.Driver, uint64(in.Level))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown input %q", in.Pin)
+}
@@ -0,0 +1,137 @@
+package board
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RecordingVersion is the version of the recording file format
+const RecordingVersion = 1
+
+// Recording is a run of the board: the state it started from, and every
+// change of the inputs until it ended. Replaying it into a board built from
+// the same system reproduces the run exactly
+type Recording struct {
+	Version int
+	Start   *Checkpoint
+	Inputs  []Input
+	End     uint64 // The clock when the recording ended
+}
+
+// Recorder records the inputs of a board
+type Recorder struct {
+	board  *Board
+	start  *Checkpoint
+	inputs []Input
+}
+
+// Record starts recording the inputs from the current state of the board
+func (b *Board) Record() (*Recorder, error) {
+	start, err := b.Checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{board: b, start: start}
+	b.OnInput(r.inputChanged)
+	return r, nil
+}
+
+func (r *Recorder) inputChanged(in Input) {
+	// Forget the inputs after a step back
+	for len(r.inputs) > 0 && r.inputs[len(r.inputs)-1].Clock > in.Clock {
+		r.inputs = r.inputs[:len(r.inputs)-1]
+	}
+	r.inputs = append(r.inputs, in)
+}
+
+// Recording returns the run recorded up to the current clock
+func (r *Recorder) Recording() (*Recording, error) {
+	end := r.board.Clock.GetClocks()
+	if end < r.start.Clock.Clocks {
+		return nil, fmt.Errorf("the board stepped back to clock %d, before the recording started at clock %d",
+			end, r.start.Clock.Clocks)
+	}
+	rec := &Recording{Version: RecordingVersion, Start: r.start, End: end}
+	for _, in := range r.inputs {
+		if in.Clock < r.start.Clock.Clocks {
+			// The board stepped back before the start, so the run no longer
+			// goes through the start state
+			return nil, fmt.Errorf("input %s changed at clock %d, before the recording started at clock %d",
+				in.Pin, in.Clock, r.start.Clock.Clocks)
+		}
+		if in.Clock <= end {
+			rec.Inputs = append(rec.Inputs, in)
+		}
+	}
+	return rec, nil
+}
+
+// Save writes the recording as JSON
+func (r *Recording) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// SaveFile writes the recording to a file
+func (r *Recording) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadRecording reads a recording written by Recording.Save
+func LoadRecording(rd io.Reader) (*Recording, error) {
+	r := &Recording{}
+	if err := json.NewDecoder(rd).Decode(r); err != nil {
+		return nil, fmt.Errorf("recording: %v", err)
+	}
+	if r.Version != RecordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d, expected %d", r.Version, RecordingVersion)
+	}
+	if r.Start == nil {
+		return nil, fmt.Errorf("recording: no start state")
+	}
+	return r, nil
+}
+
+// LoadRecordingFile reads a recording from a file
+func LoadRecordingFile(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadRecording(f)
+}
+
+// Replay restores the start of the recording, and runs the board to its end,
+// applying the inputs at their clocks. The inputs must be in clock order, and
+// not before the start. A strict board stops early on a bus error
+func (b *Board) Replay(r *Recording) error {
+	if err := b.Restore(r.Start); err != nil {
+		return err
+	}
+	next := 0
+	for {
+		clock := b.Clock.GetClocks()
+		if next < len(r.Inputs) && r.Inputs[next].Clock < clock {
+			return fmt.Errorf("input %s changed at clock %d, which has passed", r.Inputs[next].Pin, r.Inputs[next].Clock)
+		}
+		for ; next < len(r.Inputs) && r.Inputs[next].Clock == clock; next++ {
+			if err := b.ApplyInput(r.Inputs[next]); err != nil {
+				return err
+			}
+		}
+		if clock >= r.End || b.stopped() {
+			return nil
+		}
+		b.tick()
+	}
+}
@@ -0,0 +1,159 @@
+package clock4201
+
+import (
+	"interfaces"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// DefaultCrystal is the standard MCS-4 crystal frequency in Hz
+const DefaultCrystal = 5185000
+
+// Divider is how many crystal periods make up one clock period
+const Divider = 7
+
+// Clock4201 is a 4201 clock generator. It divides the crystal frequency by 7
+// into the two non-overlapping clock phases φ1 and φ2. Each clock period, all
+// the registered elements are calculated and then clocked in on φ1, and then
+// all of them are clocked out on φ2. Elements only sample inputs on φ1 and only
+// drive outputs on φ2. The bus owners reset their buses at the start of φ2, so
+// they are clocked first in each phase, and the rest in the order registered
+type Clock4201 struct {
+	Phi1     int // φ1 output, active high
+	Phi2     int // φ2 output, active high
+	crystal  float64
+	elements []interfaces.ClockedElement
+	clocks   uint64
+	realTime bool
+	start    time.Time
+	startClk uint64
+}
+
+// Checkpoint is the saved state of the clock
+type Checkpoint struct {
+	Phi1   int
+	Phi2   int
+	Clocks uint64
+}
+
+// Init sets the crystal frequency in Hz
+func (c *Clock4201) Init(crystal float64) {
+	if crystal <= 0 {
+		rlog.Warnf("4201: invalid crystal frequency %f. Using the default", crystal)
+		crystal = DefaultCrystal
+	}
+	c.crystal = crystal
+	c.elements = nil
+	c.Reset()
+}
+
+// Register adds an element to be clocked. A bus owner goes after the other bus
+// owners, but before the elements which are not
+func (c *Clock4201) Register(e interfaces.ClockedElement) {
+	if _, ok := e.(interfaces.BusOwner); !ok {
+		c.elements = append(c.elements, e)
+		return
+	}
+	i := 0
+	for i < len(c.elements) {
+		if _, ok := c.elements[i].(interfaces.BusOwner); !ok {
+			break
+		}
+		i++
+	}
+	c.elements = append(c.elements, nil)
+	copy(c.elements[i+1:], c.elements[i:])
+	c.elements[i] = e
+}
+
+// Reset resets the clock count, and all the registered elements
+func (c *Clock4201) Reset() {
+	c.Phi1 = 0
+	c.Phi2 = 0
+	c.clocks = 0
+	c.restartRealTime()
+	for _, e := range c.elements {
+		e.Reset()
+	}
+}
+
+// Frequency returns the clock frequency in Hz
+func (c *Clock4201) Frequency() float64 {
+	return c.crystal / Divider
+}
+
+// Period returns the duration of one clock period
+func (c *Clock4201) Period() time.Duration {
+	return time.Duration(float64(time.Second) / c.Frequency())
+}
+
+// GetClocks returns how many clock periods have run
+func (c *Clock4201) GetClocks() uint64 {
+	return c.clocks
+}
+
+// Checkpoint returns the state of the clock, to restore it later
+func (c *Clock4201) Checkpoint() Checkpoint {
+	return Checkpoint{Phi1: c.Phi1, Phi2: c.Phi2, Clocks: c.clocks}
+}
+
+// Restore puts the clock back in a saved state. The registered elements are
+// restored by their owners
+func (c *Clock4201) Restore(cp Checkpoint) {
+	c.Phi1 = cp.Phi1
+	c.Phi2 = cp.Phi2
+	c.clocks = cp.Clocks
+	c.restartRealTime()
+}
+
+// SetRealTime throttles the clock to the modelled crystal when enabled
+func (c *Clock4201) SetRealTime(enable bool) {
+	c.realTime = enable
+	c.restartRealTime()
+}
+
+func (c *Clock4201) restartRealTime() {
+	c.start = time.Now()
+	c.startClk = c.clocks
+}
+
+// Tick runs one clock period, φ1 followed by φ2
+func (c *Clock4201) Tick() {
+	c.Phi1 = 1
+	for _, e := range c.elements {
+		if calc, ok := e.(interfaces.Calculator); ok {
+			calc.Calculate()
+		}
+	}
+	for _, e := range c.elements {
+		e.ClockIn()
+	}
+	c.Phi1 = 0
+
+	c.Phi2 = 1
+	for _, e := range c.elements {
+		e.ClockOut()
+	}
+	c.Phi2 = 0
+
+	c.clocks++
+	if c.realTime {
+		c.throttle()
+	}
+}
+
+// Run runs a number of clock periods
+func (c *Clock4201) Run(clocks int) {
+	for i := 0; i < clocks; i++ {
+		c.Tick()
+	}
+}
+
+// throttle sleeps until the wall clock catches up with the modelled time
+func (c *Clock4201) throttle() {
+	modelled := time.Duration(float64(c.clocks-c.startClk) * float64(time.Second) / c.Frequency())
+	if ahead := modelled - time.Since(c.start); ahead > time.Millisecond {
+		time.Sleep(ahead)
+	}
+}
@@ -0,0 +1,161 @@
+package clock4201
+
+import (
+	"common"
+	"cpucore"
+	"instruction"
+	"os"
+	"rom4001"
+	"testing"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "clock_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+// phaseRecorder records which phase of the clock it was called in
+type phaseRecorder struct {
+	clock *Clock4201
+	calls []string
+}
+
+func (p *phaseRecorder) Reset() {
+	p.calls = nil
+}
+
+func (p *phaseRecorder) Calculate() {
+	p.record("calc")
+}
+
+func (p *phaseRecorder) ClockIn() {
+	p.record("in")
+}
+
+func (p *phaseRecorder) ClockOut() {
+	p.record("out")
+}
+
+func (p *phaseRecorder) record(name string) {
+	if p.clock.Phi1 == 1 && p.clock.Phi2 == 0 {
+		name += "/1"
+	} else if p.clock.Phi1 == 0 && p.clock.Phi2 == 1 {
+		name += "/2"
+	}
+	p.calls = append(p.calls, name)
+}
+
+func createSystem() (*cpucore.Core, *rom4001.RomArray) {
+	core := &cpucore.Core{}
+	core.Init()
+	// A precharged bus shows if a driver was lost to the bus reset
+	core.ExternalDataBus.SetFloat(common.FloatPrecharged)
+	rom := &rom4001.RomArray{}
+	rom.Init(4, &core.ExternalDataBus, &core.Sync, &core.CmROM)
+	rom.SetResetLine(&core.ResetIn)
+	rom.LoadImage(instruction.LEDCountUsingAdd())
+	return core, rom
+}
+
+func TestFrequency(t *testing.T) {
+	SetupLogger()
+	clk := Clock4201{}
+	clk.Init(DefaultCrystal)
+	if f := clk.Frequency(); f < 740700 || f > 740720 {
+		t.Errorf("Frequency mismatch. Exp 740.7 kHz, got %f", f)
+	}
+	if p := clk.Period(); p < 1349*time.Nanosecond || p > 1351*time.Nanosecond {
+		t.Errorf("Period mismatch. Exp 1.35us, got %v", p)
+	}
+}
+
+func TestPhases(t *testing.T) {
+	SetupLogger()
+	clk := Clock4201{}
+	clk.Init(DefaultCrystal)
+	p := &phaseRecorder{clock: &clk}
+	clk.Register(p)
+	clk.Run(2)
+	exp := []string{"calc/1", "in/1", "out/2", "calc/1", "in/1", "out/2"}
+	if len(p.calls) != len(exp) {
+		t.Fatalf("Call mismatch. Exp %v, got %v", exp, p.calls)
+	}
+	for i := range exp {
+		if p.calls[i] != exp[i] {
+			t.Errorf("Call %d mismatch. Exp %s, got %s", i, exp[i], p.calls[i])
+		}
+	}
+	if clk.Phi1 != 0 || clk.Phi2 != 0 || clk.GetClocks() != 2 {
+		t.Errorf("Clock state mismatch. Phi1=%d, Phi2=%d, clocks=%d", clk.Phi1, clk.Phi2, clk.GetClocks())
+	}
+	clk.Reset()
+	if len(p.calls) != 0 || clk.GetClocks() != 0 {
+		t.Error("Reset was not passed to the elements")
+	}
+}
+
+// TestMatchesHandOrder runs the core and ROM from the clock, in both
+// registration orders, and by hand in the old fixed order
+func TestMatchesHandOrder(t *testing.T) {
+	SetupLogger()
+	handCore, handRom := createSystem()
+	core, rom := createSystem()
+	clk := Clock4201{}
+	clk.Init(DefaultCrystal)
+	clk.Register(core)
+	clk.Register(rom)
+	revCore, revRom := createSystem()
+	rev := Clock4201{}
+	rev.Init(DefaultCrystal)
+	rev.Register(revRom)
+	rev.Register(revCore)
+
+	for i := 0; i < 8*200; i++ {
+		handCore.Calculate()
+		handCore.ClockIn()
+		handRom.ClockIn()
+		handCore.ClockOut()
+		handRom.ClockOut()
+		clk.Tick()
+		rev.Tick()
+
+		exp := handCore.GetProgramCounter()
+		if core.GetProgramCounter() != exp || revCore.GetProgramCounter() != exp {
+			t.Fatalf("PC mismatch at clock %d. Exp %X, got %X and %X", i, exp,
+				core.GetProgramCounter(), revCore.GetProgramCounter())
+		}
+		expBus := handCore.ExternalDataBus.Value()
+		if core.ExternalDataBus.Value() != expBus || revCore.ExternalDataBus.Value() != expBus {
+			t.Fatalf("Data bus mismatch at clock %d. Exp %X, got %X and %X", i, expBus,
+				core.ExternalDataBus.Value(), revCore.ExternalDataBus.Value())
+		}
+		expIO := handRom.IOBuses[0].Read()
+		if rom.IOBuses[0].Read() != expIO || revRom.IOBuses[0].Read() != expIO {
+			t.Fatalf("I/O mismatch at clock %d. Exp %X, got %X and %X", i, expIO,
+				rom.IOBuses[0].Read(), revRom.IOBuses[0].Read())
+		}
+	}
+	if handRom.IOBuses[0].Read() == 0 {
+		t.Error("The program did not write the LEDs")
+	}
+}
+
+func TestRealTime(t *testing.T) {
+	SetupLogger()
+	clk := Clock4201{}
+	// 1 kHz clock
+	clk.Init(7000)
+	clk.SetRealTime(true)
+	start := time.Now()
+	clk.Run(50)
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("Clock was not throttled. 50 clocks took %v", elapsed)
+	}
+}
@@ -1,5 +1,7 @@
 package common
 
+import "interfaces"
+
 const DirNone = 0 // disconnected from the bus
 const DirAtoB = 1 // transferring from A to B
 const DirBtoA = 2 // transferring from B to A
@@ -9,12 +11,18 @@ const DirIn = 4   // transferring out to in
 // Buffer represents a bi-directional non-latching bus buffer
 type Buffer struct {
 	Name     string
+	Owner    interfaces.BusDriver // The chip this buffer is in. Used for the driver name
 	dataBusA *Bus
 	dataBusB *Bus
 	Dir      int
 	width    int
 	mask     uint64
-	changed  bool
+}
+
+// BufferState is a snapshot of a buffer
+type BufferState struct {
+	Name string
+	Dir  int
 }
 
 func (b *Buffer) Init(busA *Bus, busB *Bus, width int, name string) {
@@ -29,36 +37,41 @@ func (b *Buffer) Init(busA *Bus, busB *Bus, width int, name string) {
 	b.Dir = DirBtoA
 }
 
+// DriverName returns the buffer name, prefixed with the owner name
+func (b *Buffer) DriverName() string {
+	return ownedName(b.Owner, b.Name)
+}
+
+// State returns a snapshot of the buffer
+func (b *Buffer) State() BufferState {
+	return BufferState{Name: b.Name, Dir: b.Dir}
+}
+
 // Disable disconnects the buffer from the bus
 func (b *Buffer) Disable() {
 	b.Dir = DirNone
-	b.changed = true
 }
 
 // AtoB transfers data from bus A to bus B
 func (b *Buffer) AtoB() {
 	value := (*b.dataBusA).Read() & b.mask
-	(*b.dataBusB).Write(value)
+	(*b.dataBusB).Write(b, value)
 	b.Dir = DirAtoB
-	b.changed = true
 }
 
 // BtoA transfers data from bus B to bus A
 func (b *Buffer) BtoA() {
 	value := (*b.dataBusB).Read() & b.mask
-	(*b.dataBusA).Write(value)
+	(*b.dataBusA).Write(b, value)
 	b.Dir = DirBtoA
-	b.changed = true
 }
 
 // SetDirAtoB just sets the direction from bus A to bus B (for the UI)
 func (b *Buffer) SetDirAtoB() {
 	b.Dir = DirAtoB
-	b.changed = true
 }
 
 // SetDirBtoA just sets the direction from bus A to bus B (for the UI)
 func (b *Buffer) SetDirBtoA() {
 	b.Dir = DirBtoA
-	b.changed = true
 }
@@ -1,6 +1,12 @@
 package common
 
-import "github.com/romana/rlog"
+import (
+	"events"
+	"fmt"
+	"interfaces"
+
+	"github.com/romana/rlog"
+)
 
 // Bus is used to transfer data between elements and also handle graphics rendering
 type Bus struct {
@@ -8,8 +14,80 @@ type Bus struct {
 	data     uint64
 	mask     uint64
 	BusWidth int
-	Updated  bool // The bus has been written since the last redraw
-	writes   int  // Number of writes to the bus during this tick
+	writes   int         // Number of writes to the bus during this tick
+	driver   string      // Who wrote the bus last during this tick
+	collided bool        // Two drivers wrote the bus during this tick
+	float    int         // What a read returns when nobody drove the bus. FloatHold by default
+	monitor  *BusMonitor // Receives the collisions. They are logged if nil
+	events   *events.Dispatcher
+}
+
+// What a read of a floating bus returns
+const (
+	FloatHold       = iota // The last value driven
+	FloatPrecharged        // All ones, like the precharged 4004 data bus
+	FloatError             // The last value driven, and the read is reported as an error
+)
+
+// BusState is a snapshot of a bus
+type BusState struct {
+	Name      string
+	Value     uint64 // What a read would return
+	Writes    int    // Writes during this tick
+	Driver    string // Who wrote the bus last during this tick, or ""
+	Collision bool   // Two drivers wrote the bus during this tick
+}
+
+// BusCheckpoint is the saved state of a bus
+type BusCheckpoint struct {
+	Data     uint64
+	Writes   int
+	Driver   string
+	Collided bool
+}
+
+// NamedDriver is a bus driver which is only a name, like a test jig
+type NamedDriver string
+
+func (n NamedDriver) DriverName() string {
+	return string(n)
+}
+
+// BusCollision is two different drivers writing a bus during the same tick
+type BusCollision struct {
+	Bus     string
+	Drivers [2]string // The earlier and the later driver
+	Values  [2]uint64 // The values they drove
+	Clock   uint64    // Clock period, if the monitor knows it
+	Phase   int       // Instruction cycle phase (0=A1..7=X3), if the monitor knows it
+	Phi     int       // Clock phase (1 or 2), if the monitor knows it
+}
+
+func (c BusCollision) Error() string {
+	return fmt.Sprintf("bus collision on %s at clock %d (phase %d, φ%d): %s drove %X, %s drove %X",
+		c.Bus, c.Clock, c.Phase, c.Phi, c.Drivers[0], c.Values[0], c.Drivers[1], c.Values[1])
+}
+
+// UndrivenRead is a read of a bus which nobody drove during the tick
+type UndrivenRead struct {
+	Bus   string
+	Float int    // The floating bus mode
+	Value uint64 // What the read returned
+	Clock uint64 // Clock period, if the monitor knows it
+	Phase int    // Instruction cycle phase (0=A1..7=X3), if the monitor knows it
+	Phi   int    // Clock phase (1 or 2), if the monitor knows it
+}
+
+func (u UndrivenRead) Error() string {
+	return fmt.Sprintf("read of undriven bus %s at clock %d (phase %d, φ%d) returned %X",
+		u.Bus, u.Clock, u.Phase, u.Phi, u.Value)
+}
+
+// BusMonitor watches the buses it is attached to for collisions and undriven reads
+type BusMonitor struct {
+	Time     func() (clock uint64, phase int, phi int) // Time stamps the reports. May be nil
+	Handler  func(c BusCollision)                      // Called for each collision
+	Undriven func(u UndrivenRead)                      // Called for each undriven read. May be nil
 }
 
 func (b *Bus) Init(busWidth int, name string) {
@@ -23,23 +101,126 @@ func (b *Bus) Init(busWidth int, name string) {
 	b.data = 0xffffffffffffffff & b.mask
 }
 
-func (b *Bus) Write(value uint64) {
-	rlog.Tracef(0, "BUS: %s write=%X, writesPre=%d. this=%p", b.Name, value, b.writes, b)
+// SetEvents raises a BusWrite event for each write through the dispatcher
+func (b *Bus) SetEvents(d *events.Dispatcher) {
+	b.events = d
+}
+
+// SetFloat selects what a read of the bus returns when nobody drove it
+func (b *Bus) SetFloat(mode int) {
+	b.float = mode
+}
+
+// GetFloat returns the floating bus mode
+func (b *Bus) GetFloat() int {
+	return b.float
+}
+
+// SetMonitor attaches a monitor which receives the collisions on this bus
+func (b *Bus) SetMonitor(m *BusMonitor) {
+	b.monitor = m
+}
+
+func (b *Bus) Write(driver interfaces.BusDriver, value uint64) {
+	name := driver.DriverName()
+	rlog.Tracef(0, "BUS: %s write=%X, driver=%s, writesPre=%d. this=%p", b.Name, value, name, b.writes, b)
+	if b.writes > 0 && name != b.driver {
+		b.collision(name, value)
+	}
 	b.data = value
+	b.driver = name
 	b.writes++
-	if b.writes > 1 {
-		rlog.Warnf("**** Bus collision. Name=%s, writes=%d", b.Name, b.writes)
+	if b.events.Wants(events.KindBusWrite) {
+		b.events.Raise(events.BusWrite{Bus: b.Name, Driver: name, Value: value})
+	}
+}
+
+func (b *Bus) collision(name string, value uint64) {
+	b.collided = true
+	c := BusCollision{
+		Bus:     b.Name,
+		Drivers: [2]string{b.driver, name},
+		Values:  [2]uint64{b.data, value},
+	}
+	if b.monitor == nil {
+		rlog.Warnf("**** %v", c)
+		return
+	}
+	if b.monitor.Time != nil {
+		c.Clock, c.Phase, c.Phi = b.monitor.Time()
+	}
+	if b.monitor.Handler != nil {
+		b.monitor.Handler(c)
 	}
-	b.Updated = true // cleared by renderer
 }
 
+// Read returns the value on the bus. Reads of an undriven bus follow the
+// floating bus mode, and are reported to the monitor
 func (b *Bus) Read() (value uint64) {
+	value = b.Value()
+	if b.writes == 0 {
+		b.undrivenRead(value)
+	}
+	return value
+}
+
+// Value returns what a read would return, without reporting undriven reads. For displays
+func (b *Bus) Value() uint64 {
+	if b.writes == 0 && b.float == FloatPrecharged {
+		return b.mask
+	}
 	return b.data
 }
 
+// State returns a snapshot of the bus
+func (b *Bus) State() BusState {
+	return BusState{Name: b.Name, Value: b.Value(), Writes: b.writes, Driver: b.Driver(), Collision: b.collided}
+}
+
+// Checkpoint returns the state of the bus, to restore it later
+func (b *Bus) Checkpoint() BusCheckpoint {
+	return BusCheckpoint{Data: b.data, Writes: b.writes, Driver: b.driver, Collided: b.collided}
+}
+
+// Restore puts the bus back in a saved state, without raising any events
+func (b *Bus) Restore(c BusCheckpoint) {
+	b.data = c.Data & b.mask
+	b.writes = c.Writes
+	b.driver = c.Driver
+	b.collided = c.Collided
+}
+
+// Driven returns true if the bus was written during this tick
+func (b *Bus) Driven() bool {
+	return b.writes > 0
+}
+
+func (b *Bus) undrivenRead(value uint64) {
+	rlog.Tracef(1, "BUS: %s read while undriven, value=%X", b.Name, value)
+	u := UndrivenRead{Bus: b.Name, Float: b.float, Value: value}
+	if b.monitor == nil || b.monitor.Undriven == nil {
+		if b.float == FloatError {
+			rlog.Errorf("**** %v", u)
+		}
+		return
+	}
+	if b.monitor.Time != nil {
+		u.Clock, u.Phase, u.Phi = b.monitor.Time()
+	}
+	b.monitor.Undriven(u)
+}
+
+// Driver returns who wrote the bus last during this tick, or "" if nobody did
+func (b *Bus) Driver() string {
+	if b.writes == 0 {
+		return ""
+	}
+	return b.driver
+}
+
 func (b *Bus) Reset() {
 	rlog.Tracef(1, "BUS: %s Reset", b.Name)
 	// b.data = 0xffffffffffffffff & b.mask
 	b.writes = 0
-	b.Updated = true // cleared by renderer
+	b.collided = false
 }
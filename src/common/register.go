@@ -1,14 +1,25 @@
 package common
 
+import (
+	"interfaces"
+	"strings"
+)
+
 type Register struct {
-	Name     string
-	Reg      uint64
-	Selected bool // For the UI to show this is selected
+	Name  string
+	Reg   uint64
+	Owner interfaces.BusDriver // The chip this register is in. Used for the driver name
 
 	dataBus *Bus
 	width   int
 	mask    uint64
-	changed bool
+}
+
+// RegisterState is a snapshot of a register
+type RegisterState struct {
+	Name     string
+	Value    uint64
+	Selected bool // The register is the one in use, like the program counter in the address stack
 }
 
 func (r *Register) Init(dataBus *Bus, width int, name string) {
@@ -19,17 +30,35 @@ func (r *Register) Init(dataBus *Bus, width int, name string) {
 		r.mask = r.mask | 1
 	}
 	r.Name = name
-	r.changed = true
+}
+
+// State returns a snapshot of the register
+func (r *Register) State() RegisterState {
+	return RegisterState{Name: r.Name, Value: r.Reg & r.mask}
 }
 
 func (r *Register) Read() {
 	value := r.Reg & r.mask
-	(*r.dataBus).Write(value)
+	(*r.dataBus).Write(r, value)
+}
+
+// DriverName returns the register name, prefixed with the owner name
+func (r *Register) DriverName() string {
+	return ownedName(r.Owner, strings.TrimRight(r.Name, "= "))
+}
+
+func ownedName(owner interfaces.BusDriver, name string) string {
+	if owner == nil {
+		return name
+	}
+	if name == "" {
+		return owner.DriverName()
+	}
+	return owner.DriverName() + " " + name
 }
 
 func (r *Register) Write() {
 	r.Reg = (*r.dataBus).Read() & r.mask
-	r.changed = true
 }
 
 // ReadDirect directly reads the register instead of using the bus
@@ -40,12 +69,10 @@ func (r *Register) ReadDirect() (value uint64) {
 // WriteDirect directly writes the register instead of using the bus
 func (r *Register) WriteDirect(value uint64) {
 	r.Reg = value & r.mask
-	r.changed = true
 }
 
 // Increment directly increments the value in a register
 // Not sure all hardware supports this
 func (r *Register) Increment() {
 	r.Reg = (r.Reg + 1) & r.mask
-	r.changed = true
 }
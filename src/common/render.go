@@ -8,32 +8,31 @@ import (
 	"github.com/tfriedel6/canvas"
 )
 
-// RegisterRenderer renders a Register to the screen
+// RegisterRenderer renders the state of a Register to the screen
 type RegisterRenderer struct {
-	reg         *Register
 	bounds      image.Rectangle
 	ShowUpdates bool
-	dirty       int // needs to be redrawn if non-zero
+	dirty       int           // needs to be redrawn if non-zero
+	last        RegisterState // The state rendered last
 }
 
 // InitRender initializes the element for rendering
-func (r *RegisterRenderer) InitRender(reg *Register, bounds image.Rectangle) {
-	r.reg = reg
+func (r *RegisterRenderer) InitRender(bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 	r.ShowUpdates = true
 }
 
-// Render the contents to the screen
-func (r *RegisterRenderer) Render(canvas *canvas.Canvas) {
-	if r.reg.changed {
+// Render the state to the screen. It is highlighted when it differs from the last one
+func (r *RegisterRenderer) Render(canvas *canvas.Canvas, state RegisterState) {
+	if state != r.last {
 		r.dirty = 4
-		r.reg.changed = false
+		r.last = state
 	}
 	if r.dirty == 0 {
 		return
 	}
-	if r.reg.Selected {
+	if state.Selected {
 		canvas.SetFillStyle(css.RegisterBackgroundSelected)
 	} else {
 		canvas.SetFillStyle(css.RegisterBackground)
@@ -49,33 +48,32 @@ func (r *RegisterRenderer) Render(canvas *canvas.Canvas) {
 	} else {
 		canvas.SetFillStyle(css.RegisterTextUpdate)
 	}
-	if r.reg.Name != "" {
-		canvas.FillText(fmt.Sprintf("%s%X", r.reg.Name, r.reg.Reg), float64(r.bounds.Min.X+10), float64(r.bounds.Min.Y+30))
+	if state.Name != "" {
+		canvas.FillText(fmt.Sprintf("%s%X", state.Name, state.Value), float64(r.bounds.Min.X+10), float64(r.bounds.Min.Y+30))
 	} else {
-		canvas.FillText(fmt.Sprintf("%X", r.reg.Reg), float64(r.bounds.Min.X+50), float64(r.bounds.Min.Y+30))
+		canvas.FillText(fmt.Sprintf("%X", state.Value), float64(r.bounds.Min.X+50), float64(r.bounds.Min.Y+30))
 	}
 	r.dirty--
 }
 
-// BufferRenderer renders a Buffer to the screen
+// BufferRenderer renders the state of a Buffer to the screen
 type BufferRenderer struct {
-	buf    *Buffer
 	bounds image.Rectangle
-	dirty  int // needs to be redrawn if non-zero
+	dirty  int         // needs to be redrawn if non-zero
+	last   BufferState // The state rendered last
 }
 
 // InitRender initializes the element for rendering
-func (r *BufferRenderer) InitRender(buf *Buffer, bounds image.Rectangle) {
-	r.buf = buf
+func (r *BufferRenderer) InitRender(bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 }
 
-// Render the contents to the screen
-func (r *BufferRenderer) Render(canvas *canvas.Canvas) {
-	if r.buf.changed {
+// Render the state to the screen
+func (r *BufferRenderer) Render(canvas *canvas.Canvas, state BufferState) {
+	if state != r.last {
 		r.dirty = 2
-		r.buf.changed = false
+		r.last = state
 	}
 	if r.dirty == 0 {
 		return
@@ -88,57 +86,47 @@ func (r *BufferRenderer) Render(canvas *canvas.Canvas) {
 		float64(r.bounds.Dx()), float64(r.bounds.Dy()))
 
 	canvas.SetFillStyle(css.RegisterTextNormal)
-	canvas.FillText(fmt.Sprintf("%s", r.buf.Name), float64(r.bounds.Min.X+10), float64(r.bounds.Min.Y+30))
+	canvas.FillText(fmt.Sprintf("%s", state.Name), float64(r.bounds.Min.X+10), float64(r.bounds.Min.Y+30))
 
 	canvas.SetFillStyle(css.BufferDirArrow)
 	arrowWidth := 16.0
 	arrowHeight := 24.0
 	// TODO: fix these magic #s here
-	if r.buf.Dir == DirAtoB {
+	if state.Dir == DirAtoB {
 		RenderArrowHead(canvas, float64(r.bounds.Max.X)-arrowWidth-5, float64(r.bounds.Max.Y)-15, arrowHeight, arrowWidth, 3)
-	} else if r.buf.Dir == DirBtoA {
+	} else if state.Dir == DirBtoA {
 		RenderArrowHead(canvas, float64(r.bounds.Max.X)-arrowWidth-5, float64(r.bounds.Max.Y)-arrowHeight-10, arrowHeight, arrowWidth, 2)
 	}
 	r.dirty--
 }
 
-// BusRenderer renders a Bus to the screen
+// BusRenderer renders the state of a Bus to the screen
 type BusRenderer struct {
-	bus          *Bus
 	startLoc     image.Point
 	endLoc       image.Point
 	widthPix     int
-	dirty        int  // needs to be redrawn if non-zero
-	NoStartArrow bool // Don't draw the start arrowhead
-	NoEndArrow   bool // Don't draw the end arrowhead
-	DrivingBus   *bool
+	dirty        int      // needs to be redrawn if non-zero
+	last         BusState // The state rendered last
+	NoStartArrow bool     // Don't draw the start arrowhead
+	NoEndArrow   bool     // Don't draw the end arrowhead
 }
 
 // InitRender initializes the element for rendering
-func (b *BusRenderer) InitRender(bus *Bus, startLoc image.Point, endLoc image.Point, widthPix int) {
-	b.bus = bus
+func (b *BusRenderer) InitRender(startLoc image.Point, endLoc image.Point, widthPix int) {
 	b.startLoc = startLoc
 	b.endLoc = endLoc
 	b.widthPix = widthPix
 	b.dirty = 2
 }
 
-// Render the contents to the screen
-func (b *BusRenderer) Render(canvas *canvas.Canvas) {
-	busCollision := false
-	busDriven := false
-	if b.DrivingBus != nil {
-		busDriven = *b.DrivingBus
-	}
-	if b.bus.writes > 0 || busDriven {
+// Render the state to the screen. busDriven highlights the bus, for the buses
+// inside the chips which are not modeled, like the one between the accumulator
+// and the ALU
+func (b *BusRenderer) Render(canvas *canvas.Canvas, state BusState, busDriven bool) {
+	busCollision := state.Collision
+	if state.Writes > 0 || busDriven || state != b.last {
 		b.dirty = 4
-		if b.bus.writes > 1 {
-			busCollision = true
-		}
-		b.bus.writes = 0
-		if b.DrivingBus != nil && *b.DrivingBus {
-			*b.DrivingBus = false
-		}
+		b.last = state
 	}
 
 	if b.dirty == 0 {
@@ -166,11 +154,11 @@ func (b *BusRenderer) Render(canvas *canvas.Canvas) {
 		canvas.FillRect(float64(b.startLoc.X)+arrowWidth, float64(b.startLoc.Y-b.widthPix/2),
 			float64(b.endLoc.X-b.startLoc.X)-arrowWidth*2, float64(b.widthPix))
 		canvas.SetFillStyle(css.RegisterTextNormal)
-		if b.bus.Name != "" {
-			canvas.FillText(fmt.Sprintf("%s=%X", b.bus.Name, b.bus.data),
+		if state.Name != "" {
+			canvas.FillText(fmt.Sprintf("%s=%X", state.Name, state.Value),
 				float64(b.startLoc.X)+20+arrowWidth, float64(b.startLoc.Y)+5)
 		} else {
-			canvas.FillText(fmt.Sprintf("%X", b.bus.data),
+			canvas.FillText(fmt.Sprintf("%X", state.Value),
 				float64(b.startLoc.X)+20+arrowWidth, float64(b.startLoc.Y)+5)
 		}
 	} else if b.startLoc.X == b.endLoc.X {
@@ -0,0 +1,116 @@
+package common
+
+import "github.com/romana/rlog"
+
+// Signal edges
+const (
+	EdgeRising  = iota // 0 to 1
+	EdgeFalling        // 1 to 0
+	NumEdges
+)
+
+// EdgeObserver is called after a signal changed level
+type EdgeObserver func(s *Signal, edge int)
+
+// Signal is a single bit control line, like SYNC, CM-ROM or RESET. It remembers
+// who drove it last and counts its edges, and observers can subscribe to the
+// edges. The zero value is a nameless active high signal at level 0
+type Signal struct {
+	Name      string
+	ActiveLow bool // The signal is asserted when the level is 0
+	level     int
+	driver    string        // Who drove the signal last
+	edges     [NumEdges]int // Number of edges seen
+	observers [NumEdges][]EdgeObserver
+}
+
+// SignalCheckpoint is the saved state of a signal
+type SignalCheckpoint struct {
+	Level  int
+	Driver string
+	Edges  [NumEdges]int
+}
+
+// Init sets the name, the polarity and the initial level. Observers are kept
+func (s *Signal) Init(name string, activeLow bool, level int) {
+	s.Name = name
+	s.ActiveLow = activeLow
+	s.level = level & 0x1
+	s.driver = ""
+	s.edges = [NumEdges]int{}
+}
+
+// Drive sets the level of the signal, and calls the observers if it changed
+func (s *Signal) Drive(driver string, level int) {
+	level &= 0x1
+	s.driver = driver
+	if level == s.level {
+		return
+	}
+	s.level = level
+	edge := EdgeRising
+	if level == 0 {
+		edge = EdgeFalling
+	}
+	s.edges[edge]++
+	rlog.Tracef(1, "SIGNAL: %s=%d, driver=%s", s.Name, level, driver)
+	for _, o := range s.observers[edge] {
+		o(s, edge)
+	}
+}
+
+// Assert drives the signal to its active level
+func (s *Signal) Assert(driver string) {
+	s.Set(driver, true)
+}
+
+// Deassert drives the signal to its inactive level
+func (s *Signal) Deassert(driver string) {
+	s.Set(driver, false)
+}
+
+// Set asserts or deasserts the signal
+func (s *Signal) Set(driver string, asserted bool) {
+	if asserted != s.ActiveLow {
+		s.Drive(driver, 1)
+	} else {
+		s.Drive(driver, 0)
+	}
+}
+
+// Level returns the electrical level, 0 or 1
+func (s *Signal) Level() int {
+	return s.level
+}
+
+// IsAsserted returns true if the signal is at its active level
+func (s *Signal) IsAsserted() bool {
+	return (s.level != 0) != s.ActiveLow
+}
+
+// Driver returns the name of whoever drove the signal last
+func (s *Signal) Driver() string {
+	return s.driver
+}
+
+// EdgeCount returns how many rising or falling edges the signal has had
+func (s *Signal) EdgeCount(edge int) int {
+	return s.edges[edge]
+}
+
+// OnEdge subscribes an observer to the rising or falling edges
+func (s *Signal) OnEdge(edge int, o EdgeObserver) {
+	s.observers[edge] = append(s.observers[edge], o)
+}
+
+// Checkpoint returns the state of the signal, to restore it later
+func (s *Signal) Checkpoint() SignalCheckpoint {
+	return SignalCheckpoint{Level: s.level, Driver: s.driver, Edges: s.edges}
+}
+
+// Restore puts the signal back in a saved state. The observers are not called
+func (s *Signal) Restore(c SignalCheckpoint) {
+	s.level = c.Level & 0x1
+	s.driver = c.Driver
+	s.edges = c.Edges
+}
@@ -38,3 +38,134 @@ func TestADD(t *testing.T) {
 	verifyJump(&core, uint64(instruction.JCN_CARRY_SET), true, t)
 
 }
+
+// setCarry sets the carry with STC or CLC
+func setCarry(core *Core, carry uint64, t *testing.T) {
+	if carry != 0 {
+		runOneCycle(core, uint64(instruction.STC), t)
+	} else {
+		runOneCycle(core, uint64(instruction.CLC), t)
+	}
+}
+
+// setRegister loads a value into a scratchpad register through the accumulator
+func setRegister(core *Core, reg uint64, value uint64, t *testing.T) {
+	runOneCycle(core, uint64(instruction.LDM|value), t)
+	runOneCycle(core, uint64(instruction.XCH|reg), t)
+}
+
+// TestArithmeticExhaustive runs every accumulator/operand/carry combination
+// through the instruction decoder. The ALU package checks the actual values
+func TestArithmeticExhaustive(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestArithmeticExhaustive")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	register := uint64(0x5)
+	for acc := uint64(0); acc < 16; acc++ {
+		for op := uint64(0); op < 16; op++ {
+			for carry := uint64(0); carry < 2; carry++ {
+				sum := acc + op + carry
+				diff := int(acc) - int(op) - int(carry)
+				diffCarry := uint64(0)
+				if diff >= 0 {
+					diffCarry = 1
+				}
+				tests := []struct {
+					name     string
+					inst     uint64
+					expAcc   uint64
+					expCarry uint64
+				}{
+					{"ADD", instruction.ADD | register, sum & 0xf, sum >> 4},
+					{"SUB", instruction.SUB | register, uint64(diff) & 0xf, diffCarry},
+					{"ADM", instruction.ADM, sum & 0xf, sum >> 4},
+					{"SBM", instruction.SBM, uint64(diff) & 0xf, diffCarry},
+				}
+				setRegister(&core, register, op, t)
+				for _, test := range tests {
+					runOneCycle(&core, uint64(instruction.LDM|acc), t)
+					setCarry(&core, carry, t)
+					runOneIOReadCycle(&core, test.inst, &op, t)
+					gotAcc := core.alu.ReadAccumulatorDirect()
+					gotCarry := uint64(core.alu.GetFlags().Carry)
+					if gotAcc != test.expAcc || gotCarry != test.expCarry {
+						t.Errorf("%s: A=%X, op=%X, C=%d. Exp A=%X, C=%d. Got A=%X, C=%d",
+							test.name, acc, op, carry, test.expAcc, test.expCarry, gotAcc, gotCarry)
+					}
+				}
+			}
+		}
+	}
+}
+
+// runBCD adds or subtracts two 4 digit BCD numbers in registers 0-3 and 4-7,
+// least significant digit first. The result goes in registers 0-3
+func runBCD(core *Core, a uint64, b uint64, subtract bool, t *testing.T) (result uint64, carry uint64) {
+	for i := uint64(0); i < 4; i++ {
+		setRegister(core, i, (a>>(i*4))&0xf, t)
+		setRegister(core, i+4, (b>>(i*4))&0xf, t)
+	}
+	if subtract {
+		// The carry means "no borrow"
+		runOneCycle(core, uint64(instruction.STC), t)
+	} else {
+		runOneCycle(core, uint64(instruction.CLC), t)
+	}
+	for i := uint64(0); i < 4; i++ {
+		if subtract {
+			// Add the 10's complement of the digit
+			runOneCycle(core, uint64(instruction.TCS), t)
+			runOneCycle(core, uint64(instruction.SUB|(i+4)), t)
+			runOneCycle(core, uint64(instruction.CLC), t)
+			runOneCycle(core, uint64(instruction.ADD|i), t)
+		} else {
+			runOneCycle(core, uint64(instruction.LD|i), t)
+			runOneCycle(core, uint64(instruction.ADD|(i+4)), t)
+		}
+		runOneCycle(core, uint64(instruction.DAA), t)
+		runOneCycle(core, uint64(instruction.XCH|i), t)
+	}
+	carry = uint64(core.alu.GetFlags().Carry)
+	for i := uint64(0); i < 4; i++ {
+		// Read the digits back with SRC. The even register is sent in X2
+		_, ioVal := runOneIOCycle(core, uint64(instruction.SRC|(i&0x6)), t)
+		result |= ((ioVal >> ((i & 1) * 4)) & 0xf) << (i * 4)
+	}
+	return result, carry
+}
+
+func TestBCD(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestBCD")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	tests := []struct {
+		a, b     uint64
+		subtract bool
+		expected uint64
+		carry    uint64
+	}{
+		{0x1234, 0x5678, false, 0x6912, 0},
+		{0x9999, 0x0001, false, 0x0000, 1},
+		{0x0909, 0x0191, false, 0x1100, 0},
+		{0x5000, 0x1234, true, 0x3766, 1},
+		{0x1234, 0x5000, true, 0x6234, 0},
+		{0x1000, 0x0001, true, 0x0999, 1},
+	}
+	for _, test := range tests {
+		result, carry := runBCD(&core, test.a, test.b, test.subtract, t)
+		if result != test.expected || carry != test.carry {
+			t.Errorf("BCD %04X, %04X, subtract=%v. Exp %04X (carry %d), got %04X (carry %d)",
+				test.a, test.b, test.subtract, test.expected, test.carry, result, carry)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package cpucore
+
+import (
+	"addressstack"
+	"alu"
+	"common"
+	"fmt"
+	"instruction"
+	"scratchpad"
+)
+
+// Checkpoint is the saved state of the core
+type Checkpoint struct {
+	Model       int
+	Decoder     instruction.DecoderCheckpoint
+	Inst        instruction.Checkpoint
+	Regs        scratchpad.Checkpoint
+	Stack       addressstack.Checkpoint
+	Alu         alu.Checkpoint
+	ExternalBus common.BusCheckpoint
+	InternalBus common.BusCheckpoint
+	BufferDir   int
+	Signals     []common.SignalCheckpoint // In the order of Core.signals
+	Evaluation  int                       // Pending conditional jump evaluation
+	TestLatched int
+	ResetClocks int
+	FetchPC     uint64
+	FetchOpcode uint64
+	FetchCycles int
+
+	// 4040 state
+	RomBank      uint64
+	SavedRomBank uint64
+	SavedRamBank uint64
+	SrcAddress   uint64
+	SavedSrc     uint64
+	IntEnabled   bool
+	Halted       bool
+}
+
+// signals returns all the pins of the core
+func (c *Core) signals() []*common.Signal {
+	s := []*common.Signal{&c.Sync, &c.CmROM, &c.CmROM1}
+	for i := range c.CmRAM {
+		s = append(s, &c.CmRAM[i])
+	}
+	for i := range c.CmRAMBank {
+		s = append(s, &c.CmRAMBank[i])
+	}
+	return append(s, &c.Test, &c.ResetIn, &c.Int, &c.IntAck, &c.Stop, &c.StopAck)
+}
+
+// Checkpoint returns the state of the core, to restore it later
+func (c *Core) Checkpoint() Checkpoint {
+	cp := Checkpoint{
+		Model:        c.model,
+		Decoder:      c.Decoder.Checkpoint(),
+		Inst:         c.inst.Checkpoint(),
+		Regs:         c.regs.Checkpoint(),
+		Stack:        c.as.Checkpoint(),
+		Alu:          c.alu.Checkpoint(),
+		ExternalBus:  c.ExternalDataBus.Checkpoint(),
+		InternalBus:  c.internalDataBus.Checkpoint(),
+		BufferDir:    c.busBuffer.buf.Dir,
+		Evaluation:   c.evaluation,
+		TestLatched:  c.testLatched,
+		ResetClocks:  c.resetClocks,
+		FetchPC:      c.fetchPC,
+		FetchOpcode:  c.fetchOpcode,
+		FetchCycles:  c.fetchCycles,
+		RomBank:      c.romBank,
+		SavedRomBank: c.savedRomBank,
+		SavedRamBank: c.savedRamBank,
+		SrcAddress:   c.srcAddress,
+		SavedSrc:     c.savedSrc,
+		IntEnabled:   c.intEnabled,
+		Halted:       c.halted,
+	}
+	for _, s := range c.signals() {
+		cp.Signals = append(cp.Signals, s.Checkpoint())
+	}
+	return cp
+}
+
+// CheckRestore returns the error Restore would return, without changing anything
+func (c *Core) CheckRestore(cp Checkpoint) error {
+	if cp.Model != c.model {
+		return fmt.Errorf("core: checkpoint is for model %d, the core is model %d", cp.Model, c.model)
+	}
+	if len(cp.Signals) != len(c.signals()) {
+		return fmt.Errorf("core: checkpoint has %d signals, expected %d", len(cp.Signals), len(c.signals()))
+	}
+	if err := c.Decoder.CheckRestore(cp.Decoder); err != nil {
+		return err
+	}
+	if err := c.regs.CheckRestore(cp.Regs); err != nil {
+		return err
+	}
+	return c.as.CheckRestore(cp.Stack)
+}
+
+// Restore puts the core back in a saved state. The model must be the same.
+// Nothing is changed if the checkpoint does not fit
+func (c *Core) Restore(cp Checkpoint) error {
+	if err := c.CheckRestore(cp); err != nil {
+		return err
+	}
+	signals := c.signals()
+	c.Decoder.Restore(cp.Decoder)
+	c.regs.Restore(cp.Regs)
+	c.as.Restore(cp.Stack)
+	c.inst.Restore(cp.Inst)
+	c.alu.Restore(cp.Alu)
+	c.ExternalDataBus.Restore(cp.ExternalBus)
+	c.internalDataBus.Restore(cp.InternalBus)
+	c.busBuffer.buf.Dir = cp.BufferDir
+	for i, s := range signals {
+		s.Restore(cp.Signals[i])
+	}
+	c.evaluation = cp.Evaluation
+	c.testLatched = cp.TestLatched
+	c.resetClocks = cp.ResetClocks
+	c.fetchPC = cp.FetchPC
+	c.fetchOpcode = cp.FetchOpcode
+	c.fetchCycles = cp.FetchCycles
+	c.romBank = cp.RomBank
+	c.savedRomBank = cp.SavedRomBank
+	c.savedRamBank = cp.SavedRamBank
+	c.srcAddress = cp.SrcAddress
+	c.savedSrc = cp.SavedSrc
+	c.intEnabled = cp.IntEnabled
+	c.halted = cp.Halted
+	return nil
+}
@@ -0,0 +1,79 @@
+package cpucore
+
+import (
+	"instruction"
+
+	"github.com/romana/rlog"
+)
+
+// execute4040 executes the 4040 instructions which only change the state of the core
+func (c *Core) execute4040(inst int) {
+	switch inst {
+	case instruction.HLT:
+		// Only RESET or an interrupt gets us out of here
+		c.halted = true
+	case instruction.LCR:
+		// The command register is the ROM bank in bit 3, and the RAM bank from DCL
+		c.alu.WriteAccumulatorDirect(c.romBank<<3 | c.alu.GetCurrentRamBank())
+		c.raiseRegister("ACC", c.alu.ReadAccumulatorDirect())
+	case instruction.DB0:
+		c.romBank = 0
+	case instruction.DB1:
+		c.romBank = 1
+	case instruction.SB0:
+		c.regs.SetBank(0)
+	case instruction.SB1:
+		c.regs.SetBank(1)
+	case instruction.EIN:
+		c.intEnabled = true
+	case instruction.DIN:
+		c.intEnabled = false
+	case instruction.BBS:
+		// Restore the state saved by the interrupt. The SRC address is sent again
+		// in X2 and X3
+		c.romBank = c.savedRomBank
+		c.alu.SetCurrentRamBank(c.savedRamBank)
+		c.srcAddress = c.savedSrc
+		c.IntAck.Deassert(c.name)
+		rlog.Infof("Return from interrupt: SRC=%02X", c.srcAddress)
+	}
+}
+
+// enterInterrupt saves the state the interrupt routine could clobber and jumps
+// to the interrupt routine. The address of the instruction which was replaced
+// by the interrupt is pushed onto the stack
+func (c *Core) enterInterrupt() {
+	c.as.StackPush()
+	c.raiseStack(true)
+	for i := uint64(0); i < 3; i++ {
+		c.as.WriteProgramCounterDirect(i, (InterruptAddress>>(i*4))&0xf)
+	}
+	c.savedRomBank = c.romBank
+	c.savedRamBank = c.alu.GetCurrentRamBank()
+	c.savedSrc = c.srcAddress
+	// The interrupt routine always runs from ROM bank 0
+	c.romBank = 0
+	c.IntAck.Assert(c.name)
+	rlog.Infof("Interrupt: SRC=%02X", c.savedSrc)
+}
+
+// sampleInterruptAndStop decides what the next instruction cycle does. An enabled
+// interrupt has priority, and releases a halted CPU. STOP and HLT repeat the
+// current address with NOP cycles
+func (c *Core) sampleInterruptAndStop() {
+	if !c.Decoder.AtInstructionBoundary() {
+		return
+	}
+	if c.Int.IsAsserted() && c.intEnabled && !c.IntAck.IsAsserted() {
+		c.halted = false
+		c.StopAck.Deassert(c.name)
+		c.Decoder.JamCycle(instruction.JamInterrupt)
+		return
+	}
+	if c.Stop.IsAsserted() || c.halted {
+		c.StopAck.Assert(c.name)
+		c.Decoder.JamCycle(instruction.JamStop)
+		return
+	}
+	c.StopAck.Deassert(c.name)
+}
@@ -0,0 +1,354 @@
+package cpucore
+
+import (
+	"addressstack"
+	"instruction"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func create4040Core(t *testing.T) *Core {
+	core := &Core{}
+	core.InitModel(Model4040)
+	syncSeen, _ := waitForSync(core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	return core
+}
+
+// runJammedCycle runs an interrupt or STOP cycle. Nothing drives the bus for the
+// instruction fetch, since CM-ROM is not active. The CPU should drive a NOP instead
+func runJammedCycle(core *Core, t *testing.T) (addr uint64) {
+	for i := 0; i < 8; i++ {
+		core.Calculate()
+		core.ClockIn()
+		if i < 3 {
+			addr = addr | (core.ExternalDataBus.Read() << (uint64(i) * 4))
+		}
+		core.ClockOut()
+		if core.CmROM.Level() == 0 || core.CmROM1.Level() == 0 {
+			t.Errorf("CM-ROM is active in phase %d of a jammed cycle", core.GetClockCount())
+		}
+		if (i == 2 || i == 3) && core.ExternalDataBus.Read() != instruction.NOP {
+			t.Errorf("Jammed cycle: NOP was not driven in phase %d. Got %X", core.GetClockCount(), core.ExternalDataBus.Read())
+		}
+	}
+	return
+}
+
+// fetchRomBank runs one instruction cycle and returns the CM-ROM lines in A3
+func fetchRomBank(core *Core, data uint64, t *testing.T) (cmROM int, cmROM1 int) {
+	for i := 0; i < 8; i++ {
+		core.Calculate()
+		core.ClockIn()
+		core.ClockOut()
+		if core.GetClockCount() == 2 {
+			cmROM, cmROM1 = core.CmROM.Level(), core.CmROM1.Level()
+		}
+		if i == 2 {
+			core.ExternalDataBus.Write(testDriver, (data>>4)&0xf)
+		} else if i == 3 {
+			core.ExternalDataBus.Write(testDriver, data&0xf)
+		}
+	}
+	return
+}
+
+func TestLogic4040(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestLogic4040")
+	core := create4040Core(t)
+	setRegister(core, 4, 0x5, t)
+	setRegister(core, 5, 0x3, t)
+	setRegister(core, 6, 0x6, t)
+	setRegister(core, 7, 0x9, t)
+	tests := []struct {
+		acc    uint64
+		inst   uint64
+		expAcc uint64
+	}{
+		{0xA, instruction.OR4, 0xF},
+		{0x8, instruction.OR5, 0xB},
+		{0xC, instruction.AN6, 0x4},
+		{0xF, instruction.AN7, 0x9},
+		{0x6, instruction.AN7, 0x0},
+	}
+	for _, test := range tests {
+		for carry := uint64(0); carry < 2; carry++ {
+			setCarry(core, carry, t)
+			runOneCycle(core, instruction.LDM|test.acc, t)
+			runOneCycle(core, test.inst, t)
+			// The carry is not affected
+			verifyJump(core, instruction.JCN_CARRY_SET, carry != 0, t)
+			verifyAccumulator(core, test.expAcc, t)
+		}
+	}
+}
+
+func TestLogicNot4004(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestLogicNot4004")
+	core := createPageTestCore(t)
+	// The 4040 instructions are NOPs on the 4004
+	setRegister(core, 4, 0x5, t)
+	runOneCycle(core, instruction.LDM|0xA, t)
+	addr := runOneCycle(core, instruction.OR4, t)
+	verifyAddress(core, addr+1, t)
+	verifyAccumulator(core, 0xA, t)
+}
+
+func TestRegisterBanks(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestRegisterBanks")
+	core := create4040Core(t)
+	setRegister(core, 0, 0x7, t)
+	setRegister(core, 8, 0x2, t)
+	runOneCycle(core, instruction.SB1, t)
+	setRegister(core, 0, 0x3, t)
+	verifyRegister(core, 0, 0x3, t)
+	// R8-R15 are not banked
+	verifyRegister(core, 8, 0x2, t)
+	setRegister(core, 8, 0x4, t)
+	runOneCycle(core, instruction.SB0, t)
+	verifyRegister(core, 0, 0x7, t)
+	verifyRegister(core, 8, 0x4, t)
+}
+
+func TestCommandRegister(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestCommandRegister")
+	core := create4040Core(t)
+	cmROM, cmROM1 := fetchRomBank(core, instruction.DB1, t)
+	if cmROM != 0 || cmROM1 != 1 {
+		t.Errorf("CM-ROM mismatch before DB1. Exp 0/1, got %d/%d", cmROM, cmROM1)
+	}
+	// DB1 switches the next fetch to CM-ROM1
+	cmROM, cmROM1 = fetchRomBank(core, instruction.LDM|5, t)
+	if cmROM != 1 || cmROM1 != 0 {
+		t.Errorf("CM-ROM mismatch after DB1. Exp 1/0, got %d/%d", cmROM, cmROM1)
+	}
+	runOneCycle(core, instruction.DCL, t)
+	runOneCycle(core, instruction.LDM|0, t)
+	runOneCycle(core, instruction.LCR, t)
+	verifyAccumulator(core, 0xD, t)
+
+	runOneCycle(core, instruction.DB0, t)
+	cmROM, cmROM1 = fetchRomBank(core, instruction.LCR, t)
+	if cmROM != 0 || cmROM1 != 1 {
+		t.Errorf("CM-ROM mismatch after DB0. Exp 0/1, got %d/%d", cmROM, cmROM1)
+	}
+	verifyAccumulator(core, 0x5, t)
+}
+
+// interruptAt raises INT during the instruction at the current address.
+// It returns the address the interrupt routine returns to
+func interruptAt(core *Core, t *testing.T) (retAddr uint64) {
+	core.Int.Drive("Test", 1)
+	retAddr = runOneCycle(core, instruction.NOP, t) + 1
+	addr := runJammedCycle(core, t)
+	if addr != retAddr {
+		t.Errorf("Interrupt cycle address mismatch. Exp %03X, got %03X", retAddr, addr)
+	}
+	if core.IntAck.Level() != 1 {
+		t.Error("INTA was not set in the interrupt routine")
+	}
+	return
+}
+
+func TestInterrupt(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestInterrupt")
+	core := create4040Core(t)
+	runOneCycle(core, instruction.EIN, t)
+	jumpTo(core, 0x120, t)
+	// The interrupt routine must not change the SRC address or the RAM bank
+	// seen by the main program
+	runOneCycle(core, instruction.LDM|2, t)
+	runOneCycle(core, instruction.DCL, t)
+	loadRegisterPair(core, 0x5A, 3, t)
+	runOneIOCycle(core, instruction.SRC|(3<<1), t)
+
+	retAddr := interruptAt(core, t)
+	verifyAddress(core, InterruptAddress, t)
+	// INT is still active, but the CPU is already in the interrupt routine
+	runOneCycle(core, instruction.LDM|0, t)
+	runOneCycle(core, instruction.DCL, t)
+	runOneIOCycle(core, instruction.SRC, t)
+	core.Int.Drive("Test", 0)
+	_, ioVal := runOneIOCycle(core, instruction.BBS, t)
+	if ioVal != 0xA5 {
+		t.Errorf("BBS did not send the saved SRC address. Exp A5, got %02X", ioVal)
+	}
+	if core.IntAck.Level() != 0 {
+		t.Error("INTA was not cleared by BBS")
+	}
+	verifyAddress(core, retAddr, t)
+
+	// Again, to check the CM lines of the restored RAM bank
+	retAddr = interruptAt(core, t)
+	verifyAddress(core, InterruptAddress, t)
+	runOneCycle(core, instruction.LDM|7, t)
+	runOneCycle(core, instruction.DCL, t)
+	core.Int.Drive("Test", 0)
+	verifyCmLines(core, instruction.BBS, []int{2, 6}, []int{6}, 2, t)
+	verifyAddress(core, retAddr, t)
+
+	// The interrupt routine runs from CM-ROM0, and BBS goes back to CM-ROM1
+	runOneCycle(core, instruction.DB1, t)
+	interruptAt(core, t)
+	cmROM, cmROM1 := fetchRomBank(core, instruction.DB1, t)
+	if cmROM != 0 || cmROM1 != 1 {
+		t.Errorf("CM-ROM mismatch in the interrupt routine. Exp 0/1, got %d/%d", cmROM, cmROM1)
+	}
+	core.Int.Drive("Test", 0)
+	runOneCycle(core, instruction.DB0, t)
+	runOneCycle(core, instruction.BBS, t)
+	cmROM, cmROM1 = fetchRomBank(core, instruction.NOP, t)
+	if cmROM != 1 || cmROM1 != 0 {
+		t.Errorf("CM-ROM mismatch after BBS. Exp 1/0, got %d/%d", cmROM, cmROM1)
+	}
+}
+
+func TestInterruptDisabled(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestInterruptDisabled")
+	core := create4040Core(t)
+	core.Int.Drive("Test", 1)
+	// Interrupts are disabled after RESET
+	addr := runOneCycle(core, instruction.NOP, t)
+	verifyAddress(core, addr+1, t)
+	core.Int.Drive("Test", 0)
+	runOneCycle(core, instruction.EIN, t)
+	runOneCycle(core, instruction.DIN, t)
+	core.Int.Drive("Test", 1)
+	addr = runOneCycle(core, instruction.NOP, t)
+	verifyAddress(core, addr+1, t)
+	if core.IntAck.Level() != 0 {
+		t.Error("INTA was set with interrupts disabled")
+	}
+}
+
+func TestInterruptTwoCycle(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestInterruptTwoCycle")
+	core := create4040Core(t)
+	runOneCycle(core, instruction.EIN, t)
+	// The interrupt waits for the end of the jump
+	core.Int.Drive("Test", 1)
+	runOneCycle(core, instruction.JUN|0x2, t)
+	runOneCycle(core, 0x34, t)
+	addr := runJammedCycle(core, t)
+	if addr != 0x234 {
+		t.Errorf("Interrupt cycle address mismatch. Exp 234, got %03X", addr)
+	}
+	verifyAddress(core, InterruptAddress, t)
+	core.Int.Drive("Test", 0)
+	runOneCycle(core, instruction.BBS, t)
+	verifyAddress(core, 0x234, t)
+}
+
+func TestHalt(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestHalt")
+	core := create4040Core(t)
+	runOneCycle(core, instruction.EIN, t)
+	addr := runOneCycle(core, instruction.HLT, t)
+	for i := 0; i < 3; i++ {
+		// The next address is sent again, but nothing is fetched
+		jamAddr := runJammedCycle(core, t)
+		if jamAddr != addr+1 {
+			t.Errorf("Halt address mismatch. Exp %03X, got %03X", addr+1, jamAddr)
+		}
+		if core.StopAck.Level() != 1 {
+			t.Error("STPA was not set while halted")
+		}
+	}
+	// Only an interrupt gets us out
+	core.Int.Drive("Test", 1)
+	runJammedCycle(core, t)
+	runJammedCycle(core, t)
+	if core.StopAck.Level() != 0 || core.IntAck.Level() != 1 {
+		t.Errorf("Halt was not released by the interrupt. STPA=%d, INTA=%d", core.StopAck.Level(), core.IntAck.Level())
+	}
+	verifyAddress(core, InterruptAddress, t)
+	core.Int.Drive("Test", 0)
+	runOneCycle(core, instruction.BBS, t)
+	verifyAddress(core, addr+1, t)
+}
+
+func TestStopPin(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestStopPin")
+	core := create4040Core(t)
+	core.Stop.Drive("Test", 1)
+	addr := runOneCycle(core, instruction.LDM|6, t)
+	for i := 0; i < 3; i++ {
+		jamAddr := runJammedCycle(core, t)
+		if jamAddr != addr+1 {
+			t.Errorf("Stop address mismatch. Exp %03X, got %03X", addr+1, jamAddr)
+		}
+		if core.StopAck.Level() != 1 {
+			t.Error("STPA was not set while stopped")
+		}
+	}
+	core.Stop.Drive("Test", 0)
+	// The last STOP cycle is still running when the pin is released
+	runJammedCycle(core, t)
+	verifyAddress(core, addr+1, t)
+	if core.StopAck.Level() != 0 {
+		t.Error("STPA was not cleared")
+	}
+	verifyAccumulator(core, 6, t)
+}
+
+func TestStack4040(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestStack4040")
+	core := &Core{}
+	core.InitModel(Model4040)
+	var events []addressstack.StackEvent
+	core.SetStrictStack(func(event addressstack.StackEvent) {
+		events = append(events, event)
+	})
+	waitForFirstCycle(core)
+
+	// 7 nested subroutines fit on the stack
+	for level := uint64(1); level <= StackDepth4040; level++ {
+		runOneCycle(core, instruction.JMS, t)
+		runOneCycle(core, level<<4, t)
+	}
+	for level := 0; level < StackDepth4040; level++ {
+		runOneCycle(core, instruction.BBL, t)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events, got %v", events)
+	}
+	verifyAddress(core, 2, t)
+}
+
+func TestReset4040(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestReset4040")
+	core := create4040Core(t)
+	runOneCycle(core, instruction.SB1, t)
+	setRegister(core, 0, 0x7, t)
+	runOneCycle(core, instruction.DB1, t)
+	runOneCycle(core, instruction.EIN, t)
+
+	holdReset(core, ResetClocks4040)
+	runOneCycle(core, instruction.NOP, t)
+
+	// Bank 0 and CM-ROM0 are selected, and interrupts are disabled
+	core.Int.Drive("Test", 1)
+	cmROM, cmROM1 := fetchRomBank(core, instruction.SB1, t)
+	if cmROM != 0 || cmROM1 != 1 {
+		t.Errorf("CM-ROM mismatch after RESET. Exp 0/1, got %d/%d", cmROM, cmROM1)
+	}
+	// The extra registers are cleared too
+	verifyRegister(core, 0, 0, t)
+	if core.IntAck.Level() != 0 {
+		t.Error("Interrupts were enabled after RESET")
+	}
+}
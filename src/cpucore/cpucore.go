@@ -4,6 +4,8 @@ import (
 	"addressstack"
 	"alu"
 	"common"
+	"events"
+	"fmt"
 	"instruction"
 	"scratchpad"
 
@@ -13,13 +15,42 @@ import (
 const BusWidth = 4
 const AddressWidth = 12
 const NumRegisters = 16
+const NumCmRAMLines = 4
+const NumRamBanks = 8 // Using a 3-to-8 decoder on CM-RAM1..3
+const StackDepth = 3
+
+// The 4040 has a second bank of R0-R7, and a deeper stack
+const NumRegisters4040 = 24
+const StackDepth4040 = 7
+
+// InterruptAddress is where the 4040 jumps on an interrupt
+const InterruptAddress = 0x003
+
+// The CPU models
+const (
+	Model4004 = iota
+	Model4040
+)
+
+// ResetClocks is how long the RESET pin must be held to clear the whole CPU.
+// The scratchpad is cleared one register pair per instruction cycle
+const ResetClocks = NumRegisters / 2 * 8
+const ResetClocks4040 = NumRegisters4040 / 2 * 8
 
 // Core contains all the logic components of our cpu
 type Core struct {
 	ExternalDataBus common.Bus
-	Sync            int
-	CmROM           int   // ROM select
-	CmRAM           uint8 // RAM select
+	Sync            common.Signal                // SYNC output pin (active low)
+	CmROM           common.Signal                // ROM select (active low)
+	CmROM1          common.Signal                // Second ROM bank select (4040 only, active low)
+	CmRAM           [NumCmRAMLines]common.Signal // RAM bank select lines CM-RAM0..3 (active low)
+	CmRAMBank       [NumRamBanks]common.Signal   // CM-RAM0, plus CM-RAM1..3 through a 3-to-8 decoder (active low)
+	Test            common.Signal                // TEST input pin
+	ResetIn         common.Signal                // RESET input pin (active high)
+	Int             common.Signal                // INT input pin (4040 only, active high)
+	IntAck          common.Signal                // INTA output pin. Set while in the interrupt routine (4040 only)
+	Stop            common.Signal                // STOP input pin (4040 only, active high)
+	StopAck         common.Signal                // STPA output pin. Set while stopped or halted (4040 only)
 	Decoder         instruction.Decoder
 
 	regs            scratchpad.Registers
@@ -28,20 +59,121 @@ type Core struct {
 	busBuffer       ExternalBusBuffer
 	as              addressstack.AddressStack
 	inst            instruction.Instruction
-	evaluationFn    func() bool // Conditional jump evaluation function
+	evaluation      int // Pending conditional jump evaluation (evalNone, evalJCN or evalISZ)
+	testLatched     int // TEST input pin latched with clock
+	resetClocks     int // How many clocks the RESET pin has been held
+
+	name string // The driver name of the output pins
+
+	events      *events.Dispatcher // May be nil
+	fetchPC     uint64             // Address of the current instruction
+	fetchOpcode uint64             // Opcode of the current instruction, with the second byte of two cycle instructions
+	fetchCycles int                // Instruction cycles of the current instruction. 0 if nothing was fetched
+
+	// 4040 state
+	model        int
+	numRegisters int
+	romBank      uint64 // ROM bank selected by DB0/DB1
+	savedRomBank uint64 // Command register saved by the interrupt
+	savedRamBank uint64
+	srcAddress   uint64 // Address sent by the last SRC
+	savedSrc     uint64 // SRC address saved by the interrupt
+	intEnabled   bool
+	halted       bool
 }
 
-// Init create and initialize all the core components
+// Init create and initialize all the core components as a 4004
 func (c *Core) Init() {
+	c.InitModel(Model4004)
+}
+
+// InitModel create and initialize all the core components for a CPU model
+func (c *Core) InitModel(model int) {
+	c.model = model
+	c.name = "4004"
+	c.numRegisters = NumRegisters
+	stackDepth := StackDepth
+	if model == Model4040 {
+		c.name = "4040"
+		c.numRegisters = NumRegisters4040
+		stackDepth = StackDepth4040
+	}
+	c.initSignals()
 	c.internalDataBus.Init(BusWidth, "Internal Data Bus")
 	c.ExternalDataBus.Init(BusWidth, "External Data Bus")
 	c.busBuffer.Init(&c.ExternalDataBus, &c.internalDataBus, "Bus Buffer")
-	c.regs.Init(&c.internalDataBus, BusWidth, NumRegisters)
+	c.busBuffer.buf.Owner = c
+	c.regs.Init(&c.internalDataBus, BusWidth, c.numRegisters)
 	c.alu.Init(&c.internalDataBus, BusWidth)
-	c.as.Init(&c.internalDataBus, AddressWidth, 3)
+	c.as.Init(&c.internalDataBus, AddressWidth, stackDepth)
 	c.inst.Init(&c.internalDataBus, BusWidth)
 	c.Decoder.Init()
-	c.Sync = 0
+	c.Decoder.SetModel4040(model == Model4040)
+	c.Reset()
+}
+
+// initSignals names the pins and sets their polarity and reset levels
+func (c *Core) initSignals() {
+	c.Sync.Init("SYNC", true, 1)
+	c.CmROM.Init("CM-ROM", true, 1)
+	c.CmROM1.Init("CM-ROM1", true, 1)
+	for i := range c.CmRAM {
+		c.CmRAM[i].Init(fmt.Sprintf("CM-RAM%d", i), true, 1)
+	}
+	for i := range c.CmRAMBank {
+		c.CmRAMBank[i].Init(fmt.Sprintf("CM-RAM bank %d", i), true, 1)
+	}
+	c.Test.Init("TEST", false, 0)
+	c.ResetIn.Init("RESET", false, 0)
+	c.Int.Init("INT", false, 0)
+	c.IntAck.Init("INTA", false, 0)
+	c.Stop.Init("STP", false, 0)
+	c.StopAck.Init("STPA", false, 0)
+}
+
+// DriverName returns the CPU model, to identify the core in bus collision reports
+func (c *Core) DriverName() string {
+	return c.name
+}
+
+// SetBusMonitor attaches a monitor to the internal and external data buses
+func (c *Core) SetBusMonitor(m *common.BusMonitor) {
+	c.internalDataBus.SetMonitor(m)
+	c.ExternalDataBus.SetMonitor(m)
+}
+
+// GetModel returns the CPU model
+func (c *Core) GetModel() int {
+	return c.model
+}
+
+// Reset is a power-on reset. Everything is cleared right away, as if the RESET
+// pin had been held for ResetClocks clocks
+func (c *Core) Reset() {
+	c.as.Reset()
+	c.regs.Reset()
+	c.alu.Reset()
+	c.inst.Reset()
+	c.Decoder.Reset()
+	c.evaluation = evalNone
+	c.fetchCycles = 0
+	c.testLatched = 0
+	c.resetClocks = 0
+	c.reset4040()
+	c.Sync.Deassert(c.name)
+	c.driveCmLines(false, false)
+}
+
+func (c *Core) reset4040() {
+	c.romBank = 0
+	c.savedRomBank = 0
+	c.savedRamBank = 0
+	c.srcAddress = 0
+	c.savedSrc = 0
+	c.intEnabled = false
+	c.halted = false
+	c.IntAck.Deassert(c.name)
+	c.StopAck.Deassert(c.name)
 }
 
 func (c *Core) GetClockCount() int {
@@ -56,6 +188,12 @@ func (c *Core) GetProgramCounter() uint64 {
 	return c.as.GetProgramCounter()
 }
 
+// SetStrictStack reports address stack overflows and underflows to the handler.
+// A nil handler lets the stack wrap around silently like the real CPU
+func (c *Core) SetStrictStack(handler func(event addressstack.StackEvent)) {
+	c.as.SetStrictMode(handler)
+}
+
 func (c *Core) LogScratchPadRegisters() {
 	c.regs.Log()
 }
@@ -64,28 +202,32 @@ func (c *Core) getDecoderFlag(index int) int {
 	return c.Decoder.Flags[index].Value
 }
 
-// Calculate the internal logic before the next clock edge
-func (c *Core) Calculate() {
-	c.Decoder.CalculateFlags()
+// OwnsBuses marks the core as the owner of the external data bus. It resets the
+// bus in ClockOut, so it is clocked before the ROMs and RAMs
+func (c *Core) OwnsBuses() {
 }
 
-// UpdateInternalBus is just for the renderer to show the internal bus updated
-// after an external device puts data on the external bus
-func (c *Core) UpdateInternalBus() {
-	c.internalDataBus.Reset()
-	// Load the data from the external bus if needed
-	// MAKE SURE NONE ELSE IS DRIVING THE INTERNAL BUS!!!
-	if c.getDecoderFlag(instruction.BusDir) == common.DirIn &&
-		c.getDecoderFlag(instruction.PCOut) == 0 &&
-		c.getDecoderFlag(instruction.AccOut) == 0 &&
-		c.getDecoderFlag(instruction.TempOut) == 0 &&
-		c.getDecoderFlag(instruction.ScratchPadOut) == 0 {
-		c.busBuffer.buf.AtoB()
+// Calculate the internal logic before the next clock edge
+func (c *Core) Calculate() {
+	if c.ResetIn.IsAsserted() {
+		c.Decoder.CalculateResetFlags()
+		return
 	}
+	c.Decoder.CalculateFlags()
 }
 
 // ClockIn clock in external inputs to the core
 func (c *Core) ClockIn() {
+	c.clearDrivingBus()
+	if c.ResetIn.IsAsserted() {
+		c.clockReset()
+		return
+	}
+	c.resetClocks = 0
+	// φ1 and φ2 are separate bus cycles for collision detection
+	c.internalDataBus.Reset()
+	carry := c.alu.GetFlags().Carry
+
 	// Load the data from the external bus if needed
 	if c.getDecoderFlag(instruction.BusDir) == common.DirIn {
 		c.busBuffer.buf.AtoB()
@@ -94,17 +236,22 @@ func (c *Core) ClockIn() {
 	if c.getDecoderFlag(instruction.ScratchPadIndex) >= 0 {
 		c.regs.Select(c.getDecoderFlag(instruction.ScratchPadIndex))
 	}
+	if c.getDecoderFlag(instruction.SampleTest) != 0 {
+		c.testLatched = c.Test.Level()
+	}
 	if c.getDecoderFlag(instruction.InstRegLoad) != 0 {
 		// Read the OPR from the external bus and write it into the instruction register
 		c.inst.Write()
 	}
 
 	if c.getDecoderFlag(instruction.DecodeInstruction) != 0 {
+		c.fetched()
 		evalResult := true
-		if c.evaluationFn != nil {
-			evalResult = c.evaluationFn()
+		if c.evaluation != evalNone {
+			evalResult = c.evaluate()
+			c.raiseJump(evalResult)
 		}
-		c.evaluationFn = nil
+		c.evaluation = evalNone
 
 		// Write the completed instruction to the decoder
 		c.Decoder.SetCurrentInstruction(c.inst.GetInstructionRegister(), evalResult)
@@ -121,11 +268,16 @@ func (c *Core) ClockIn() {
 	}
 	if c.getDecoderFlag(instruction.AccInst) >= 0 {
 		c.alu.ExectuteAccInst(uint64(c.getDecoderFlag(instruction.AccInst)))
+		c.raiseAccumulator(carry)
+	}
+	if c.getDecoderFlag(instruction.ExtInst) >= 0 {
+		c.execute4040(c.getDecoderFlag(instruction.ExtInst))
 	}
 
 	// Finally, any internal bus loads. Do this last to make sure the bus has valid data
 	if c.getDecoderFlag(instruction.AccLoad) != 0 {
 		c.alu.WriteAccumulator()
+		c.raiseAccumulator(carry)
 	}
 	if c.getDecoderFlag(instruction.TempLoad) != 0 {
 		c.alu.WriteTemp()
@@ -135,12 +287,28 @@ func (c *Core) ClockIn() {
 	}
 	if c.getDecoderFlag(instruction.ScratchPadLoad4) != 0 {
 		c.regs.Write()
+		c.raiseIndexRegister()
 	}
 	if c.getDecoderFlag(instruction.StackPush) != 0 {
 		c.as.StackPush()
+		c.raiseStack(true)
 	}
 	if c.getDecoderFlag(instruction.StackPop) != 0 {
 		c.as.StackPop()
+		c.raiseStack(false)
+	}
+	if c.getDecoderFlag(instruction.Interrupt) != 0 {
+		c.enterInterrupt()
+	}
+
+	if c.getDecoderFlag(instruction.SampleTest) != 0 && c.Decoder.AtInstructionBoundary() {
+		c.retired()
+	}
+
+	// The 4040 checks INT and STOP when the TEST pin is sampled, after the
+	// current instruction has had a chance to change the state (EIN, HLT, ...)
+	if c.getDecoderFlag(instruction.SampleTest) != 0 && c.model == Model4040 {
+		c.sampleInterruptAndStop()
 	}
 }
 
@@ -155,11 +323,22 @@ func (c *Core) ClockOut() {
 	c.busBuffer.buf.Disable()
 
 	if c.getDecoderFlag(instruction.Sync) != 0 {
-		c.Sync = 0
+		c.Sync.Assert(c.name)
 		c.inst.Reset()
 	} else {
-		c.Sync = 1
+		c.Sync.Deassert(c.name)
+	}
+
+	cmROM := c.getDecoderFlag(instruction.CmROMOut) != 0
+	cmRAM := c.getDecoderFlag(instruction.CmRAMOut) != 0
+	// The CM lines are also active in M2 of an I/O instruction. Only the upper
+	// 4 bits of the instruction have been loaded at this point, but that is all we need
+	if c.Decoder.GetClockCount() == 4 && !c.Decoder.IsSecondCycle() &&
+		(c.inst.GetInstructionRegister()&0xf0) == instruction.IO {
+		cmROM = true
+		cmRAM = true
 	}
+	c.driveCmLines(cmROM, cmRAM)
 
 	if c.getDecoderFlag(instruction.PCInc) != 0 {
 		c.as.IncProgramCounter()
@@ -178,8 +357,22 @@ func (c *Core) ClockOut() {
 	if c.getDecoderFlag(instruction.ScratchPadOut) != 0 {
 		c.regs.Read()
 	}
+	if c.getDecoderFlag(instruction.SrcLatch) != 0 {
+		// Keep the address sent by SRC for the interrupt routine
+		shift := uint64(c.getDecoderFlag(instruction.SrcLatch)-1) * 4
+		c.srcAddress = c.srcAddress&^(0xf<<shift) | c.internalDataBus.Read()<<shift
+	}
+	if c.getDecoderFlag(instruction.SrcOut) != 0 {
+		shift := uint64(c.getDecoderFlag(instruction.SrcOut)-1) * 4
+		c.internalDataBus.Write(c, (c.srcAddress>>shift)&0xf)
+	}
+	if c.getDecoderFlag(instruction.NopOut) != 0 {
+		// A jammed cycle. Make sure nobody decodes the instruction from ROM
+		c.internalDataBus.Write(c, instruction.NOP)
+	}
 	if c.getDecoderFlag(instruction.ScratchPadInc) != 0 {
 		c.regs.Inc()
+		c.raiseIndexRegister()
 	}
 
 	if c.getDecoderFlag(instruction.BusDir) == common.DirOut {
@@ -206,34 +399,98 @@ func (c *Core) ClockOut() {
 
 	// Condtitional evaluation flags
 	if c.getDecoderFlag(instruction.EvalulateJCN) != 0 {
-		c.evaluationFn = c.evalulateJCN
+		c.evaluation = evalJCN
 	}
 	if c.getDecoderFlag(instruction.EvalulateISZ) != 0 {
-		c.evaluationFn = c.evalulateISZ
+		c.evaluation = evalISZ
 	}
 }
 
+// clockReset clocks the core while the RESET pin is held. The scratchpad is only
+// cleared one register pair per instruction cycle. Everything else is cleared
+// right away
+func (c *Core) clockReset() {
+	c.as.Reset()
+	c.alu.Reset()
+	c.inst.Reset()
+	c.evaluation = evalNone
+	c.fetchCycles = 0
+	c.testLatched = 0
+	c.reset4040()
+	pair := (c.resetClocks / 8) % (c.numRegisters / 2)
+	c.regs.ResetPair(pair)
+	if c.resetClocks < c.numRegisters/2*8 {
+		c.resetClocks++
+	}
+	rlog.Tracef(0, "RESET: clock %d, cleared register pair %d", c.resetClocks, pair)
+}
+
+// Conditional jump evaluations
+const (
+	evalNone = iota
+	evalJCN
+	evalISZ
+)
+
+// evaluate returns the result of the pending conditional jump evaluation
+func (c *Core) evaluate() bool {
+	if c.evaluation == evalISZ {
+		return c.evalulateISZ()
+	}
+	return c.evalulateJCN()
+}
+
 // If these functions return false, conditional jumps are blocked
 func (c *Core) evalulateJCN() bool {
-	// Not sure how the real CPU does this, so I am cutting corners here
 	condititonFlags := c.alu.ReadTempDirect()
 	aluFlags := c.alu.GetFlags()
-	// testBitFlag := int(condititonFlags & 0x1)
+	testBitFlag := int(condititonFlags & 0x1)
 	carryBitFlag := int((condititonFlags >> 1) & 0x1)
 	zeroBitFlag := int((condititonFlags >> 2) & 0x1)
 	invertBitFlag := int((condititonFlags >> 3) & 0x1)
-	result := true
-	if invertBitFlag == 0 {
-		result = ((carryBitFlag == 0) || (aluFlags.Carry == 1)) &&
-			((zeroBitFlag == 0) || (aluFlags.Zero == 1))
-	} else {
-		result = ((carryBitFlag == 1) && (aluFlags.Carry == 0)) ||
-			((zeroBitFlag == 1) && (aluFlags.Zero == 0))
+	// Any of the selected conditions is enough, and the invert bit inverts the
+	// result. So JCN 0 never jumps, and JCN 8 always does
+	// NOTE: the test condition is true when the TEST pin is 0
+	result := ((carryBitFlag == 1) && (aluFlags.Carry == 1)) ||
+		((zeroBitFlag == 1) && (aluFlags.Zero == 1)) ||
+		((testBitFlag == 1) && (c.testLatched == 0))
+	if invertBitFlag == 1 {
+		result = !result
 	}
-	rlog.Debugf("evalulateJCN: conditionalFlags=%X, aluFlags=%v. Result=%v", condititonFlags, aluFlags, result)
+	rlog.Debugf("evalulateJCN: conditionalFlags=%X, aluFlags=%v, test=%d. Result=%v",
+		condititonFlags, aluFlags, c.testLatched, result)
 	return result
 }
 
+// driveCmLines drives the CM-ROM and CM-RAM lines. All the lines are active low.
+// The CM-RAM lines are selected by the last DCL instruction. A value of 0 selects
+// CM-RAM0. Otherwise, bits 0-2 select CM-RAM1..3, so 3, 5, 6 and 7 activate more
+// than one line. These combinations are meant to drive an external 3-to-8 decoder
+func (c *Core) driveCmLines(cmROM bool, cmRAM bool) {
+	// DB1 moves everything to CM-ROM1 on the 4040
+	c.CmROM.Set(c.name, cmROM && c.romBank == 0)
+	c.CmROM1.Set(c.name, cmROM && c.romBank != 0)
+
+	bank := c.alu.GetCurrentRamBank() & 0x7
+	for i := range c.CmRAM {
+		active := false
+		if cmRAM {
+			if i == 0 {
+				active = bank == 0
+			} else {
+				active = (bank>>uint(i-1))&0x1 != 0
+			}
+		}
+		c.CmRAM[i].Set(c.name, active)
+	}
+	for i := range c.CmRAMBank {
+		c.CmRAMBank[i].Set(c.name, cmRAM && uint64(i) == bank)
+	}
+	if cmRAM {
+		rlog.Tracef(0, "CM-RAM: bank=%d", bank)
+	}
+}
+
 func (c *Core) evalulateISZ() bool {
 	condition := c.regs.IsCurrentRegisterZero()
 	rlog.Debugf("evalulateISZ: Result=%v", condition)
@@ -242,14 +499,10 @@ func (c *Core) evalulateISZ() bool {
 
 // ExternalBusBuffer is the buffer that connects the internal and external data busses
 type ExternalBusBuffer struct {
-	buf    common.Buffer
-	busInt *common.Bus
-	busExt *common.Bus
+	buf common.Buffer
 }
 
 // Init ...
 func (b *ExternalBusBuffer) Init(busExt *common.Bus, busInt *common.Bus, name string) {
 	b.buf.Init(busExt, busInt, BusWidth, name)
-	b.busExt = busExt
-	b.busInt = busInt
 }
@@ -0,0 +1,93 @@
+package cpucore
+
+import (
+	"events"
+	"fmt"
+)
+
+// SetEvents raises the CPU events, and the data bus events, through the dispatcher
+func (c *Core) SetEvents(d *events.Dispatcher) {
+	c.events = d
+	c.internalDataBus.SetEvents(d)
+	c.ExternalDataBus.SetEvents(d)
+}
+
+// fetched is called when the instruction register is decoded. The address
+// and the first byte are kept for the retire and jump events
+func (c *Core) fetched() {
+	if c.Decoder.IsJamCycle() {
+		return
+	}
+	inst := c.inst.GetInstructionRegister()
+	if c.Decoder.IsSecondCycle() {
+		c.fetchOpcode = c.fetchOpcode<<8 | inst
+		c.fetchCycles++
+		return
+	}
+	c.fetchPC = c.as.GetProgramCounter()
+	c.fetchOpcode = inst
+	c.fetchCycles = 1
+	if c.events.Wants(events.KindFetch) {
+		c.events.Raise(events.Fetch{PC: c.fetchPC, Opcode: inst})
+	}
+}
+
+// retired is called in X3 at an instruction boundary
+func (c *Core) retired() {
+	if c.fetchCycles == 0 {
+		// A jammed cycle
+		return
+	}
+	if c.events.Wants(events.KindRetire) {
+		c.events.Raise(events.Retire{PC: c.fetchPC, Opcode: c.fetchOpcode, Cycles: c.fetchCycles})
+	}
+	c.fetchCycles = 0
+}
+
+func (c *Core) raiseJump(taken bool) {
+	if c.events.Wants(events.KindJump) {
+		c.events.Raise(events.Jump{PC: c.fetchPC, Opcode: c.fetchOpcode >> 8, Taken: taken})
+	}
+}
+
+func (c *Core) raiseStack(push bool) {
+	kind := events.KindStackPop
+	if push {
+		kind = events.KindStackPush
+	}
+	if c.events.Wants(kind) {
+		c.events.Raise(events.StackOp{Push: push, Address: c.as.GetProgramCounter()})
+	}
+}
+
+func (c *Core) raiseRegister(name string, value uint64) {
+	if c.events.Wants(events.KindRegister) {
+		c.events.Raise(events.RegisterWrite{Register: name, Value: value})
+	}
+}
+
+// raiseIndexRegister raises a register event for the selected index register
+func (c *Core) raiseIndexRegister() {
+	if !c.events.Wants(events.KindRegister) {
+		return
+	}
+	index := c.regs.GetIndex()
+	name := fmt.Sprintf("R%d", index)
+	if index >= NumRegisters {
+		// The second bank of R0-R7 on the 4040
+		name = fmt.Sprintf("R%d'", index-NumRegisters)
+	}
+	c.raiseRegister(name, c.regs.ReadDirect())
+}
+
+// raiseAccumulator raises a register event for the accumulator, and one for
+// the carry if it changed
+func (c *Core) raiseAccumulator(carry int) {
+	if !c.events.Wants(events.KindRegister) {
+		return
+	}
+	c.raiseRegister("ACC", c.alu.ReadAccumulatorDirect())
+	if flags := c.alu.GetFlags(); flags.Carry != carry {
+		c.raiseRegister("CY", uint64(flags.Carry))
+	}
+}
@@ -0,0 +1,202 @@
+package cpucore
+
+import (
+	"instruction"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func TestIOWrite(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestIOWrite")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	insts := []uint64{instruction.WRM, instruction.WMP, instruction.WRR, instruction.WPM,
+		instruction.WR0, instruction.WR1, instruction.WR2, instruction.WR3}
+	for i, inst := range insts {
+		accumVal := uint64(i + 3)
+		runOneCycle(&core, uint64(instruction.LDM|accumVal), t)
+		// The accumulator should be driven on the external bus in X2
+		_, ioVal := runOneIOCycle(&core, inst, t)
+		if (ioVal & 0xf) != accumVal {
+			t.Errorf("Inst %02X: I/O write val %X was not equal to %X", inst, ioVal&0xf, accumVal)
+		}
+		// The accumulator should not be modified
+		verifyAccumulator(&core, accumVal, t)
+	}
+}
+
+func TestIORead(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestIORead")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	insts := []uint64{instruction.RDM, instruction.RDR,
+		instruction.RD0, instruction.RD1, instruction.RD2, instruction.RD3}
+	for i, inst := range insts {
+		ioData := uint64(i + 7)
+		runOneIOReadCycle(&core, inst, &ioData, t)
+		// The value read from the external bus should be in the accumulator
+		verifyAccumulator(&core, ioData, t)
+	}
+}
+
+func TestADM(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestADM")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	accumVal := uint64(0x5)
+	ioData := uint64(0x3)
+	runOneCycle(&core, uint64(instruction.LDM|accumVal), t)
+	runOneIOReadCycle(&core, instruction.ADM, &ioData, t)
+	// The carry bit should NOT be set. Test it with a jump
+	verifyJump(&core, uint64(instruction.JCN_CARRY_SET), false, t)
+	verifyAccumulator(&core, accumVal+ioData, t)
+
+	// Now cause a carry
+	accumVal = uint64(0xe)
+	runOneCycle(&core, uint64(instruction.LDM|accumVal), t)
+	runOneIOReadCycle(&core, instruction.ADM, &ioData, t)
+	verifyJump(&core, uint64(instruction.JCN_CARRY_SET), true, t)
+	verifyAccumulator(&core, (accumVal+ioData)&0xf, t)
+}
+
+func TestSBM(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestSBM")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	accumVal := uint64(0x9)
+	ioData := uint64(0x3)
+	runOneCycle(&core, uint64(instruction.LDM|accumVal), t)
+	runOneIOReadCycle(&core, instruction.SBM, &ioData, t)
+	// There was no borrow, so the carry bit should be set. Test it with a jump
+	verifyJump(&core, uint64(instruction.JCN_CARRY_SET), true, t)
+	verifyAccumulator(&core, accumVal-ioData, t)
+}
+
+// runOneCmCycle runs one instruction cycle, and captures the CM lines in each clock phase
+func runOneCmCycle(core *Core, data uint64, t *testing.T) (cmROM [8]int, cmRAM [8][NumCmRAMLines]int, cmRAMBank [8][NumRamBanks]int) {
+	for i := 0; i < 8; i++ {
+		core.Calculate()
+		core.ClockIn()
+		core.ClockOut()
+		phase := core.GetClockCount()
+		cmROM[phase] = core.CmROM.Level()
+		for j := range core.CmRAM {
+			cmRAM[phase][j] = core.CmRAM[j].Level()
+		}
+		for j := range core.CmRAMBank {
+			cmRAMBank[phase][j] = core.CmRAMBank[j].Level()
+		}
+		if i == 2 {
+			core.ExternalDataBus.Write(testDriver, (data>>4)&0xf)
+		} else if i == 3 {
+			core.ExternalDataBus.Write(testDriver, data&0xf)
+		}
+	}
+	return
+}
+
+// expectedCmRAM returns the CM-RAM lines DCL should select for a bank (active low)
+func expectedCmRAM(bank uint64) (lines [NumCmRAMLines]int) {
+	lines = [NumCmRAMLines]int{1, 1, 1, 1}
+	switch bank {
+	case 0:
+		lines[0] = 0
+	case 1:
+		lines[1] = 0
+	case 2:
+		lines[2] = 0
+	case 3:
+		lines[1], lines[2] = 0, 0
+	case 4:
+		lines[3] = 0
+	case 5:
+		lines[1], lines[3] = 0, 0
+	case 6:
+		lines[2], lines[3] = 0, 0
+	case 7:
+		lines[1], lines[2], lines[3] = 0, 0, 0
+	}
+	return
+}
+
+func verifyCmLines(core *Core, inst uint64, romPhases []int, ramPhases []int, bank uint64, t *testing.T) {
+	cmROM, cmRAM, cmRAMBank := runOneCmCycle(core, inst, t)
+	for phase := 0; phase < 8; phase++ {
+		expROM := 1
+		for _, p := range romPhases {
+			if p == phase {
+				expROM = 0
+			}
+		}
+		if cmROM[phase] != expROM {
+			t.Errorf("Inst %02X: CM-ROM mismatch in phase %d. Exp %d, got %d", inst, phase, expROM, cmROM[phase])
+		}
+		expRAM := expectedCmRAM(bank)
+		expBank := [NumRamBanks]int{1, 1, 1, 1, 1, 1, 1, 1}
+		expBank[bank] = 0
+		ramActive := false
+		for _, p := range ramPhases {
+			if p == phase {
+				ramActive = true
+			}
+		}
+		if !ramActive {
+			expRAM = [NumCmRAMLines]int{1, 1, 1, 1}
+			expBank = [NumRamBanks]int{1, 1, 1, 1, 1, 1, 1, 1}
+		}
+		if cmRAM[phase] != expRAM {
+			t.Errorf("Inst %02X: CM-RAM mismatch in phase %d. Exp %v, got %v", inst, phase, expRAM, cmRAM[phase])
+		}
+		if cmRAMBank[phase] != expBank {
+			t.Errorf("Inst %02X: CM-RAM bank mismatch in phase %d. Exp %v, got %v", inst, phase, expBank, cmRAMBank[phase])
+		}
+	}
+}
+
+func TestCmLines(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestCmLines")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	// A normal instruction only selects the ROM in A3
+	verifyCmLines(&core, instruction.NOP, []int{2}, []int{}, 0, t)
+
+	for bank := uint64(0); bank < NumRamBanks; bank++ {
+		runOneCycle(&core, uint64(instruction.LDM|bank), t)
+		runOneCycle(&core, uint64(instruction.DCL), t)
+		// SRC selects both in X2
+		verifyCmLines(&core, instruction.SRC, []int{2, 6}, []int{6}, bank, t)
+		// I/O instructions select both in M2
+		verifyCmLines(&core, instruction.WRM, []int{2, 4}, []int{4}, bank, t)
+		verifyCmLines(&core, instruction.RDR, []int{2, 4}, []int{4}, bank, t)
+	}
+
+	// The second byte of a two cycle instruction is not an I/O instruction
+	runOneCycle(&core, uint64(instruction.JUN), t)
+	verifyCmLines(&core, instruction.WRM, []int{2}, []int{}, 0, t)
+}
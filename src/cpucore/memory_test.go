@@ -1,6 +1,8 @@
 package cpucore
 
 import (
+	"addressstack"
+	"common"
 	"instruction"
 	"os"
 	"testing"
@@ -20,13 +22,15 @@ func SetupLogger() {
 	}
 }
 
+const testDriver = common.NamedDriver("Test ROM")
+
 func DumpState(core Core) {
 	if enableLog {
 		rlog.Infof("PC=%X, DBUS=%X, INST=%X, SYNC=%d, CCLK=%d",
 			core.GetProgramCounter(),
 			core.ExternalDataBus.Read(),
 			core.GetInstructionRegister(),
-			core.Sync, core.GetClockCount())
+			core.Sync.Level(), core.GetClockCount())
 	}
 }
 
@@ -43,12 +47,46 @@ func TestSync(t *testing.T) {
 	}
 }
 
+func TestSignalEdges(t *testing.T) {
+	SetupLogger()
+	core := Core{}
+	core.Init()
+	syncStarts, cmROMStarts, cmRAMEdges := 0, 0, 0
+	core.Sync.OnEdge(common.EdgeFalling, func(s *common.Signal, edge int) {
+		syncStarts++
+		if s.Driver() != "4004" || !s.IsAsserted() {
+			t.Errorf("SYNC mismatch. Driver=%s, asserted=%v", s.Driver(), s.IsAsserted())
+		}
+	})
+	core.CmROM.OnEdge(common.EdgeFalling, func(s *common.Signal, edge int) {
+		cmROMStarts++
+	})
+	for i := range core.CmRAM {
+		core.CmRAM[i].OnEdge(common.EdgeRising, func(s *common.Signal, edge int) {
+			cmRAMEdges++
+		})
+	}
+	waitForSync(&core)
+	syncStarts, cmROMStarts = 0, 0
+	for i := 0; i < 4; i++ {
+		runOneCmCycle(&core, instruction.NOP, t)
+	}
+	// One SYNC and one CM-ROM pulse per cycle, and no CM-RAM glitches
+	if syncStarts != 4 || cmROMStarts != 4 || cmRAMEdges != 0 {
+		t.Errorf("Edge mismatch. SYNC=%d, CM-ROM=%d, CM-RAM=%d", syncStarts, cmROMStarts, cmRAMEdges)
+	}
+	if core.Sync.EdgeCount(common.EdgeRising) != core.Sync.EdgeCount(common.EdgeFalling) {
+		t.Errorf("SYNC edge count mismatch. Rising=%d, falling=%d",
+			core.Sync.EdgeCount(common.EdgeRising), core.Sync.EdgeCount(common.EdgeFalling))
+	}
+}
+
 func waitForSync(core *Core) (syncSeen bool, count int) {
 	for i := 0; i < 16; i++ {
 		core.Calculate()
 		core.ClockIn()
 		core.ClockOut()
-		if core.Sync == 0 {
+		if core.Sync.Level() == 0 {
 			if !syncSeen {
 				syncSeen = true
 				count = 0
@@ -72,6 +110,12 @@ func runOneCycle(core *Core, data uint64, t *testing.T) (addr uint64) {
 }
 
 func runOneIOCycle(core *Core, data uint64, t *testing.T) (addr uint64, ioVal uint64) {
+	return runOneIOReadCycle(core, data, nil, t)
+}
+
+// runOneIOReadCycle is the same as runOneIOCycle, but if ioData is not nil,
+// it will be driven on the external bus during X2 for I/O reads
+func runOneIOReadCycle(core *Core, data uint64, ioData *uint64, t *testing.T) (addr uint64, ioVal uint64) {
 	addr = 0
 	for i := 0; i < 8; i++ {
 		DumpState(*core)
@@ -89,10 +133,13 @@ func runOneIOCycle(core *Core, data uint64, t *testing.T) (addr uint64, ioVal ui
 		core.ClockOut()
 		if i == 2 {
 			rlog.Debugf("runOneCycle: Writing upper data %X", (data>>4)&0xf)
-			core.ExternalDataBus.Write((data >> 4) & 0xf)
+			core.ExternalDataBus.Write(testDriver, (data>>4)&0xf)
 		} else if i == 3 {
 			rlog.Debugf("runOneCycle: Writing lower data %X", data&0xf)
-			core.ExternalDataBus.Write(data & 0xf)
+			core.ExternalDataBus.Write(testDriver, data&0xf)
+		} else if i == 5 && ioData != nil {
+			rlog.Debugf("runOneCycle: Writing I/O data %X", *ioData&0xf)
+			core.ExternalDataBus.Write(testDriver, *ioData&0xf)
 		}
 	}
 	return
@@ -139,9 +186,14 @@ func TestJCN(t *testing.T) {
 	if !syncSeen {
 		t.Fatal("Sync was not seen")
 	}
-	// No flags set, should jump
+	// No flags set, should not jump
 	conditionFlags := uint64(0)
-	jumpExpected := true
+	jumpExpected := false
+	verifyJump(&core, instruction.JCN|conditionFlags, jumpExpected, t)
+
+	// No flags set, inverted, should jump
+	conditionFlags = uint64(8)
+	jumpExpected = true
 	verifyJump(&core, instruction.JCN|conditionFlags, jumpExpected, t)
 
 	// Carry bit should not be set, no jump
@@ -169,6 +221,36 @@ func TestJCN(t *testing.T) {
 	// TODO: Carry Tests
 }
 
+func TestJCNTestPin(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestJCNTestPin")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	// The test condition is true when the TEST pin is 0
+	core.Test.Drive("Test", 0)
+	verifyJump(&core, instruction.JCN_TEST_SET, true, t)
+	verifyJump(&core, instruction.JCN_TEST_UNSET, false, t)
+
+	core.Test.Drive("Test", 1)
+	verifyJump(&core, instruction.JCN_TEST_SET, false, t)
+	verifyJump(&core, instruction.JCN_TEST_UNSET, true, t)
+
+	// Combined with the zero flag, either condition is enough. The accumulator
+	// is zero after reset
+	verifyJump(&core, instruction.JCN_TEST_SET|instruction.JCN_ZERO_SET, true, t)
+	verifyJump(&core, instruction.JCN_TEST_UNSET|instruction.JCN_ZERO_UNSET, false, t)
+	runOneCycle(&core, instruction.LDM|5, t)
+	verifyJump(&core, instruction.JCN_TEST_SET|instruction.JCN_ZERO_SET, false, t)
+	verifyJump(&core, instruction.JCN_TEST_UNSET|instruction.JCN_ZERO_UNSET, true, t)
+	core.Test.Drive("Test", 0)
+	verifyJump(&core, instruction.JCN_TEST_SET|instruction.JCN_ZERO_SET, true, t)
+	verifyJump(&core, instruction.JCN_TEST_UNSET|instruction.JCN_ZERO_UNSET, false, t)
+}
+
 func verifyJump(core *Core, instruction uint64, jumpExpected bool, t *testing.T) {
 	verifyJumpExtended(core, instruction, jumpExpected, false, t)
 }
@@ -212,14 +294,16 @@ func verifyJumpExtended(core *Core, instruction uint64, jumpExpected bool, exten
 
 }
 
+// loadRegisterPair loads a byte into a register pair. Like the real CPU, the
+// even register holds the upper 4 bits
 func loadRegisterPair(core *Core, data uint8, regPair int, t *testing.T) (nextAddr uint64) {
-	// Load the accumulator with the lower 4 bits
-	nextAddr = runOneCycle(core, uint64(instruction.LDM|(data&0xf)), t)
-	// Swap the accumulator with the lower register pair
-	nextAddr = runOneCycle(core, uint64(instruction.XCH|(regPair<<1)), t)
 	// Load the accumulator with the higher 4 bits
 	nextAddr = runOneCycle(core, uint64(instruction.LDM|((data>>4)&0xf)), t)
-	// Swap the accumulator with the lower register pair
+	// Swap the accumulator with the even register of the pair
+	nextAddr = runOneCycle(core, uint64(instruction.XCH|(regPair<<1)), t)
+	// Load the accumulator with the lower 4 bits
+	nextAddr = runOneCycle(core, uint64(instruction.LDM|(data&0xf)), t)
+	// Swap the accumulator with the odd register of the pair
 	nextAddr = runOneCycle(core, uint64(instruction.XCH|((regPair<<1)+1)), t)
 	return nextAddr
 }
@@ -234,13 +318,15 @@ func TestSRC(t *testing.T) {
 		t.Fatal("Sync was not seen")
 	}
 	regPair := 2
-	expSrcVal := uint64(0xd)
+	expSrcVal := uint64(0xd4)
 	// Populate the scratch registers with out expected value
-	loadRegisterPair(&core, 0xd, regPair, t)
+	loadRegisterPair(&core, uint8(expSrcVal), regPair, t)
 	core.LogScratchPadRegisters()
 
 	// Run the SRC command
-	_, srcVal := runOneIOCycle(&core, uint64(instruction.SRC|(regPair<<1)), t)
+	_, ioVal := runOneIOCycle(&core, uint64(instruction.SRC|(regPair<<1)), t)
+	// The upper 4 bits are sent in X2, and the lower 4 bits in X3
+	srcVal := ((ioVal & 0xf) << 4) | (ioVal >> 4)
 	if expSrcVal != srcVal {
 		t.Errorf("SRC val %X was not equal to %X", srcVal, expSrcVal)
 	}
@@ -262,9 +348,24 @@ func TestFIM(t *testing.T) {
 	// The first cycle sets up the register pair to load into
 	runOneCycle(&core, uint64(instruction.FIM|(regPair<<1)), t)
 	// The second cycle provides the data to load
-	runOneCycle(&core, uint64(romValue), t)
+	addr := runOneCycle(&core, uint64(romValue), t)
 
 	core.LogScratchPadRegisters()
+
+	// The data is fetched from the next address, and we should just continue on
+	expAddr := addr + 1
+	addr = runOneCycle(&core, uint64(instruction.NOP), t)
+	if addr != expAddr {
+		t.Errorf("Continue address mismatch. Exp %X, got %X", expAddr, addr)
+	}
+
+	// The upper 4 bits go into the even register of the pair
+	verifyRegister(&core, uint64(regPair<<1), uint64(romValue>>4), t)
+	verifyRegister(&core, uint64(regPair<<1)+1, uint64(romValue&0xf), t)
+
+	// The data byte should not have been executed as an instruction.
+	// 0xDE would be LDM E, so the accumulator should still be 0
+	verifyAccumulator(&core, 0, t)
 }
 
 func TestFIN(t *testing.T) {
@@ -278,7 +379,7 @@ func TestFIN(t *testing.T) {
 	}
 	regPair := 2
 	romAddr := uint8(0xde)
-	romData := uint8(0x77)
+	romData := uint8(0x4b)
 
 	// Populate scratch registers pair 0 with out expected address
 	loadRegisterPair(&core, romAddr, 0, t)
@@ -297,10 +398,18 @@ func TestFIN(t *testing.T) {
 	}
 
 	// Run a final cycle to see where the program counter ended up
-	addr = runOneCycle(&core, uint64(romData), t)
+	addr = runOneCycle(&core, uint64(instruction.NOP), t)
 	if addr != uint64(expAddr) {
 		t.Errorf("Continue address mismatch. Exp %X, got %X", expAddr, addr)
 	}
+
+	// The upper 4 bits go into the even register of the pair
+	verifyRegister(&core, uint64(regPair<<1), uint64(romData>>4), t)
+	verifyRegister(&core, uint64(regPair<<1)+1, uint64(romData&0xf), t)
+
+	// Make sure the instructions after FIN are decoded normally
+	runOneCycle(&core, uint64(instruction.LDM|0x5), t)
+	verifyAccumulator(&core, 0x5, t)
 }
 
 func TestJIN(t *testing.T) {
@@ -381,8 +490,8 @@ func TestBBL(t *testing.T) {
 	if addr != uint64(expAddr) {
 		t.Errorf("Continue address mismatch. Exp %X, got %X", expAddr, addr)
 	}
-	// Now we should be back where we started
-	expAddr = 1
+	// Now we should be back after the JMS instruction
+	expAddr = 6
 	addr = runOneCycle(&core, uint64(instruction.NOP), t)
 	if addr != uint64(expAddr) {
 		t.Errorf("Continue address mismatch. Exp %X, got %X", expAddr, addr)
@@ -390,6 +499,86 @@ func TestBBL(t *testing.T) {
 	verifyAccumulator(&core, accumVal, t)
 }
 
+// waitForFirstCycle runs the core up to its first instruction cycle, so a
+// program can start at address 0
+func waitForFirstCycle(core *Core) {
+	for core.Sync.Level() != 0 {
+		core.Calculate()
+		core.ClockIn()
+		core.ClockOut()
+	}
+	// Run 1 extra clock to align to the start
+	core.Calculate()
+	core.ClockIn()
+	core.ClockOut()
+}
+
+func TestStackOverflow(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestStackOverflow")
+	core := Core{}
+	core.Init()
+	var events []addressstack.StackEvent
+	core.SetStrictStack(func(event addressstack.StackEvent) {
+		events = append(events, event)
+	})
+	waitForFirstCycle(&core)
+
+	// Run the 4 nested JMS instructions
+	program := instruction.StackOverflow()
+	for i := uint64(0); i < 8; i++ {
+		addr := runOneCycle(&core, uint64(program[i]), t)
+		if addr != i {
+			t.Errorf("Address mismatch. Exp %X, got %X", i, addr)
+		}
+	}
+	if len(events) != 1 || events[0].Type != addressstack.EventOverflow {
+		t.Errorf("Expected one overflow event, got %v", events)
+	}
+
+	// The first 3 return addresses are still on the stack
+	events = nil
+	expAddrs := []uint64{8, 8, 6}
+	for i, expAddr := range expAddrs {
+		addr := runOneCycle(&core, uint64(instruction.BBL), t)
+		if addr != expAddr {
+			t.Errorf("BBL %d: address mismatch. Exp %X, got %X", i, expAddr, addr)
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events, got %v", events)
+	}
+	// The 4th JMS overwrote the return address of the first one
+	runOneCycle(&core, uint64(instruction.BBL), t)
+	if len(events) != 1 || events[0].Type != addressstack.EventUnderflow {
+		t.Errorf("Expected one underflow event, got %v", events)
+	}
+	addr := runOneCycle(&core, uint64(instruction.NOP), t)
+	if addr != 9 {
+		t.Errorf("Address mismatch after the last BBL. Exp 9, got %X", addr)
+	}
+}
+
+func TestStackWrapAround(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestStackWrapAround")
+	core := Core{}
+	core.Init()
+	waitForFirstCycle(&core)
+
+	// Without strict mode, 4 pushes and 4 pops bring us back to the same register
+	program := instruction.StackOverflow()
+	for i := uint64(0); i < 8; i++ {
+		runOneCycle(&core, uint64(program[i]), t)
+	}
+	for i := 0; i < 4; i++ {
+		runOneCycle(&core, uint64(instruction.BBL), t)
+	}
+	if core.as.GetStackPointer() != 0 {
+		t.Errorf("Stack pointer mismatch. Exp 0, got %d", core.as.GetStackPointer())
+	}
+}
+
 // NOTE: THIS TEST IS DESTRUCTIVE!
 func verifyAccumulator(core *Core, exp uint64, t *testing.T) {
 	// Swap the accumulator with register 14
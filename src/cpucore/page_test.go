@@ -0,0 +1,122 @@
+package cpucore
+
+import (
+	"instruction"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+// jumpTo moves the program counter to addr with a JUN
+func jumpTo(core *Core, addr uint64, t *testing.T) {
+	runOneCycle(core, uint64(instruction.JUN|(addr>>8)), t)
+	runOneCycle(core, addr&0xff, t)
+}
+
+func createPageTestCore(t *testing.T) *Core {
+	core := &Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	return core
+}
+
+func verifyAddress(core *Core, exp uint64, t *testing.T) {
+	addr := runOneCycle(core, uint64(instruction.NOP), t)
+	if addr != exp {
+		t.Errorf("Address mismatch. Exp %03X, got %03X", exp, addr)
+	}
+}
+
+func TestJCNPageBoundary(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestJCNPageBoundary")
+	// The target is in the page of the instruction after the JCN
+	tests := []struct {
+		instAddr uint64
+		expAddr  uint64
+	}{
+		{0x0fd, 0x010},
+		{0x0fe, 0x110},
+		{0x0ff, 0x110},
+		{0x1fe, 0x210},
+		{0xffe, 0x010},
+	}
+	for _, test := range tests {
+		core := createPageTestCore(t)
+		jumpTo(core, test.instAddr, t)
+		// With no conditions, the inverted JCN always jumps
+		runOneCycle(core, uint64(instruction.JCN|0x8), t)
+		runOneCycle(core, 0x10, t)
+		verifyAddress(core, test.expAddr, t)
+	}
+
+	// When the jump is not taken, we just go to the next instruction
+	core := createPageTestCore(t)
+	jumpTo(core, 0x0fe, t)
+	runOneCycle(core, uint64(instruction.JCN|instruction.JCN_CARRY_SET), t)
+	runOneCycle(core, 0x10, t)
+	verifyAddress(core, 0x100, t)
+}
+
+func TestISZPageBoundary(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestISZPageBoundary")
+	core := createPageTestCore(t)
+	jumpTo(core, 0x0fe, t)
+	// Register 3 is 0, so it is not zero after the increment and we jump
+	runOneCycle(core, uint64(instruction.ISZ|3), t)
+	runOneCycle(core, 0x20, t)
+	verifyAddress(core, 0x120, t)
+}
+
+func TestJINPageBoundary(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestJINPageBoundary")
+	regPair := 3
+	tests := []struct {
+		instAddr uint64
+		expAddr  uint64
+	}{
+		{0x0fe, 0x0a5},
+		{0x0ff, 0x1a5},
+		{0x3ff, 0x4a5},
+	}
+	for _, test := range tests {
+		core := createPageTestCore(t)
+		loadRegisterPair(core, 0xa5, regPair, t)
+		jumpTo(core, test.instAddr, t)
+		runOneCycle(core, uint64(instruction.JIN|(regPair<<1)), t)
+		verifyAddress(core, test.expAddr, t)
+	}
+}
+
+func TestFINPageBoundary(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestFINPageBoundary")
+	regPair := 5
+	tests := []struct {
+		instAddr  uint64
+		fetchAddr uint64
+	}{
+		{0x0fe, 0x0c3},
+		{0x0ff, 0x1c3},
+		{0x7ff, 0x8c3},
+	}
+	for _, test := range tests {
+		core := createPageTestCore(t)
+		loadRegisterPair(core, 0xc3, 0, t)
+		jumpTo(core, test.instAddr, t)
+		runOneCycle(core, uint64(instruction.FIN|(regPair<<1)), t)
+		addr := runOneCycle(core, 0x96, t)
+		if addr != test.fetchAddr {
+			t.Errorf("Fetch address mismatch. Exp %03X, got %03X", test.fetchAddr, addr)
+		}
+		// Then we continue after the FIN
+		verifyAddress(core, test.instAddr+1, t)
+		verifyRegister(core, uint64(regPair<<1), 0x9, t)
+		verifyRegister(core, uint64(regPair<<1)+1, 0x6, t)
+	}
+}
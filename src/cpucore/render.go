@@ -15,7 +15,6 @@ import (
 
 // Renderer contains all the rendering code of our cpu
 type Renderer struct {
-	core   *Core
 	dirty  int
 	bounds image.Rectangle
 
@@ -27,14 +26,14 @@ type Renderer struct {
 	externalBufferRenderer  ExternalBusBufferRenderer
 	asRenderer              addressstack.Renderer
 	instRenderer            instruction.Renderer
-	instDirty               int // non-zero = render
+	instDirty               int    // non-zero = render
+	decoded                 string // The instruction rendered last
 	instX                   int
 	instY                   int
 }
 
-// InitRender Initializes the renderer
-func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.core = core
+// InitRender Initializes the renderer. The state gives the size of the register files
+func (r *Renderer) InitRender(state State, canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 	r.instDirty = 2
@@ -42,13 +41,13 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	// Initialize all the child renderers
 	mainBusSizePx := 32
 	extBusY := r.bounds.Min.Y + mainBusSizePx/2
-	r.externalDataBusRenderer.InitRender(&r.core.ExternalDataBus,
+	r.externalDataBusRenderer.InitRender(
 		image.Point{r.bounds.Min.X, extBusY},
 		image.Point{r.bounds.Max.X, extBusY},
 		mainBusSizePx)
 
 	intBusY := extBusY + 160
-	r.internalDataBusRenderer.InitRender(&r.core.internalDataBus,
+	r.internalDataBusRenderer.InitRender(
 		image.Point{r.bounds.Min.X, intBusY},
 		image.Point{r.bounds.Max.X, intBusY},
 		mainBusSizePx)
@@ -57,7 +56,7 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	extBufferHeight := intBusY - extBusY - mainBusSizePx
 	extBufferLeft := canvas.Width()/2 - extBufferWidth/2
 	extBufferTop := extBusY + mainBusSizePx/2
-	r.externalBufferRenderer.InitRender(&r.core.busBuffer, canvas, image.Rectangle{
+	r.externalBufferRenderer.InitRender(canvas, image.Rectangle{
 		image.Point{extBufferLeft, extBufferTop},
 		image.Point{extBufferLeft + extBufferWidth, extBufferTop + extBufferHeight}})
 
@@ -65,7 +64,7 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	aluWidth := 470
 	aluHeight := 400
 	aluRight := r.bounds.Min.X + aluWidth + aluLeftMargin
-	r.aluRenderer.InitRender(&r.core.alu, canvas, image.Rectangle{
+	r.aluRenderer.InitRender(canvas, image.Rectangle{
 		image.Point{r.bounds.Min.X + aluLeftMargin, intBusY + mainBusSizePx/2},
 		image.Point{aluRight, intBusY + mainBusSizePx/2 + aluHeight}})
 
@@ -74,7 +73,7 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	instWidth := int(css.RegisterWidth)
 	instHeight := 250
 	instRight := instLeft + instWidth
-	r.instRenderer.InitRender(&r.core.inst, canvas, image.Rectangle{
+	r.instRenderer.InitRender(canvas, image.Rectangle{
 		image.Point{instLeft, intBusY + mainBusSizePx/2},
 		image.Point{instRight, intBusY + mainBusSizePx/2 + instHeight}})
 
@@ -85,7 +84,7 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	asLeft := instRight + asLeftMargin
 	asWidth := int(2 * css.RegisterWidth)
 	asHeight := 320
-	r.asRenderer.InitRender(&r.core.as, canvas, image.Rectangle{
+	r.asRenderer.InitRender(len(state.Stack.Regs), canvas, image.Rectangle{
 		image.Point{asLeft, intBusY + mainBusSizePx/2},
 		image.Point{asLeft + asLeftMargin + asWidth, intBusY + mainBusSizePx/2 + asHeight}})
 
@@ -93,44 +92,44 @@ func (r *Renderer) InitRender(core *Core, canvas *canvas.Canvas, bounds image.Re
 	spWidth := 400
 	spHeight := 500
 
-	r.scratchPadRenderer.InitRender(&r.core.regs, canvas, image.Rectangle{
+	r.scratchPadRenderer.InitRender(len(state.Regs.Regs), canvas, image.Rectangle{
 		image.Point{r.bounds.Max.X - spRightMargin - spWidth, intBusY + mainBusSizePx/2},
 		image.Point{r.bounds.Max.X - spRightMargin, intBusY + mainBusSizePx/2 + spHeight}})
 }
 
-// Render renders the current state of this element
-func (r *Renderer) Render(canvas *canvas.Canvas) {
+// Render renders a state of the core
+func (r *Renderer) Render(canvas *canvas.Canvas, state State) {
 	canvas.SetFillStyle(css.Background)
 	if r.dirty > 0 {
 		canvas.FillRect(0, 0, float64(canvas.Width()), float64(canvas.Height()))
 		r.dirty--
 	}
-	r.externalDataBusRenderer.Render(canvas)
-	r.internalDataBusRenderer.Render(canvas)
-	r.externalBufferRenderer.Render(canvas)
-	r.aluRenderer.Render(canvas)
-	r.instRenderer.Render(canvas)
-	r.asRenderer.Render(canvas)
-	r.scratchPadRenderer.Render(canvas)
-	if r.core.Decoder.InstChanged {
+	r.externalDataBusRenderer.Render(canvas, state.ExternalBus, false)
+	r.internalDataBusRenderer.Render(canvas, state.InternalBus, false)
+	r.externalBufferRenderer.Render(canvas, state)
+	r.aluRenderer.Render(canvas, state.Alu)
+	r.instRenderer.Render(canvas, state.Inst, state.InternalBus)
+	r.asRenderer.Render(canvas, state.Stack, state.InternalBus)
+	r.scratchPadRenderer.Render(canvas, state.Regs, state.InternalBus)
+	if state.Decoded != r.decoded {
 		r.instDirty = 2
+		r.decoded = state.Decoded
 	}
 	if r.instDirty > 0 {
 		canvas.SetFillStyle(css.Background)
 		canvas.FillRect(float64(r.instX), float64(r.instY)-20, 100, 30)
 		canvas.SetFillStyle(css.TextNormal)
-		canvas.FillText(r.core.Decoder.DecodedInstruction, float64(r.instX), float64(r.instY))
+		canvas.FillText(state.Decoded, float64(r.instX), float64(r.instY))
 		r.instDirty--
 	}
 	// Always render the clock count
 	canvas.SetFillStyle(css.Background)
 	canvas.FillRect(float64(r.instX), float64(r.instY), 100, 30)
 	canvas.SetFillStyle(css.TextNormal)
-	canvas.FillText(fmt.Sprintf("CLK=%d", r.core.GetClockCount()), float64(r.instX), float64(r.instY+20))
+	canvas.FillText(fmt.Sprintf("CLK=%d", state.ClockCount), float64(r.instX), float64(r.instY+20))
 }
 
 type ExternalBusBufferRenderer struct {
-	buf    *ExternalBusBuffer
 	dirty  int
 	bounds image.Rectangle
 
@@ -141,8 +140,7 @@ type ExternalBusBufferRenderer struct {
 }
 
 // InitRender Initializes the renderer
-func (r *ExternalBusBufferRenderer) InitRender(buf *ExternalBusBuffer, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.buf = buf
+func (r *ExternalBusBufferRenderer) InitRender(canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 
@@ -153,24 +151,24 @@ func (r *ExternalBusBufferRenderer) InitRender(buf *ExternalBusBuffer, canvas *c
 	// bufHeight := 80
 	bufTop := r.bounds.Min.Y + busHeightPx
 	bufHeight := r.bounds.Dy() - 2*busHeightPx
-	r.externalDataBusRenderer.InitRender(r.buf.busExt,
+	r.externalDataBusRenderer.InitRender(
 		image.Point{r.bounds.Min.X + r.bounds.Dx()/2 - busWidthPx/2, r.bounds.Min.Y},
 		image.Point{r.bounds.Min.X + r.bounds.Dx()/2 - busWidthPx/2, bufTop},
 		busWidthPx)
 
-	r.internalDataBusRenderer.InitRender(r.buf.busInt,
+	r.internalDataBusRenderer.InitRender(
 		image.Point{r.bounds.Min.X + r.bounds.Dx()/2 - busWidthPx/2, r.bounds.Max.Y - busHeightPx},
 		image.Point{r.bounds.Min.X + r.bounds.Dx()/2 - busWidthPx/2, r.bounds.Max.Y},
 		busWidthPx)
 
-	r.bufferRenderer.InitRender(&r.buf.buf, image.Rectangle{
+	r.bufferRenderer.InitRender(image.Rectangle{
 		image.Point{r.bounds.Min.X, bufTop},
 		image.Point{r.bounds.Max.X, bufTop + bufHeight}})
 }
 
-// Render renders the current state of this element
-func (r *ExternalBusBufferRenderer) Render(canvas *canvas.Canvas) {
-	r.externalDataBusRenderer.Render(canvas)
-	r.internalDataBusRenderer.Render(canvas)
-	r.bufferRenderer.Render(canvas)
+// Render renders the buffer and the buses of a state of the core
+func (r *ExternalBusBufferRenderer) Render(canvas *canvas.Canvas, state State) {
+	r.externalDataBusRenderer.Render(canvas, state.ExternalBus, false)
+	r.internalDataBusRenderer.Render(canvas, state.InternalBus, false)
+	r.bufferRenderer.Render(canvas, state.Buffer)
 }
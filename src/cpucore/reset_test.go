@@ -0,0 +1,92 @@
+package cpucore
+
+import (
+	"instruction"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+// holdReset holds the RESET pin for the given number of clocks
+func holdReset(core *Core, clocks int) {
+	core.ResetIn.Drive("Test", 1)
+	for i := 0; i < clocks; i++ {
+		core.Calculate()
+		core.ClockIn()
+		core.ClockOut()
+	}
+	core.ResetIn.Drive("Test", 0)
+}
+
+// loadAllRegisters writes a non-zero value to every scratchpad register
+func loadAllRegisters(core *Core, t *testing.T) {
+	for reg := uint64(0); reg < NumRegisters; reg++ {
+		runOneCycle(core, uint64(instruction.LDM|(reg%15+1)), t)
+		runOneCycle(core, uint64(instruction.XCH|reg), t)
+	}
+}
+
+func TestReset(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestReset")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	loadAllRegisters(&core, t)
+	// Select RAM bank 5, set the carry and leave a value in the accumulator
+	runOneCycle(&core, uint64(instruction.LDM|5), t)
+	runOneCycle(&core, uint64(instruction.DCL), t)
+	runOneCycle(&core, uint64(instruction.STC), t)
+	runOneCycle(&core, uint64(instruction.LDM|9), t)
+	// Jump to a subroutine so the PC and the stack are not 0
+	runOneCycle(&core, uint64(instruction.JMS|0x1), t)
+	runOneCycle(&core, 0x23, t)
+
+	holdReset(&core, ResetClocks)
+	// RESET was released in the middle of an instruction cycle, so the
+	// CPU waits for the next one
+	runOneCycle(&core, instruction.NOP, t)
+
+	for i := uint64(0); i < 2; i++ {
+		addr := runOneCycle(&core, instruction.NOP, t)
+		if addr != i {
+			t.Errorf("Address mismatch after RESET. Exp %X, got %X", i, addr)
+		}
+	}
+	for reg := uint64(0); reg < NumRegisters; reg++ {
+		verifyRegister(&core, reg, 0, t)
+	}
+	// DCL should be back to bank 0
+	verifyCmLines(&core, instruction.SRC, []int{2, 6}, []int{6}, 0, t)
+	verifyAccumulator(&core, 0, t)
+	// TCC moves the carry to the accumulator
+	runOneCycle(&core, instruction.TCC, t)
+	verifyAccumulator(&core, 0, t)
+}
+
+func TestResetTooShort(t *testing.T) {
+	SetupLogger()
+	rlog.Info("TestResetTooShort")
+	core := Core{}
+	core.Init()
+	syncSeen, _ := waitForSync(&core)
+	if !syncSeen {
+		t.Fatal("Sync was not seen")
+	}
+	loadAllRegisters(&core, t)
+
+	// One instruction cycle only clears the first register pair
+	holdReset(&core, 8)
+	runOneCycle(&core, instruction.NOP, t)
+
+	for reg := uint64(0); reg < NumRegisters; reg++ {
+		exp := reg%15 + 1
+		if reg < 2 {
+			exp = 0
+		}
+		verifyRegister(&core, reg, exp, t)
+	}
+}
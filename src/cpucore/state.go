@@ -0,0 +1,57 @@
+package cpucore
+
+import (
+	"addressstack"
+	"alu"
+	"common"
+	"instruction"
+	"scratchpad"
+)
+
+// State is a snapshot of the core, taken between two clocks. It shares nothing
+// with the core, so it does not change when the simulation runs on
+type State struct {
+	ClockCount  int    // Clock in the instruction cycle, 0-7
+	PC          uint64 // Program counter
+	Decoded     string // The decoded instruction, like "JUN 4"
+	ExternalBus common.BusState
+	InternalBus common.BusState
+	Buffer      common.BufferState // The buffer between the internal and external buses
+	Alu         alu.State
+	Regs        scratchpad.State
+	Stack       addressstack.State
+	Inst        instruction.State
+}
+
+// State returns a snapshot of the core
+func (c *Core) State() State {
+	s := State{
+		ClockCount:  c.Decoder.GetClockCount(),
+		PC:          c.as.GetProgramCounter(),
+		Decoded:     c.Decoder.DecodedInstruction,
+		ExternalBus: c.ExternalDataBus.State(),
+		InternalBus: c.internalDataBus.State(),
+		Buffer:      c.busBuffer.buf.State(),
+		Alu:         c.alu.State(),
+		Regs:        c.regs.State(),
+		Stack:       c.as.State(),
+		Inst:        c.inst.State(),
+	}
+	// The data from an external device is only loaded on the next ClockIn.
+	// Show it on the internal bus right away, like the real buffer would
+	if c.getDecoderFlag(instruction.BusDir) == common.DirIn && s.InternalBus.Writes == 0 {
+		s.InternalBus.Value = s.ExternalBus.Value
+		s.InternalBus.Writes = s.ExternalBus.Writes
+		s.InternalBus.Driver = s.ExternalBus.Driver
+	}
+	return s
+}
+
+// clearDrivingBus is called at the start of each clock, so the state shows
+// which registers drove the internal bus during the clock
+func (c *Core) clearDrivingBus() {
+	c.alu.ClearDrivingBus()
+	c.regs.ClearDrivingBus()
+	c.as.ClearDrivingBus()
+	c.inst.ClearDrivingBus()
+}
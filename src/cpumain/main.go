@@ -1,17 +1,34 @@
 package main
 
 import (
-	"common"
-	"cpucore"
+	"board"
+	"flag"
+	"fmt"
+	"functional"
 	"instruction"
 	"os"
-	"rom4001"
+	"sysconfig"
 	"time"
 
 	"github.com/romana/rlog"
 )
 
 func main() {
+	configFile := flag.String("config", "", "JSON system file describing the chips. Runs a sample program if empty")
+	loadFile := flag.String("load", "", "Checkpoint file to resume from. It must be saved from the same system")
+	saveFile := flag.String("save", "", "Checkpoint file to write when done")
+	recordFile := flag.String("record", "", "Recording file to write when done")
+	replayFile := flag.String("replay", "", "Recording file to replay instead of running the program")
+	backend := flag.String("backend", "cycle", "cycle: clock every chip, or functional: run whole instructions, much faster, 4004 without peripherals only")
+	flag.Parse()
+	if *backend != "cycle" && *backend != "functional" {
+		fmt.Printf("Unknown backend %q\n", *backend)
+		return
+	}
+	if *backend == "functional" && (*recordFile != "" || *replayFile != "") {
+		fmt.Println("Recording and replay need the cycle backend")
+		return
+	}
 
 	enableLog := true
 	// Programmatically change an rlog setting from within the program
@@ -24,46 +41,125 @@ func main() {
 
 	rlog.Info("Welcome to the go 4004 emulator :)")
 
-	core := cpucore.Core{}
-	core.Init()
+	b, err := CreateBoard(*configFile)
+	if err != nil {
+		rlog.Error(err)
+		fmt.Println(err)
+		return
+	}
+	if *loadFile != "" {
+		if err := b.LoadCheckpointFile(*loadFile); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+			return
+		}
+	}
 
-	ioBus := common.Bus{}
-	ioBus.Init(4, "ROM I/O bus")
-	rom := rom4001.Rom4001{}
-	rom.Init(&core.ExternalDataBus, &core.Sync, &core.CmROM)
-	rom.SetIOBus(&ioBus)
-	WriteROM(&rom)
+	var recorder *board.Recorder
+	if *recordFile != "" {
+		if recorder, err = b.Record(); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+			return
+		}
+	}
 
-	lastTime := time.Now()
-	var loops = 1000000
-	for i := 0; i < loops; i++ {
-		if enableLog {
-			DumpState(core, rom, &ioBus)
+	if *replayFile != "" {
+		if err := replay(b, *replayFile); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+			return
+		}
+		rlog.Errorf("Replayed %s to clock %d", *replayFile, b.Clock.GetClocks())
+	} else if *backend == "functional" {
+		if err := runFunctional(b, 100000000); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+			return
+		}
+	} else {
+		lastTime := time.Now()
+		var loops = 1000000
+		for i := 0; i < loops; i++ {
+			if enableLog {
+				DumpState(b)
+			}
+			b.Step(1)
+		}
+		duration := time.Now().Sub(lastTime).Seconds()
+		hz := float64(loops) / duration
+		rlog.Errorf("Elapsed time = %f seconds, or %3.1f kHz", duration, hz/1000)
+	}
+	if recorder != nil {
+		rec, err := recorder.Recording()
+		if err == nil {
+			err = rec.SaveFile(*recordFile)
+		}
+		if err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+		}
+	}
+	if *saveFile != "" {
+		if err := b.SaveCheckpointFile(*saveFile); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
 		}
-		core.Calculate()
-		core.ClockIn()
-		rom.ClockIn()
-		core.ClockOut()
-		rom.ClockOut()
 	}
-	duration := time.Now().Sub(lastTime).Seconds()
-	hz := float64(loops) / duration
-	rlog.Errorf("Elapsed time = %f seconds, or %3.1f kHz", duration, hz/1000)
 	rlog.Info("Goodbye")
 }
 
-func DumpState(core cpucore.Core, rom rom4001.Rom4001, romIoBus *common.Bus) {
+// runFunctional runs a number of clocks with the functional backend, and
+// leaves the board where it stopped
+func runFunctional(b *board.Board, clocks uint64) error {
+	m := &functional.Machine{}
+	if err := m.Init(b); err != nil {
+		return err
+	}
+	lastTime := time.Now()
+	if err := m.Run(clocks); err != nil {
+		return err
+	}
+	duration := time.Now().Sub(lastTime).Seconds()
+	hz := float64(clocks) / duration
+	rlog.Errorf("Elapsed time = %f seconds, or %3.1f MHz equivalent", duration, hz/1000000)
+	return m.Store()
+}
+
+// replay runs a recorded session, like one from the visualizer
+func replay(b *board.Board, path string) error {
+	rec, err := board.LoadRecordingFile(path)
+	if err != nil {
+		return err
+	}
+	return b.Replay(rec)
+}
+
+func DumpState(b *board.Board) {
 	rlog.Infof("PC=%X, DBUS=%X, INST=%X, ROMIO=%X, SYNC=%d, CCLK=%d, ROMCLK=%d",
-		core.GetProgramCounter(),
-		core.ExternalDataBus.Read(),
-		core.GetInstructionRegister(),
-		romIoBus.Read(),
-		core.Sync, core.GetClockCount(),
-		rom.GetClockCount())
+		b.Core.GetProgramCounter(),
+		b.Core.ExternalDataBus.Value(),
+		b.Core.GetInstructionRegister(),
+		b.Roms.IOBuses[0].Value(),
+		b.Core.Sync.Level(), b.Core.GetClockCount(),
+		b.Roms.GetClockCount())
 }
 
-func WriteROM(r *rom4001.Rom4001) {
+// CreateBoard loads the system file, or creates the default system with a sample program
+func CreateBoard(configFile string) (*board.Board, error) {
+	if configFile != "" {
+		return sysconfig.Load(configFile)
+	}
+	b := &board.Board{}
+	b.Init(board.DefaultConfig())
+	WriteROM(b)
+	return b, nil
+}
+
+func WriteROM(b *board.Board) {
 	// Load a sample program into memory
 	data := instruction.LEDCountUsingAdd()
-	r.LoadProgram(data)
+	if err := b.LoadProgram(data); err != nil {
+		rlog.Error(err)
+	}
 }
@@ -0,0 +1,136 @@
+package events
+
+// Event kinds
+const (
+	KindFetch     = iota // An instruction was fetched
+	KindRetire           // An instruction finished, including its second cycle
+	KindRegister         // The accumulator, the carry or an index register was written
+	KindStackPush        // A return address was pushed
+	KindStackPop         // A return address was popped
+	KindBusWrite         // A bus was written
+	KindIOPort           // An I/O port changed
+	KindSrc              // A RAM or ROM latched the address sent by SRC
+	KindJump             // A conditional jump was taken or not
+	NumKinds
+)
+
+// Event is something which happened in the simulation. The type of the event
+// tells what happened, and Kind returns the matching Kind constant
+type Event interface {
+	Kind() int
+}
+
+// Fetch is an instruction fetched from memory. Jammed 4040 cycles are not fetches
+type Fetch struct {
+	PC     uint64 // Address of the instruction
+	Opcode uint64 // First byte of the instruction
+}
+
+// Retire is an instruction which finished
+type Retire struct {
+	PC     uint64 // Address of the instruction
+	Opcode uint64 // Both bytes of a two cycle instruction, with the first byte in the upper 8 bits
+	Cycles int    // Instruction cycles, 1 or 2
+}
+
+// RegisterWrite is a write of a CPU register
+type RegisterWrite struct {
+	Register string // "ACC", "CY", or the index register, like "R3". Bank 1 of the 4040 is "R3'"
+	Value    uint64
+}
+
+// StackOp is a push or a pop of the address stack
+type StackOp struct {
+	Push bool
+	// The program counter saved by the push, or restored by the pop. Like in the
+	// real CPU, it is incremented at the end of the instruction cycle
+	Address uint64
+}
+
+// BusWrite is a write of a bus
+type BusWrite struct {
+	Bus    string
+	Driver string
+	Value  uint64
+}
+
+// IOPort is an I/O port which changed
+type IOPort struct {
+	Chip  string // The chip which drives the port, like "ROM #0"
+	Port  string // The bus name of the port
+	Value uint64
+}
+
+// SrcLatch is the address of an SRC instruction, latched by the selected chip
+type SrcLatch struct {
+	Chip    string
+	Address uint64
+}
+
+// Jump is the decision of a conditional jump (JCN or ISZ)
+type Jump struct {
+	PC     uint64 // Address of the jump instruction
+	Opcode uint64 // First byte of the instruction
+	Taken  bool
+}
+
+func (Fetch) Kind() int         { return KindFetch }
+func (Retire) Kind() int        { return KindRetire }
+func (RegisterWrite) Kind() int { return KindRegister }
+func (BusWrite) Kind() int      { return KindBusWrite }
+func (IOPort) Kind() int        { return KindIOPort }
+func (SrcLatch) Kind() int      { return KindSrc }
+func (Jump) Kind() int          { return KindJump }
+
+func (s StackOp) Kind() int {
+	if s.Push {
+		return KindStackPush
+	}
+	return KindStackPop
+}
+
+// Observer is called with the clock period of the event and the event
+type Observer func(clock uint64, e Event)
+
+// Dispatcher sends the events to the observers subscribed to their kind. The
+// chips raise events through a Dispatcher pointer, which may be nil
+type Dispatcher struct {
+	Clock     func() uint64 // Time stamps the events. May be nil
+	observers [NumKinds][]Observer
+}
+
+// Subscribe calls the observer for each event of the kind
+func (d *Dispatcher) Subscribe(kind int, o Observer) {
+	d.observers[kind] = append(d.observers[kind], o)
+}
+
+// SubscribeAll calls the observer for every event
+func (d *Dispatcher) SubscribeAll(o Observer) {
+	for kind := range d.observers {
+		d.Subscribe(kind, o)
+	}
+}
+
+// Wants returns true if an event of the kind has observers. Check this before
+// building an event, to keep the simulation fast when nobody is listening
+func (d *Dispatcher) Wants(kind int) bool {
+	return d != nil && len(d.observers[kind]) > 0
+}
+
+// Raise sends the event to its observers
+func (d *Dispatcher) Raise(e Event) {
+	if d == nil {
+		return
+	}
+	observers := d.observers[e.Kind()]
+	if len(observers) == 0 {
+		return
+	}
+	var clock uint64
+	if d.Clock != nil {
+		clock = d.Clock()
+	}
+	for _, o := range observers {
+		o(clock, e)
+	}
+}
@@ -0,0 +1,32 @@
+package events
+
+import "testing"
+
+func TestDispatcher(t *testing.T) {
+	var d *Dispatcher
+	if d.Wants(KindFetch) {
+		t.Error("A nil dispatcher wants events")
+	}
+	d.Raise(Fetch{}) // Must not panic
+
+	d = &Dispatcher{Clock: func() uint64 { return 42 }}
+	if d.Wants(KindFetch) {
+		t.Error("A dispatcher without observers wants events")
+	}
+	var all, pushes []Event
+	d.SubscribeAll(func(clock uint64, e Event) {
+		if clock != 42 {
+			t.Errorf("Clock mismatch. Exp 42, got %d", clock)
+		}
+		all = append(all, e)
+	})
+	d.Subscribe(KindStackPush, func(clock uint64, e Event) {
+		pushes = append(pushes, e)
+	})
+	d.Raise(StackOp{Push: true, Address: 0x123})
+	d.Raise(StackOp{Push: false, Address: 0x123})
+	d.Raise(Jump{PC: 0x10, Taken: true})
+	if len(all) != 3 || len(pushes) != 1 || pushes[0] != (StackOp{Push: true, Address: 0x123}) {
+		t.Errorf("Observer mismatch. All=%v, pushes=%v", all, pushes)
+	}
+}
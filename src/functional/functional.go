@@ -0,0 +1,410 @@
+// Package functional runs whole instructions instead of clocking the chips.
+// Only the 4004 is supported, with ROMs and RAMs but no peripherals: a 4040
+// system, or one with 4003 or 4008 peripherals, needs the cycle backend
+package functional
+
+import (
+	"alu"
+	"board"
+	"common"
+	"cpucore"
+	"fmt"
+	"instruction"
+	"supportcommon"
+)
+
+// Clocks per instruction cycle. Two cycle instructions take twice as long
+const CycleClocks = 8
+
+// Machine runs whole 4004 instructions on the state of a board, without
+// clocking the chips. It is much faster than the board, for long programs.
+// It works on a checkpoint of the board: the scratchpad, the accumulator and
+// carry, the address stack, the ROMs and the RAMs. The ROM and RAM ports and
+// the TEST pin are the board's own. The chips raise no events, and peripherals
+// are not supported, since they need the bus cycles
+type Machine struct {
+	board *board.Board
+	state *board.Checkpoint
+	roms  [16]int // Index of the ROM with each chip ID, or -1
+	banks []int   // The DCL bank of each RAM
+	chips []int   // The chip number in its bank of each RAM
+}
+
+// Init takes over the board. The board is run to the first clock of the next
+// instruction first, since the core finishes some instructions in that clock
+func (m *Machine) Init(b *board.Board) error {
+	if b.Core.GetModel() != cpucore.Model4004 {
+		return fmt.Errorf("functional: only the 4004 is supported")
+	}
+	if len(b.Peripherals) > 0 {
+		return fmt.Errorf("functional: peripherals need the cycle accurate board")
+	}
+	if !b.AtInstructionBoundary() {
+		b.StepInstruction()
+	}
+	b.Step(1)
+	state, err := b.Checkpoint()
+	if err != nil {
+		return err
+	}
+	m.board = b
+	m.state = state
+	for i := range m.roms {
+		m.roms[i] = b.Roms.FindChip(i)
+	}
+	m.banks = m.banks[:0]
+	m.chips = m.chips[:0]
+	for _, pos := range b.RamChips() {
+		m.banks = append(m.banks, pos.Bank)
+		m.chips = append(m.chips, pos.Chip)
+	}
+	return nil
+}
+
+// GetClocks returns how many clock periods the board would have run
+func (m *Machine) GetClocks() uint64 {
+	return m.state.Clock.Clocks
+}
+
+// GetProgramCounter returns the address of the next instruction
+func (m *Machine) GetProgramCounter() uint64 {
+	s := &m.state.Core.Stack
+	return s.Regs[s.StackPointer]
+}
+
+// GetAccumulator returns the accumulator and the carry
+func (m *Machine) GetAccumulator() (acc uint64, carry uint64) {
+	return m.state.Core.Alu.Accumulator, m.state.Core.Alu.Carry
+}
+
+// Run runs instructions until at least the number of clocks has passed
+func (m *Machine) Run(clocks uint64) error {
+	end := m.GetClocks() + clocks
+	for m.GetClocks() < end {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store writes the state back to the board, which goes on from there. The
+// board keeps its input pins as they are now
+func (m *Machine) Store() error {
+	b := m.board
+	core := &m.state.Core
+	flags := core.Alu.Flags &^ (alu.FlagPosZero | alu.FlagPosCarry)
+	if core.Alu.Accumulator == 0 {
+		flags |= alu.FlagPosZero
+	}
+	if core.Alu.Carry != 0 {
+		flags |= alu.FlagPosCarry
+	}
+	core.Alu.Flags = flags
+	// The ROMs latched the low nibble of the program counter in the first clock
+	pc := m.GetProgramCounter()
+	core.ExternalBus.Data = pc & 0xf
+	for i := range m.state.Roms {
+		m.state.Roms[i].Address = pc & 0xf
+	}
+	for i := range b.Roms.IOBuses {
+		m.state.RomIOBuses[i] = b.Roms.IOBuses[i].Checkpoint()
+	}
+	for i := range b.RamIOBuses {
+		m.state.RamIOBuses[i] = b.RamIOBuses[i].Checkpoint()
+	}
+	pins := []*common.Signal{&b.Core.Test, &b.Core.ResetIn, &b.Core.Int, &b.Core.Stop}
+	levels := make([]common.SignalCheckpoint, len(pins))
+	for i, pin := range pins {
+		levels[i] = pin.Checkpoint()
+	}
+	if err := b.Restore(m.state); err != nil {
+		return err
+	}
+	for i, pin := range pins {
+		pin.Restore(levels[i])
+	}
+	// Keep working on our own copy
+	state, err := b.Checkpoint()
+	if err != nil {
+		return err
+	}
+	m.state = state
+	return nil
+}
+
+// fetch reads a byte of program memory
+func (m *Machine) fetch(addr uint64) (uint64, error) {
+	i := m.roms[addr>>8]
+	if i < 0 {
+		return 0, fmt.Errorf("functional: no ROM at address %03X", addr)
+	}
+	return uint64(m.state.Roms[i].Data[addr&0xff]), nil
+}
+
+// Step runs one instruction, and returns the clocks it took
+func (m *Machine) Step() (clocks int, err error) {
+	core := &m.state.Core
+	stack := &core.Stack
+	regs := core.Regs.Regs
+	a := &core.Alu
+
+	pc := m.GetProgramCounter()
+	op, err := m.fetch(pc)
+	if err != nil {
+		return 0, err
+	}
+	next := (pc + 1) & 0xfff
+	clocks = CycleClocks
+	var arg uint64
+	if instruction.IsTwoCycleInstruction(op) {
+		clocks = 2 * CycleClocks
+		if op&0xf0 != instruction.FIN&0xf0 {
+			if arg, err = m.fetch(next); err != nil {
+				return 0, err
+			}
+			next = (next + 1) & 0xfff
+		}
+	}
+	reg := op & 0xf
+	pair := op & 0xe
+
+	switch op & 0xf0 {
+	case instruction.JCN:
+		if m.evaluateJCN(reg) {
+			next = (next & 0xf00) | arg
+		}
+	case instruction.FIM_SRC:
+		if op&0x1 == 0 {
+			regs[pair] = arg >> 4
+			regs[pair+1] = arg & 0xf
+		} else {
+			m.src(regs[pair]<<4 | regs[pair+1])
+		}
+	case instruction.FIN & 0xf0:
+		addr := (next & 0xf00) | regs[0]<<4 | regs[1]
+		if op&0x1 == 0 {
+			data, err := m.fetch(addr)
+			if err != nil {
+				return 0, err
+			}
+			regs[pair] = data >> 4
+			regs[pair+1] = data & 0xf
+		} else {
+			next = (next & 0xf00) | regs[pair]<<4 | regs[pair+1]
+		}
+	case instruction.JUN:
+		next = reg<<8 | arg
+	case instruction.JMS:
+		// The return address points at the second byte, like the address stack
+		stack.Regs[stack.StackPointer] = (next - 1) & 0xfff
+		stack.StackPointer = (stack.StackPointer + 1) % len(stack.Regs)
+		if stack.Levels < len(stack.Regs)-1 {
+			stack.Levels++
+		}
+		next = reg<<8 | arg
+	case instruction.INC:
+		regs[reg] = (regs[reg] + 1) & 0xf
+	case instruction.ISZ:
+		regs[reg] = (regs[reg] + 1) & 0xf
+		if regs[reg] != 0 {
+			next = (next & 0xf00) | arg
+		}
+	case instruction.ADD:
+		a.Accumulator, a.Carry = add(a.Accumulator, regs[reg], a.Carry)
+	case instruction.SUB:
+		a.Accumulator, a.Carry = add(a.Accumulator, ^regs[reg], ^a.Carry)
+	case instruction.LD:
+		a.Accumulator = regs[reg]
+	case instruction.XCH:
+		a.Accumulator, regs[reg] = regs[reg], a.Accumulator
+	case instruction.BBL:
+		stack.StackPointer = (stack.StackPointer + len(stack.Regs) - 1) % len(stack.Regs)
+		if stack.Levels > 0 {
+			stack.Levels--
+		}
+		next = (stack.Regs[stack.StackPointer] + 1) & 0xfff
+		a.Accumulator = reg
+	case instruction.LDM:
+		a.Accumulator = reg
+	case instruction.IO:
+		m.executeIO(op)
+	case instruction.ACC:
+		m.executeAcc(op)
+	}
+	stack.Regs[stack.StackPointer] = next
+	m.state.Clock.Clocks += uint64(clocks)
+	return clocks, nil
+}
+
+// add returns the 4 bit sum and the carry out
+func add(a uint64, b uint64, carry uint64) (uint64, uint64) {
+	sum := (a & 0xf) + (b & 0xf) + (carry & 0x1)
+	return sum & 0xf, sum >> 4
+}
+
+// evaluateJCN returns true if the jump is taken. Any of the selected
+// conditions is enough, and bit 3 inverts the result
+func (m *Machine) evaluateJCN(cond uint64) bool {
+	a := &m.state.Core.Alu
+	test := m.board.Core.Test.Level()
+	result := (cond&0x2 != 0 && a.Carry == 1) || (cond&0x4 != 0 && a.Accumulator == 0) ||
+		(cond&0x1 != 0 && test == 0)
+	return result != (cond&0x8 != 0)
+}
+
+// src selects the ROM and the RAM for the I/O instructions
+func (m *Machine) src(addr uint64) {
+	for i := range m.state.Roms {
+		rom := &m.state.Roms[i]
+		rom.SrcSelected = uint64(m.board.Roms.Chips[i].GetChipID()) == addr>>4
+		if rom.SrcSelected {
+			rom.SrcAddress = addr
+		}
+	}
+	bank := int(m.state.Core.Alu.RamBank & 0x7)
+	for i := range m.state.Rams {
+		ram := &m.state.Rams[i]
+		ram.SrcSelected = m.banks[i] == bank && uint64(m.chips[i]) == addr>>6
+		if ram.SrcSelected {
+			ram.SrcAddress = addr
+		}
+	}
+}
+
+// selectedRom returns the index of the ROM selected by SRC, or -1
+func (m *Machine) selectedRom() int {
+	for i := range m.state.Roms {
+		if m.state.Roms[i].SrcSelected {
+			return i
+		}
+	}
+	return -1
+}
+
+// selectedRam returns the index of the RAM selected by SRC, or -1
+func (m *Machine) selectedRam() int {
+	for i := range m.state.Rams {
+		if m.state.Rams[i].SrcSelected {
+			return i
+		}
+	}
+	return -1
+}
+
+// executeIO runs the I/O and RAM instructions. Reads from a chip which is not
+// there return 0xF, like the precharged data bus
+func (m *Machine) executeIO(op uint64) {
+	a := &m.state.Core.Alu
+	b := m.board
+	if i := m.selectedRom(); i >= 0 {
+		port := &b.Roms.IOBuses[i]
+		outputs := b.Roms.Chips[i].IOOutputs()
+		inverted := b.Roms.Chips[i].IOInverted()
+		switch op {
+		case instruction.WRR:
+			value := ((a.Accumulator ^ inverted) & outputs) | (port.Read() &^ outputs)
+			port.Reset()
+			port.Write(&b.Roms.Chips[i].Core, value)
+		case instruction.RDR:
+			a.Accumulator = (port.Read() ^ inverted) & 0xf
+		}
+	} else if op == instruction.RDR {
+		a.Accumulator = 0xf
+	}
+
+	i := m.selectedRam()
+	if i < 0 {
+		switch op {
+		case instruction.SBM, instruction.RDM, instruction.ADM,
+			instruction.RD0, instruction.RD1, instruction.RD2, instruction.RD3:
+			m.readRam(op, 0xf)
+		}
+		return
+	}
+	ram := &m.state.Rams[i]
+	reg := int(ram.SrcAddress>>4) & (supportcommon.RamRegisters - 1)
+	char := reg*supportcommon.RamCharacters + int(ram.SrcAddress)&(supportcommon.RamCharacters-1)
+	switch op {
+	case instruction.WRM:
+		ram.Data[char] = uint8(a.Accumulator)
+	case instruction.WMP:
+		port := &b.RamIOBuses[i]
+		port.Reset()
+		port.Write(&b.Rams[i].Core, a.Accumulator)
+	case instruction.WR0, instruction.WR1, instruction.WR2, instruction.WR3:
+		ram.Status[reg*supportcommon.RamStatusCharacters+int(op-instruction.WR0)] = uint8(a.Accumulator)
+	case instruction.SBM, instruction.RDM, instruction.ADM:
+		m.readRam(op, uint64(ram.Data[char]))
+	case instruction.RD0, instruction.RD1, instruction.RD2, instruction.RD3:
+		m.readRam(op, uint64(ram.Status[reg*supportcommon.RamStatusCharacters+int(op-instruction.RD0)]))
+	}
+}
+
+// readRam completes the instructions which read a RAM character
+func (m *Machine) readRam(op uint64, value uint64) {
+	a := &m.state.Core.Alu
+	switch op {
+	case instruction.SBM:
+		a.Accumulator, a.Carry = add(a.Accumulator, ^value, ^a.Carry)
+	case instruction.ADM:
+		a.Accumulator, a.Carry = add(a.Accumulator, value, a.Carry)
+	default:
+		a.Accumulator = value
+	}
+}
+
+// executeAcc runs the accumulator group instructions
+func (m *Machine) executeAcc(op uint64) {
+	a := &m.state.Core.Alu
+	switch op {
+	case instruction.CLB:
+		a.Accumulator = 0
+		a.Carry = 0
+	case instruction.CLC:
+		a.Carry = 0
+	case instruction.IAC:
+		a.Accumulator, a.Carry = add(a.Accumulator, 1, 0)
+	case instruction.CMC:
+		a.Carry ^= 1
+	case instruction.CMA:
+		a.Accumulator = ^a.Accumulator & 0xf
+	case instruction.RAL:
+		acc := a.Accumulator<<1 | a.Carry
+		a.Accumulator, a.Carry = acc&0xf, acc>>4
+	case instruction.RAR:
+		acc := a.Accumulator | a.Carry<<4
+		a.Accumulator, a.Carry = acc>>1, acc&0x1
+	case instruction.TCC:
+		a.Accumulator = a.Carry
+		a.Carry = 0
+	case instruction.DAC:
+		a.Accumulator, a.Carry = add(a.Accumulator, 0xf, 0)
+	case instruction.TCS:
+		a.Accumulator = 9 + a.Carry
+		a.Carry = 0
+	case instruction.STC:
+		a.Carry = 1
+	case instruction.DAA:
+		// The carry is only ever set
+		if a.Accumulator > 9 || a.Carry != 0 {
+			acc := a.Accumulator + 6
+			if acc > 0xf {
+				a.Carry = 1
+			}
+			a.Accumulator = acc & 0xf
+		}
+	case instruction.KBP:
+		switch a.Accumulator {
+		case 0, 1, 2:
+		case 4:
+			a.Accumulator = 3
+		case 8:
+			a.Accumulator = 4
+		default:
+			a.Accumulator = 0xf
+		}
+	case instruction.DCL:
+		a.RamBank = a.Accumulator & 0x7
+	}
+}
@@ -0,0 +1,207 @@
+package functional
+
+import (
+	"board"
+	"cpucore"
+	"instruction"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "functional_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+// createBoard creates a board with two RAM banks. The ROM 0 I/O lines are
+// inputs, and some of the ROM 1 outputs are inverted
+func createBoard(program []uint8) *board.Board {
+	config := board.DefaultConfig()
+	config.NumRams = 2 * board.RamChipsPerBank
+	b := &board.Board{}
+	b.Init(config)
+	b.Roms.Chips[0].SetIOMask(0x3, 0x6)
+	b.Roms.Chips[1].SetIOMask(0xf, 0x5)
+	if err := b.LoadProgram(program); err != nil {
+		panic(err)
+	}
+	b.Reset()
+	return b
+}
+
+// randomProgram returns random instructions for ROM 0 and 1
+func randomProgram(r *rand.Rand) []uint8 {
+	program := make([]uint8, 512)
+	for i := range program {
+		program[i] = uint8(r.Intn(0x100))
+		if program[i]&0xf0 == instruction.JUN || program[i]&0xf0 == instruction.JMS {
+			// Stay in the first two pages
+			program[i] &= 0xf1
+		}
+	}
+	return program
+}
+
+// architecture is the state both backends must agree on
+type architecture struct {
+	Clocks     uint64
+	Regs       []uint64
+	Stack      []uint64
+	SP         int
+	Levels     int
+	Acc, Carry uint64
+	RamBank    uint64
+	RamData    [][]uint8
+	RamStatus  [][]uint8
+	Ports      []uint64
+}
+
+func architectureOf(b *board.Board, c *board.Checkpoint) architecture {
+	a := architecture{
+		Clocks:  c.Clock.Clocks,
+		Regs:    c.Core.Regs.Regs,
+		Stack:   c.Core.Stack.Regs,
+		SP:      c.Core.Stack.StackPointer,
+		Levels:  c.Core.Stack.Levels,
+		Acc:     c.Core.Alu.Accumulator,
+		Carry:   c.Core.Alu.Carry,
+		RamBank: c.Core.Alu.RamBank,
+	}
+	for _, ram := range c.Rams {
+		a.RamData = append(a.RamData, ram.Data)
+		a.RamStatus = append(a.RamStatus, ram.Status)
+	}
+	for i := range b.Roms.IOBuses {
+		a.Ports = append(a.Ports, b.Roms.IOBuses[i].Value())
+	}
+	for i := range b.RamIOBuses {
+		a.Ports = append(a.Ports, b.RamIOBuses[i].Value())
+	}
+	return a
+}
+
+func boardArchitecture(b *board.Board) architecture {
+	c, err := b.Checkpoint()
+	if err != nil {
+		panic(err)
+	}
+	return architectureOf(b, c)
+}
+
+func TestRandomPrograms(t *testing.T) {
+	SetupLogger()
+	r := rand.New(rand.NewSource(4004))
+	for p := 0; p < 20; p++ {
+		program := randomProgram(r)
+		ref := createBoard(program)
+		b := createBoard(program)
+		m := &Machine{}
+		if err := m.Init(b); err != nil {
+			t.Fatal(err)
+		}
+		ref.Step(int(m.GetClocks() - ref.Clock.GetClocks()))
+		for i := 0; i < 2000; i++ {
+			if i%50 == 0 {
+				ref.Core.Test.Drive("TEST switch", (i/50)%2)
+				b.Core.Test.Drive("TEST switch", (i/50)%2)
+				ref.SetRomInput(0, "Keys", uint64(i/50))
+				b.SetRomInput(0, "Keys", uint64(i/50))
+			}
+			pc := m.GetProgramCounter()
+			clocks, err := m.Step()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref.Step(clocks)
+			exp := boardArchitecture(ref)
+			got := architectureOf(b, m.state)
+			if !reflect.DeepEqual(exp, got) {
+				t.Fatalf("Program %d, instruction %d: %02X at %03X mismatch.\nExp %+v\ngot %+v",
+					p, i, program[pc], pc, exp, got)
+			}
+		}
+
+		// The board goes on from the functional state
+		if err := m.Store(); err != nil {
+			t.Fatal(err)
+		}
+		ref.Step(2000)
+		b.Step(2000)
+		if exp, got := boardArchitecture(ref), boardArchitecture(b); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("Program %d: mismatch after the store.\nExp %+v\ngot %+v", p, exp, got)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	SetupLogger()
+	b := createBoard(instruction.LEDCountUsingAdd())
+	ref := createBoard(instruction.LEDCountUsingAdd())
+	m := &Machine{}
+	if err := m.Init(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	ref.Step(int(m.GetClocks() - ref.Clock.GetClocks()))
+	if !reflect.DeepEqual(boardArchitecture(ref), architectureOf(b, m.state)) {
+		t.Error("Run mismatch")
+	}
+	if err := m.Store(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Clock.GetClocks() != ref.Clock.GetClocks() || b.Core.GetProgramCounter() != ref.Core.GetProgramCounter() {
+		t.Errorf("Store mismatch. Exp clock %d, PC %03X, got %d, %03X", ref.Clock.GetClocks(),
+			ref.Core.GetProgramCounter(), b.Clock.GetClocks(), b.Core.GetProgramCounter())
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	SetupLogger()
+	config := board.DefaultConfig()
+	config.Model = cpucore.Model4040
+	b := &board.Board{}
+	b.Init(config)
+	if err := (&Machine{}).Init(b); err == nil {
+		t.Error("A 4040 was accepted")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	SetupLogger()
+	b := createBoard(instruction.LEDCountUsingAdd())
+	ref := createBoard(instruction.LEDCountUsingAdd())
+	history := b.EnableHistory(1000, 1)
+	m := &Machine{}
+	if err := m.Init(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Store(); err != nil {
+		t.Fatal(err)
+	}
+	history.Sync()
+	if history.Oldest() != b.Clock.GetClocks() {
+		t.Errorf("Sync did not take a checkpoint. Oldest %d, clock %d", history.Oldest(), b.Clock.GetClocks())
+	}
+	// Step back over a checkpoint of the cycle accurate board
+	b.Step(1500)
+	if err := history.StepBack(700); err != nil {
+		t.Fatal(err)
+	}
+	ref.Step(int(b.Clock.GetClocks() - ref.Clock.GetClocks()))
+	if !reflect.DeepEqual(boardArchitecture(ref), boardArchitecture(b)) {
+		t.Error("Step back mismatch")
+	}
+}
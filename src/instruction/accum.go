@@ -5,8 +5,11 @@ import "fmt"
 func (d *Decoder) handleACC(fullInst int, evalResult bool) (err error) {
 	if d.clockCount == 5 {
 		d.setDecodedInstruction(fmt.Sprintf("ACC %X", d.currInstruction&0xf))
-		// Output the data from the selected scratchpad register
-		d.writeFlag(AccInst, int(d.currInstruction&0xf))
+		// Output the data from the selected scratchpad register. 0xFE and
+		// 0xFF are not used, and do nothing
+		if d.currInstruction&0xff <= DCL {
+			d.writeFlag(AccInst, int(d.currInstruction&0xf))
+		}
 		d.currInstruction = -1
 	}
 	return err
@@ -0,0 +1,140 @@
+package instruction
+
+import (
+	"alu"
+	"common"
+)
+
+// Jammed instruction cycles (4040 only). The CPU still sends the address, but
+// it ignores the instruction fetched from ROM
+const (
+	JamNone      = iota // A normal instruction cycle
+	JamInterrupt        // Jump to the interrupt routine
+	JamStop             // Do nothing while STOP is active or the CPU is halted
+)
+
+// JamCycle replaces the next instruction cycle. It must be called in X3,
+// at an instruction boundary
+func (d *Decoder) JamCycle(jam int) {
+	d.jamCycle = jam
+}
+
+// IsJamCycle returns true if the current instruction cycle ignores the fetched instruction
+func (d *Decoder) IsJamCycle() bool {
+	return d.jamCycle != JamNone
+}
+
+// AtInstructionBoundary returns true in X3 if the next instruction cycle fetches
+// a new instruction. An interrupt or STOP never splits a two cycle instruction
+func (d *Decoder) AtInstructionBoundary() bool {
+	if d.currInstruction > 0 {
+		// Instructions finishing early in the next cycle are fine,
+		// but not the first cycle of FIN
+		return d.dblInstruction != 0 || !IsTwoCycleInstruction(uint64(d.currInstruction))
+	}
+	return d.dblInstruction == 0
+}
+
+// jamNop drives a NOP in place of the data from ROM. The ROM and RAM chips decode
+// the instructions they see on the bus, so they must not see the ignored instruction
+func (d *Decoder) jamNop() {
+	d.writeFlag(BusDir, common.DirOut)
+	d.writeFlag(NopOut, 1)
+}
+
+func (d *Decoder) decodeJamCycle() {
+	if d.jamCycle == JamInterrupt {
+		d.setDecodedInstruction("INT")
+		// Push the current address and jump to the interrupt routine
+		d.writeFlag(Interrupt, 1)
+	} else {
+		d.setDecodedInstruction("STOP")
+	}
+	// The current address is fetched again after a STOP. After an interrupt,
+	// the core has already loaded the address of the interrupt routine
+	d.inhibitPCInc = true
+	d.jamCycle = JamNone
+	d.currInstruction = -1
+}
+
+func (d *Decoder) handle4040(fullInst int, evalResult bool) (err error) {
+	switch fullInst {
+	case OR4, OR5, AN6, AN7:
+		err = d.handleLogic(fullInst, evalResult)
+	case BBS:
+		err = d.handleBBS(fullInst, evalResult)
+	case RPM:
+		// Read the program memory selected by the last SRC into the accumulator
+		if d.clockCount == 5 {
+			d.setDecodedInstruction(inst4040ToString(fullInst))
+			// The ROM drives the external bus in X2
+			d.x2IsRead = true
+		} else if d.clockCount == 7 {
+			d.writeFlag(AccLoad, 1)
+			d.currInstruction = -1
+		}
+	case HLT, LCR, DB0, DB1, SB0, SB1, EIN, DIN:
+		// These only change the state of the core, which executes them in X3
+		if d.clockCount == 5 {
+			d.setDecodedInstruction(inst4040ToString(fullInst))
+		} else if d.clockCount == 7 {
+			d.writeFlag(ExtInst, fullInst)
+			d.currInstruction = -1
+		}
+	default:
+		d.currInstruction = -1
+	}
+	return err
+}
+
+func (d *Decoder) handleLogic(fullInst int, evalResult bool) (err error) {
+	if d.clockCount == 5 {
+		d.setDecodedInstruction(inst4040ToString(fullInst))
+		if fullInst == OR4 || fullInst == OR5 {
+			d.writeFlag(AluMode, alu.AluIntModeOr)
+		} else {
+			d.writeFlag(AluMode, alu.AluIntModeAnd)
+		}
+		// Output the data from the register in the instruction (R4..R7)
+		d.writeFlag(ScratchPadIndex, fullInst&0x7)
+		d.writeFlag(ScratchPadOut, 1)
+	} else if d.clockCount == 6 {
+		// Load the value into the temp register
+		d.writeFlag(TempLoad, 1)
+	} else if d.clockCount == 7 {
+		// Evaluate the ALU and write the value into the accumulator
+		d.writeFlag(AluEval, 1)
+		d.writeFlag(AccLoad, 1)
+		d.currInstruction = -1
+	}
+	return err
+}
+
+func (d *Decoder) handleBBS(fullInst int, evalResult bool) (err error) {
+	// Branch back from the interrupt routine, and send the SRC address saved by
+	// the interrupt to the ROM and RAM chips
+	if d.clockCount == 5 {
+		d.setDecodedInstruction(inst4040ToString(fullInst))
+	} else if d.clockCount == 6 {
+		// The core restores the command register and the SRC address first
+		d.writeFlag(ExtInst, fullInst)
+		// Pop the address stack. Unlike BBL, the return address is the
+		// instruction which was replaced by the interrupt, so don't increment it
+		d.writeFlag(StackPop, 1)
+		d.inhibitPCInc = true
+		d.writeFlag(SrcOut, 2)
+		d.writeFlag(CmROMOut, 1)
+		d.writeFlag(CmRAMOut, 1)
+	} else if d.clockCount == 7 {
+		d.writeFlag(SrcOut, 1)
+		d.currInstruction = -1
+	}
+	return err
+}
+
+var inst4040Strings = []string{"NOP", "HLT", "BBS", "LCR", "OR4", "OR5", "AN6", "AN7",
+	"DB0", "DB1", "SB0", "SB1", "EIN", "DIN", "RPM", "NOP"}
+
+func inst4040ToString(inst int) string {
+	return inst4040Strings[inst&0xf]
+}
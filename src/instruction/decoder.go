@@ -2,6 +2,7 @@ package instruction
 
 import (
 	"common"
+	"fmt"
 
 	"github.com/romana/rlog"
 )
@@ -22,8 +23,23 @@ const LDM = 0xD0 // Load direct into accumulator
 const LD = 0xA0  // Load register into accumulator
 const XCH = 0xB0 // Exchange the accumulator and scratchpad register
 const BBL = 0xC0 // Branch back (stack pop)
+const IO = 0xE0  // Alias for all the I/O and RAM instructions
+const WRM = 0xE0 // Write accumulator to RAM main memory character
+const WMP = 0xE1 // Write accumulator to RAM output port
 const WRR = 0xE2 // ROM I/O write
+const WPM = 0xE3 // Write program memory
+const WR0 = 0xE4 // Write accumulator to RAM status character 0
+const WR1 = 0xE5 // Write accumulator to RAM status character 1
+const WR2 = 0xE6 // Write accumulator to RAM status character 2
+const WR3 = 0xE7 // Write accumulator to RAM status character 3
+const SBM = 0xE8 // Subtract RAM main memory character from accumulator with borrow
+const RDM = 0xE9 // Read RAM main memory character into accumulator
 const RDR = 0xEA // ROM I/O read
+const ADM = 0xEB // Add RAM main memory character to accumulator with carry
+const RD0 = 0xEC // Read RAM status character 0 into accumulator
+const RD1 = 0xED // Read RAM status character 1 into accumulator
+const RD2 = 0xEE // Read RAM status character 2 into accumulator
+const RD3 = 0xEF // Read RAM status character 3 into accumulator
 const ACC = 0xF0 // Alias for all the accumulator instructions
 // Accumulator instructions
 const CLB = ACC | 0x0 // Clear accumulator and carry
@@ -41,27 +57,43 @@ const DAA = ACC | 0xB // Decimal adjust
 const KBP = ACC | 0xC // Keyboard process
 const DCL = ACC | 0xD // Designate command line
 
+// 4040 instructions. These are NOPs on the 4004
+const HLT = 0x01 // Halt
+const BBS = 0x02 // Branch back from interrupt and restore SRC
+const LCR = 0x03 // Load the command register into the accumulator
+const OR4 = 0x04 // Logical OR register 4 into the accumulator
+const OR5 = 0x05 // Logical OR register 5 into the accumulator
+const AN6 = 0x06 // Logical AND register 6 into the accumulator
+const AN7 = 0x07 // Logical AND register 7 into the accumulator
+const DB0 = 0x08 // Designate ROM bank 0 (CM-ROM0)
+const DB1 = 0x09 // Designate ROM bank 1 (CM-ROM1)
+const SB0 = 0x0A // Select register bank 0
+const SB1 = 0x0B // Select register bank 1
+const EIN = 0x0C // Enable interrupt
+const DIN = 0x0D // Disable interrupt
+const RPM = 0x0E // Read program memory
+
 // Some helpers
+const FIM_SRC = 0x20 // FIM and SRC share the same upper 4 bits
+
 // You can use any of these three in combination
-const JCN_TEST_SET = 0x11  // Jump if test bit is set
+const JCN_TEST_SET = 0x11  // Jump if test bit is set (the TEST pin is 0)
 const JCN_CARRY_SET = 0x12 // Jump if carry bit is set
 const JCN_ZERO_SET = 0x14  // Jump if accumulator is zero
 // You can use any of these three in combination
-const JCN_TEST_UNSET = 0x19  // Jump if test bit is NOT set
+const JCN_TEST_UNSET = 0x19  // Jump if test bit is NOT set (the TEST pin is 1)
 const JCN_CARRY_UNSET = 0x1A // Jump if carry bit is NOT set
 const JCN_ZERO_UNSET = 0x1C  // Jump if accumulator is NOT zero
 
 type DecoderFlag struct {
-	Name    string
-	Value   int
-	Changed bool
+	Name  string
+	Value int
 }
 
 type Decoder struct {
 	// Control Flags
 	Flags              map[int]DecoderFlag
 	DecodedInstruction string // For the renderer
-	InstChanged        bool   // For the renderer
 
 	clockCount      int // Internal clock count
 	instPhase       int // which instruction phase are we in
@@ -72,6 +104,8 @@ type Decoder struct {
 	inhibitPC       bool // Block the program counter from writing to the external bus
 	x2IsRead        bool // The CPU's X2 cycle is an external device read
 	x3IsRead        bool // The CPU's X3 cycle is an external device read
+	is4040          bool // Decode the 4040 instructions
+	jamCycle        int  // The next instruction cycle ignores the fetched instruction (4040 only)
 }
 
 const (
@@ -102,51 +136,181 @@ const (
 	DecodeInstruction        // The instruction register is ready to be decoded
 	EvalulateJCN             // Evaluate the condition flags for a JCN instruction
 	EvalulateISZ             // Evaluate the scratchpad regiser for an ISZ instruction
+	SampleTest               // Sample the TEST input pin
+	CmROMOut                 // Drive the CM-ROM line active
+	CmRAMOut                 // Drive the CM-RAM lines selected by DCL active
+	ExtInst                  // Execute a 4040 instruction in the core (value is the instruction)
+	Interrupt                // Jump to the interrupt routine (4040 only)
+	SrcLatch                 // Latch the SRC address from the internal bus (value is the nybble to load)
+	SrcOut                   // The latched SRC address should drive the bus (value is the nybble to drive)
+	NopOut                   // Drive a NOP on the bus in place of the fetched instruction
 	END                      // Marker for end of list
 )
 
+// DecoderCheckpoint is the saved state of the decoder
+type DecoderCheckpoint struct {
+	Flags              []int // Flag values, by flag index
+	DecodedInstruction string
+	ClockCount         int
+	InstPhase          int
+	SyncSent           bool
+	CurrInstruction    int
+	DblInstruction     int
+	InhibitPCInc       bool
+	InhibitPC          bool
+	X2IsRead           bool
+	X3IsRead           bool
+	JamCycle           int
+}
+
 func (d *Decoder) Init() {
 	d.DecodedInstruction = "NOP"
-	d.InstChanged = true
 	d.clockCount = 0
 	d.syncSent = false
 	d.currInstruction = -1
 	d.Flags = make(map[int]DecoderFlag)
-	d.Flags[Sync] = DecoderFlag{"SYNC", 0, false}
-	d.Flags[BusDir] = DecoderFlag{"BDIR", 0, false}
-	d.Flags[BusTurnAround] = DecoderFlag{"BTA ", 0, false}
-	d.Flags[InstRegOut] = DecoderFlag{"INSO  ", 0, false}
-	d.Flags[InstRegLoad] = DecoderFlag{"INSL  ", 0, false}
-	d.Flags[PCOut] = DecoderFlag{"PCO ", 0, false}
-	d.Flags[PCLoad] = DecoderFlag{"PCL ", 0, false}
-	d.Flags[PCInc] = DecoderFlag{"PCI ", 0, false}
-	d.Flags[AccOut] = DecoderFlag{"ACCO  ", 0, false}
-	d.Flags[AccLoad] = DecoderFlag{"ACCL  ", 0, false}
-	d.Flags[AccInst] = DecoderFlag{"ACCL  ", -1, false}
-	d.Flags[TempOut] = DecoderFlag{"TMPO  ", 0, false}
-	d.Flags[TempLoad] = DecoderFlag{"TMPL  ", 0, false}
-	d.Flags[AluOut] = DecoderFlag{"ALUO", 0, false}
-	d.Flags[AluEval] = DecoderFlag{"ALUE", 0, false}
-	d.Flags[AluMode] = DecoderFlag{"ALUM", 0, false}
-	d.Flags[ScratchPadIndex] = DecoderFlag{"SPI ", -1, false}
-	d.Flags[ScratchPadLoad4] = DecoderFlag{"SPL4", 0, false}
-	d.Flags[ScratchPadLoad8] = DecoderFlag{"SPL8", 0, false}
-	d.Flags[ScratchPadOut] = DecoderFlag{"SPO ", 0, false}
-	d.Flags[ScratchPadInc] = DecoderFlag{"SP+ ", 0, false}
-	d.Flags[StackPush] = DecoderFlag{"PUSH", 0, false}
-	d.Flags[StackPop] = DecoderFlag{"POP ", 0, false}
-	d.Flags[DecodeInstruction] = DecoderFlag{"DEC ", 0, false}
-	d.Flags[EvalulateJCN] = DecoderFlag{"EJCN", 0, false}
-	d.Flags[EvalulateISZ] = DecoderFlag{"EISZ", 0, false}
+	d.Flags[Sync] = DecoderFlag{"SYNC", 0}
+	d.Flags[BusDir] = DecoderFlag{"BDIR", 0}
+	d.Flags[BusTurnAround] = DecoderFlag{"BTA ", 0}
+	d.Flags[InstRegOut] = DecoderFlag{"INSO  ", 0}
+	d.Flags[InstRegLoad] = DecoderFlag{"INSL  ", 0}
+	d.Flags[PCOut] = DecoderFlag{"PCO ", 0}
+	d.Flags[PCLoad] = DecoderFlag{"PCL ", 0}
+	d.Flags[PCInc] = DecoderFlag{"PCI ", 0}
+	d.Flags[AccOut] = DecoderFlag{"ACCO  ", 0}
+	d.Flags[AccLoad] = DecoderFlag{"ACCL  ", 0}
+	d.Flags[AccInst] = DecoderFlag{"ACCL  ", -1}
+	d.Flags[TempOut] = DecoderFlag{"TMPO  ", 0}
+	d.Flags[TempLoad] = DecoderFlag{"TMPL  ", 0}
+	d.Flags[AluOut] = DecoderFlag{"ALUO", 0}
+	d.Flags[AluEval] = DecoderFlag{"ALUE", 0}
+	d.Flags[AluMode] = DecoderFlag{"ALUM", 0}
+	d.Flags[ScratchPadIndex] = DecoderFlag{"SPI ", -1}
+	d.Flags[ScratchPadLoad4] = DecoderFlag{"SPL4", 0}
+	d.Flags[ScratchPadLoad8] = DecoderFlag{"SPL8", 0}
+	d.Flags[ScratchPadOut] = DecoderFlag{"SPO ", 0}
+	d.Flags[ScratchPadInc] = DecoderFlag{"SP+ ", 0}
+	d.Flags[StackPush] = DecoderFlag{"PUSH", 0}
+	d.Flags[StackPop] = DecoderFlag{"POP ", 0}
+	d.Flags[DecodeInstruction] = DecoderFlag{"DEC ", 0}
+	d.Flags[EvalulateJCN] = DecoderFlag{"EJCN", 0}
+	d.Flags[EvalulateISZ] = DecoderFlag{"EISZ", 0}
+	d.Flags[SampleTest] = DecoderFlag{"TEST", 0}
+	d.Flags[CmROMOut] = DecoderFlag{"CMRO", 0}
+	d.Flags[CmRAMOut] = DecoderFlag{"CMRA", 0}
+	d.Flags[ExtInst] = DecoderFlag{"EXT ", -1}
+	d.Flags[Interrupt] = DecoderFlag{"INT ", 0}
+	d.Flags[SrcLatch] = DecoderFlag{"SRCL", 0}
+	d.Flags[SrcOut] = DecoderFlag{"SRCO", 0}
+	d.Flags[NopOut] = DecoderFlag{"NOPO", 0}
+}
+
+// SetModel4040 turns decoding of the 4040 instructions on or off
+func (d *Decoder) SetModel4040(enable bool) {
+	d.is4040 = enable
+}
+
+// Reset puts the decoder back in its power-on state
+func (d *Decoder) Reset() {
+	d.clearState()
+	d.DecodedInstruction = "NOP"
+	d.clockCount = 0
+	d.instPhase = 0
+	d.resetFlags()
+}
+
+// CalculateResetFlags is used instead of CalculateFlags while the RESET pin is
+// active. Any instruction in progress is dropped, but the timing keeps running
+// so SYNC is still sent to the other chips. After RESET is released, the decoder
+// waits for the start of the next instruction cycle, like it does at power-on
+func (d *Decoder) CalculateResetFlags() {
+	d.resetFlags()
+	d.clearState()
+	if d.DecodedInstruction != "RESET" {
+		d.setDecodedInstruction("RESET")
+	}
+	if d.clockCount == 7 {
+		d.writeFlag(Sync, 1)
+	}
+}
+
+func (d *Decoder) clearState() {
+	d.syncSent = false
+	d.currInstruction = -1
+	d.dblInstruction = 0
+	d.inhibitPCInc = false
+	d.inhibitPC = false
+	d.x2IsRead = false
+	d.x3IsRead = false
+	d.jamCycle = JamNone
+}
+
+// Checkpoint returns the state of the decoder, to restore it later
+func (d *Decoder) Checkpoint() DecoderCheckpoint {
+	c := DecoderCheckpoint{
+		Flags:              make([]int, END),
+		DecodedInstruction: d.DecodedInstruction,
+		ClockCount:         d.clockCount,
+		InstPhase:          d.instPhase,
+		SyncSent:           d.syncSent,
+		CurrInstruction:    d.currInstruction,
+		DblInstruction:     d.dblInstruction,
+		InhibitPCInc:       d.inhibitPCInc,
+		InhibitPC:          d.inhibitPC,
+		X2IsRead:           d.x2IsRead,
+		X3IsRead:           d.x3IsRead,
+		JamCycle:           d.jamCycle,
+	}
+	for i := range c.Flags {
+		c.Flags[i] = d.Flags[i].Value
+	}
+	return c
+}
+
+// CheckRestore returns the error Restore would return, without changing anything
+func (d *Decoder) CheckRestore(c DecoderCheckpoint) error {
+	if len(c.Flags) != END {
+		return fmt.Errorf("decoder: checkpoint has %d flags, expected %d", len(c.Flags), END)
+	}
+	return nil
+}
+
+// Restore puts the decoder back in a saved state
+func (d *Decoder) Restore(c DecoderCheckpoint) error {
+	if err := d.CheckRestore(c); err != nil {
+		return err
+	}
+	for i, value := range c.Flags {
+		flag := d.Flags[i]
+		flag.Value = value
+		d.Flags[i] = flag
+	}
+	d.DecodedInstruction = c.DecodedInstruction
+	d.clockCount = c.ClockCount
+	d.instPhase = c.InstPhase
+	d.syncSent = c.SyncSent
+	d.currInstruction = c.CurrInstruction
+	d.dblInstruction = c.DblInstruction
+	d.inhibitPCInc = c.InhibitPCInc
+	d.inhibitPC = c.InhibitPC
+	d.x2IsRead = c.X2IsRead
+	d.x3IsRead = c.X3IsRead
+	d.jamCycle = c.JamCycle
+	return nil
 }
 
 func (d *Decoder) GetClockCount() int {
 	return d.instPhase
 }
 
+// IsSecondCycle returns true if we are in the second cycle of a two cycle instruction
+func (d *Decoder) IsSecondCycle() bool {
+	return d.dblInstruction != 0
+}
+
 func (d *Decoder) resetFlags() {
 	for i := 0; i < END; i++ {
-		if i == ScratchPadIndex || i == AccInst {
+		if i == ScratchPadIndex || i == AccInst || i == ExtInst {
 			d.clearFlag(i, -1)
 		} else {
 			d.clearFlag(i, 0)
@@ -156,14 +320,12 @@ func (d *Decoder) resetFlags() {
 
 func (d *Decoder) clearFlag(index int, value int) {
 	flag := d.Flags[index]
-	flag.Changed = flag.Value != value
 	flag.Value = value
 	d.Flags[index] = flag
 }
 
 func (d *Decoder) writeFlag(index int, value int) {
 	flag := d.Flags[index]
-	flag.Changed = true // we always set changed so the UI can show the write
 	flag.Value = value
 	d.Flags[index] = flag
 	rlog.Tracef(0, "Wrote Flag: Name=%s, value=%d. ClkCnt=%d", flag.Name, d.Flags[index].Value, d.clockCount)
@@ -193,6 +355,8 @@ func (d *Decoder) CalculateFlags() {
 				d.writeFlag(PCInc, 1)
 			}
 		}
+		// The increment is only ever blocked for one instruction cycle
+		d.inhibitPCInc = false
 	}
 
 	// Continue to decode instructions after clock 5
@@ -202,6 +366,9 @@ func (d *Decoder) CalculateFlags() {
 
 	switch d.clockCount {
 	case 0:
+		// The execution cycles of the previous instruction are done
+		d.x2IsRead = false
+		d.x3IsRead = false
 		if !d.inhibitPC {
 			// Drive the current address (nybble 0) to the external bus
 			d.writeFlag(PCOut, 1)
@@ -217,44 +384,62 @@ func (d *Decoder) CalculateFlags() {
 		// Drive the current address (nybble 2) to the external bus
 		d.writeFlag(BusDir, common.DirOut)
 		d.writeFlag(PCOut, 1)
+		// CM-ROM selects the ROM bank for the instruction fetch.
+		// Nothing is fetched in a jammed cycle
+		if d.jamCycle == JamNone {
+			d.writeFlag(CmROMOut, 1)
+		}
 	case 3:
-		if d.syncSent {
+		if d.jamCycle != JamNone {
+			d.jamNop()
+		} else if d.syncSent {
 			d.writeFlag(BusDir, common.DirIn)
 		}
 	case 4:
-		if d.syncSent {
+		if d.jamCycle != JamNone {
+			d.jamNop()
+		} else if d.syncSent {
 			// Read the OPR from the external bus and write it into the instruction register
 			d.writeFlag(BusDir, common.DirIn)
 			d.writeFlag(InstRegLoad, 1)
 		}
 	case 5:
-		if d.syncSent {
+		if d.jamCycle != JamNone {
+			d.writeFlag(DecodeInstruction, 1)
+		} else if d.syncSent {
 			d.writeFlag(BusDir, common.DirIn)
 			d.writeFlag(InstRegLoad, 1)
 			d.writeFlag(DecodeInstruction, 1)
 		}
 	case 6:
 		if d.syncSent {
-			// If the X2 cycle is a read, read the external bus
-			if d.x2IsRead {
-				d.writeFlag(BusDir, common.DirIn)
-			} else {
+			// If the X2 cycle is a read, leave the external bus to the
+			// RAM or ROM. The data is latched in X3, after it was driven
+			if !d.x2IsRead {
 				d.writeFlag(BusDir, common.DirOut)
 			}
 		}
 	case 7:
 		// If the X3 cycle is a read, read the external bus
-		if d.x3IsRead {
+		// The data read in X2 is also latched from the external bus here
+		if d.x2IsRead || d.x3IsRead {
 			d.writeFlag(BusDir, common.DirIn)
 		} else {
 			d.writeFlag(BusDir, common.DirOut)
 		}
+		// The TEST pin is sampled in X3 of every instruction cycle.
+		// JCN evaluates the value sampled during its first cycle
+		d.writeFlag(SampleTest, 1)
 		d.writeFlag(Sync, 1)
 	}
 }
 
 // SetCurrentInstruction set the current instruction from the instruction register
 func (d *Decoder) SetCurrentInstruction(inst uint64, evalResult bool) (err error) {
+	if d.jamCycle != JamNone {
+		d.decodeJamCycle()
+		return nil
+	}
 	if d.dblInstruction == 0 {
 		if inst != 0 {
 			rlog.Debugf("SetCurrentInstruction: %02X", inst)
@@ -271,6 +456,10 @@ func (d *Decoder) decodeCurrentInstruction(evalResult bool) (err error) {
 	opr := d.currInstruction & 0xf0
 	fullInst := d.currInstruction
 	switch opr {
+	case NOP:
+		if d.is4040 {
+			err = d.handle4040(fullInst, evalResult)
+		}
 	// Note FIN and JIN share the same upper 4 bits
 	case FIN & 0xf0:
 		err = d.handleFIN_JIN(fullInst, evalResult)
@@ -303,18 +492,27 @@ func (d *Decoder) decodeCurrentInstruction(evalResult bool) (err error) {
 	// Collectively, all the accumulator instructions
 	case ACC:
 		err = d.handleACC(fullInst, evalResult)
-	}
-
-	// These instructions require decoding the entire 8 bits
-	switch fullInst {
-	case WRR:
-		err = d.handleWRR(fullInst, evalResult)
+	// Collectively, all the I/O and RAM instructions
+	case IO:
+		err = d.handleIO(fullInst, evalResult)
 	}
 	return err
 }
 
 func (d *Decoder) setDecodedInstruction(inst string) {
 	d.DecodedInstruction = inst
-	d.InstChanged = true
 	rlog.Debugf("--- Decoded instruction is: %s", d.DecodedInstruction)
 }
+
+// IsTwoCycleInstruction returns true if the instruction takes a second instruction
+// cycle, during which the byte fetched from ROM is data instead of an opcode
+func IsTwoCycleInstruction(inst uint64) bool {
+	switch inst & 0xf0 {
+	case JCN, JUN, JMS, ISZ:
+		return true
+	case FIM_SRC, FIN & 0xf0:
+		// FIM and FIN have bit 0 cleared. SRC and JIN are single cycle
+		return (inst & 0x1) == 0
+	}
+	return false
+}
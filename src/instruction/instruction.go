@@ -14,10 +14,25 @@ type Instruction struct {
 	dataBus    *common.Bus
 	width      int
 	mask       uint64
-	drivingBus bool
+	drivingBus bool // The instruction register drove the data bus during this clock
 	writeCount int
 }
 
+// State is a snapshot of the instruction register
+type State struct {
+	IO          common.RegisterState // The nybble to or from the data bus
+	Instruction common.RegisterState
+	DrivingBus  bool
+}
+
+// Checkpoint is the saved state of the instruction register
+type Checkpoint struct {
+	IO          uint64
+	Instruction uint64
+	DrivingBus  bool
+	WriteCount  int
+}
+
 func (r *Instruction) Init(dataBus *common.Bus, width int) {
 	r.busReg.Init(dataBus, width, "I/O ")
 	r.instReg.Init(nil, 8, "INST ")
@@ -30,6 +45,34 @@ func (r *Instruction) Init(dataBus *common.Bus, width int) {
 	r.dataBus = dataBus
 }
 
+// State returns a snapshot of the instruction register
+func (r *Instruction) State() State {
+	return State{IO: r.busReg.State(), Instruction: r.instReg.State(), DrivingBus: r.drivingBus}
+}
+
+// ClearDrivingBus is called at the start of each clock
+func (r *Instruction) ClearDrivingBus() {
+	r.drivingBus = false
+}
+
+// Checkpoint returns the state of the instruction register, to restore it later
+func (r *Instruction) Checkpoint() Checkpoint {
+	return Checkpoint{
+		IO:          r.busReg.ReadDirect(),
+		Instruction: r.instReg.ReadDirect(),
+		DrivingBus:  r.drivingBus,
+		WriteCount:  r.writeCount,
+	}
+}
+
+// Restore puts the instruction register back in a saved state
+func (r *Instruction) Restore(c Checkpoint) {
+	r.busReg.WriteDirect(c.IO)
+	r.instReg.WriteDirect(c.Instruction)
+	r.drivingBus = c.DrivingBus
+	r.writeCount = c.WriteCount
+}
+
 func (r *Instruction) GetInstructionRegister() uint64 {
 	return r.instReg.ReadDirect()
 }
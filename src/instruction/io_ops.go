@@ -1,10 +1,42 @@
 package instruction
 
-import "fmt"
+import (
+	"alu"
+	"fmt"
+)
 
 func (d *Decoder) handleFIM_SRC(fullInst int, evalResult bool) (err error) {
 	if (fullInst & 0x1) == 0 {
-		d.setDecodedInstruction("FIM")
+		// Fetch immediate data from ROM into the register pair
+		// Are we on the first phase?
+		if d.dblInstruction == 0 {
+			if d.clockCount == 5 {
+				d.setDecodedInstruction(fmt.Sprintf("FIM %X", (d.currInstruction&0xf)>>1))
+			} else if d.clockCount == 6 {
+				// The next cycle fetches the data, so hold on to the instruction
+				d.dblInstruction = d.currInstruction
+				d.currInstruction = -1
+			}
+		} else {
+			if d.clockCount == 5 {
+				// The instruction register now contains the data from ROM
+				// Output the upper 4 bits
+				d.writeFlag(InstRegOut, 2)
+			} else if d.clockCount == 6 {
+				// Load the upper 4 bits into the even register of the pair
+				d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)) // Note - we are chopping bit 0
+				d.writeFlag(ScratchPadLoad4, 1)
+				// Output the lower 4 bits
+				d.writeFlag(InstRegOut, 1)
+			} else if d.clockCount == 7 {
+				// Load the lower 4 bits into the odd register of the pair
+				d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+1) // Note - we are chopping bit 0
+				d.writeFlag(ScratchPadLoad4, 1)
+				// Done
+				d.dblInstruction = 0
+				d.currInstruction = -1
+			}
+		}
 	} else {
 		// Send I/O address to ROM/RAM
 		d.setDecodedInstruction(fmt.Sprintf("SRC %X", (d.currInstruction&0xf)>>1))
@@ -12,24 +44,91 @@ func (d *Decoder) handleFIM_SRC(fullInst int, evalResult bool) (err error) {
 			// Output the selected scratchpad register
 			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)) // Note - we are chopping bit 0
 			d.writeFlag(ScratchPadOut, 1)
+			// Select the ROM and RAM banks for the SRC address
+			d.writeFlag(CmROMOut, 1)
+			d.writeFlag(CmRAMOut, 1)
+			if d.is4040 {
+				// The 4040 keeps the address for the return from an interrupt
+				d.writeFlag(SrcLatch, 2)
+			}
 		} else if d.clockCount == 7 {
 			// Output the selected scratchpad register + 1
 			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+1) // Note - we are chopping bit 0
 			d.writeFlag(ScratchPadOut, 1)
+			if d.is4040 {
+				d.writeFlag(SrcLatch, 1)
+			}
 			d.currInstruction = -1
 		}
 	}
 	return err
 }
 
-func (d *Decoder) handleWRR(fullInst int, evalResult bool) (err error) {
-	// ROM I/O Write
-	// Send I/O address to ROM/RAM
-	d.setDecodedInstruction("WRR")
-	if d.clockCount == 6 {
+func (d *Decoder) handleIO(fullInst int, evalResult bool) (err error) {
+	// I/O and RAM instructions. These require decoding the entire 8 bits
+	switch fullInst {
+	case WRM, WMP, WRR, WPM, WR0, WR1, WR2, WR3:
+		err = d.handleIOWrite(fullInst, evalResult)
+	case RDM, RDR, RD0, RD1, RD2, RD3:
+		err = d.handleIORead(fullInst, evalResult)
+	case ADM, SBM:
+		err = d.handleIOArithmetic(fullInst, evalResult)
+	}
+	return err
+}
+
+func (d *Decoder) handleIOWrite(fullInst int, evalResult bool) (err error) {
+	// Write the accumulator to the ROM/RAM selected by the last SRC
+	if d.clockCount == 5 {
+		d.setDecodedInstruction(ioInstToString(fullInst))
+	} else if d.clockCount == 6 {
 		// Output the accumulator to the external bus
 		d.writeFlag(AccOut, 1)
 		d.currInstruction = -1
 	}
 	return err
 }
+
+func (d *Decoder) handleIORead(fullInst int, evalResult bool) (err error) {
+	// Read the ROM/RAM selected by the last SRC into the accumulator
+	if d.clockCount == 5 {
+		d.setDecodedInstruction(ioInstToString(fullInst))
+		// The ROM/RAM drives the external bus in X2
+		d.x2IsRead = true
+	} else if d.clockCount == 7 {
+		// Load the data from the external bus into the accumulator
+		d.writeFlag(AccLoad, 1)
+		d.currInstruction = -1
+	}
+	return err
+}
+
+func (d *Decoder) handleIOArithmetic(fullInst int, evalResult bool) (err error) {
+	// Add/subtract the RAM character selected by the last SRC to/from the accumulator
+	if d.clockCount == 5 {
+		d.setDecodedInstruction(ioInstToString(fullInst))
+		if fullInst == ADM {
+			d.writeFlag(AluMode, alu.AluIntModeAdd)
+		} else {
+			d.writeFlag(AluMode, alu.AluIntModeSub)
+		}
+		// The RAM drives the external bus in X2
+		d.x2IsRead = true
+	} else if d.clockCount == 7 {
+		// Load the data from the external bus into the temp register
+		d.writeFlag(TempLoad, 1)
+	} else if d.clockCount == 0 {
+		// Evaluate the ALU and write the value into the accumulator
+		d.writeFlag(AluEval, 1)
+		d.writeFlag(AccLoad, 1)
+		d.currInstruction = -1
+	}
+	return err
+}
+
+var ioInstStrings = []string{"WRM", "WMP", "WRR", "WPM", "WR0", "WR1", "WR2", "WR3",
+	"SBM", "RDM", "RDR", "ADM", "RD0", "RD1", "RD2", "RD3"}
+
+func ioInstToString(inst int) string {
+	return ioInstStrings[inst&0xf]
+}
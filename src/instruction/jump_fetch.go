@@ -47,6 +47,12 @@ func (d *Decoder) handleJCN_JMS_ISZ_JUN(fullInst int, evalResult bool) (err erro
 				blockJump = !evalResult
 			}
 			if !blockJump {
+				if opr == JCN || opr == ISZ {
+					// The 8-bit address is in the page of the next instruction,
+					// so move the PC there before loading the lower 8 bits.
+					// This matters when the second byte is the last one in a page
+					d.writeFlag(PCInc, 1)
+				}
 				// Block the PC increment
 				d.inhibitPCInc = true
 				// Output the lower 4 bits of the instruction register
@@ -90,22 +96,27 @@ func (d *Decoder) handleJCN_JMS_ISZ_JUN(fullInst int, evalResult bool) (err erro
 	return err
 }
 
+// handleFIN_JIN decodes FIN and JIN. Like FIM and SRC, they use the even
+// register of a pair for the upper 4 bits, and the odd register for the lower 4
 func (d *Decoder) handleFIN_JIN(fullInst int, evalResult bool) (err error) {
 	if (fullInst & 0xf1) == JIN {
 		// Jump indirect to address in specified register pair
+		// The jump stays in the page of the next instruction
 		if d.clockCount == 6 {
 			d.setDecodedInstruction(fmt.Sprintf("JIN %X", d.currInstruction&0xe))
-			// Output the lower address to the program counter
-			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+0) // Note - we are chopping bit 0
+			// Output the lower address (odd register) to the program counter
+			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+1) // Note - we are chopping bit 0
 			d.writeFlag(ScratchPadOut, 1)
+			// Move to the page of the next instruction
+			d.writeFlag(PCInc, 1)
 			// Block the PC increment
 			d.inhibitPCInc = true
 		} else if d.clockCount == 7 {
 			// Load the lowest 4 bits into the PC
 			d.writeFlag(PCLoad, 1)
 
-			// Output the lower address to the program counter
-			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+1) // Note - we are chopping bit 0
+			// Output the middle address (even register) to the program counter
+			d.writeFlag(ScratchPadIndex, int(d.currInstruction&0xe)+0) // Note - we are chopping bit 0
 			d.writeFlag(ScratchPadOut, 1)
 		} else if d.clockCount == 0 {
 			// Load the middle 4 bits into the PC
@@ -116,21 +127,23 @@ func (d *Decoder) handleFIN_JIN(fullInst int, evalResult bool) (err error) {
 		}
 	} else if (fullInst & 0xf1) == FIN {
 		// Fetch indirect to address in register pair 0
-		// then store the result in specified register pair
+		// then store the result in specified register pair.
+		// The PC is incremented as usual in the first cycle, so the fetch is
+		// from the page of the next instruction
 		if d.clockCount == 0 {
-			// Output the lower address to the data bus
-			d.writeFlag(ScratchPadIndex, 0)
+			// Output the lower address (odd register) to the data bus
+			d.writeFlag(ScratchPadIndex, 1)
 			d.writeFlag(ScratchPadOut, 1)
-			// UnBlock the PC increment
-			d.inhibitPCInc = false
+			// The PC already points to the next instruction
+			d.inhibitPCInc = true
 			// Disable the program counter from using the bus
 			d.inhibitPC = true
 			// Mark this as a double instruction to prevent the instruction register
 			// from being clobbered
 			d.dblInstruction = d.currInstruction
 		} else if d.clockCount == 1 {
-			// Output the middle address to the data bus
-			d.writeFlag(ScratchPadIndex, 1)
+			// Output the middle address (even register) to the data bus
+			d.writeFlag(ScratchPadIndex, 0)
 			d.writeFlag(ScratchPadOut, 1)
 		} else if d.clockCount == 2 {
 			// Unblock the PC
@@ -145,11 +158,8 @@ func (d *Decoder) handleFIN_JIN(fullInst int, evalResult bool) (err error) {
 			d.writeFlag(ScratchPadIndex, int(d.dblInstruction&0xe)+1) // Note - we are chopping bit 0
 			d.writeFlag(ScratchPadLoad4, 1)
 			// Done
-			d.dblInstruction = 1
+			d.dblInstruction = 0
 			d.currInstruction = -1
-		} else if d.clockCount == 6 && d.dblInstruction <= 0 {
-			// Block the PC increment
-			d.inhibitPCInc = true
 		}
 	}
 	return err
@@ -10,9 +10,8 @@ import (
 
 // Renderer contains all the rendering code of our cpu
 type Renderer struct {
-	instReg *Instruction
-	dirty   int
-	bounds  image.Rectangle
+	dirty  int
+	bounds image.Rectangle
 
 	// Child Renderers
 	ioRenderer                 common.RegisterRenderer
@@ -21,8 +20,7 @@ type Renderer struct {
 }
 
 // InitRender Initializes the renderer
-func (r *Renderer) InitRender(instReg *Instruction, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.instReg = instReg
+func (r *Renderer) InitRender(canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 
@@ -31,24 +29,23 @@ func (r *Renderer) InitRender(instReg *Instruction, canvas *canvas.Canvas, bound
 	busWidth := 20
 	regX := 20
 
-	r.instructionDataBusRenderer.InitRender(r.instReg.dataBus,
+	r.instructionDataBusRenderer.InitRender(
 		image.Point{int(css.RegisterWidth/2) + regX + r.bounds.Min.X, r.bounds.Min.Y},
 		image.Point{int(css.RegisterWidth/2) + regX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		busWidth)
 
-	r.ioRenderer.InitRender(&r.instReg.busReg, image.Rectangle{
+	r.ioRenderer.InitRender(image.Rectangle{
 		image.Point{regX + r.bounds.Min.X, r.bounds.Min.Y + busHeight},
 		image.Point{regX + r.bounds.Min.X + int(css.RegisterWidth), r.bounds.Min.Y + busHeight + int(css.RegisterHeight)}})
 
-	r.instructionRenderer.InitRender(&r.instReg.instReg, image.Rectangle{
+	r.instructionRenderer.InitRender(image.Rectangle{
 		image.Point{regX + r.bounds.Min.X, r.bounds.Min.Y + busHeight + int(css.RegisterHeight)},
 		image.Point{regX + r.bounds.Min.X + int(css.RegisterWidth), r.bounds.Min.Y + busHeight + 2*int(css.RegisterHeight)}})
 }
 
-// Render the contents to the screen
-func (r *Renderer) Render(canvas *canvas.Canvas) {
-	r.instructionDataBusRenderer.DrivingBus = &r.instReg.drivingBus
-	r.instructionDataBusRenderer.Render(canvas)
-	r.ioRenderer.Render(canvas)
-	r.instructionRenderer.Render(canvas)
+// Render the state to the screen. The data bus is the internal bus of the CPU
+func (r *Renderer) Render(canvas *canvas.Canvas, state State, dataBus common.BusState) {
+	r.instructionDataBusRenderer.Render(canvas, dataBus, state.DrivingBus)
+	r.ioRenderer.Render(canvas, state.IO)
+	r.instructionRenderer.Render(canvas, state.Instruction)
 }
@@ -1,5 +1,7 @@
 package interfaces
 
+// BusDriver is anything which writes to a bus. The name identifies it in bus
+// collision reports, so it should include the chip, like "4001 #3 I/O BUF"
 type BusDriver interface {
-	Drive()
+	DriverName() string
 }
@@ -1,6 +1,21 @@
 package interfaces
 
+// ClockedElement is clocked by the two phase clock of a 4201. Inputs are
+// sampled in ClockIn (φ1), and outputs are driven in ClockOut (φ2)
 type ClockedElement interface {
 	Reset()
-	Clock()
+	ClockIn()
+	ClockOut()
+}
+
+// Calculator is a clocked element which updates its internal logic before φ1
+type Calculator interface {
+	Calculate()
+}
+
+// BusOwner is a clocked element which resets the shared buses at the start of
+// its ClockOut, like the CPU core does with the external data bus. It must be
+// clocked before the other drivers of those buses in each phase
+type BusOwner interface {
+	OwnsBuses()
 }
@@ -0,0 +1,68 @@
+package ram4002
+
+import (
+	"common"
+	"events"
+	"interfaces"
+	"supportcommon"
+)
+
+const BusWidth = 4
+const Depth = supportcommon.RamRegisters * supportcommon.RamCharacters
+
+// Ram4002 is a model of the Intel 4002 RAM
+type Ram4002 struct {
+	interfaces.ClockedElement
+	Core   supportcommon.RamRom
+	busInt common.Bus // Internal Data Bus for address/data
+}
+
+// Init initializes the RAM. cm is the CM-RAM line for the bank this chip is in
+func (r *Ram4002) Init(busExt *common.Bus, sync *common.Signal, cm *common.Signal) {
+	r.busInt.Init(BusWidth, "RAM Internal")
+	r.Core.Init(busExt, &r.busInt, sync, cm, BusWidth, Depth)
+	r.Core.SetChipType(supportcommon.ChipTypeRam)
+}
+
+// SetIOBus sets the bus driven by the output port
+func (r *Ram4002) SetIOBus(bus *common.Bus) {
+	r.Core.SetIOBus(bus)
+}
+
+// SetBusMonitor attaches a monitor to the internal bus
+func (r *Ram4002) SetBusMonitor(m *common.BusMonitor) {
+	r.Core.SetBusMonitor(m)
+}
+
+// SetEvents raises the chip events through the dispatcher
+func (r *Ram4002) SetEvents(d *events.Dispatcher) {
+	r.Core.SetEvents(d)
+}
+
+// SetResetLine connects the RESET input
+func (r *Ram4002) SetResetLine(reset *common.Signal) {
+	r.Core.SetResetLine(reset)
+}
+
+// SetChipID sets the chip number (0-3) within the bank
+func (r *Ram4002) SetChipID(id int) {
+	r.Core.SetChipID(id)
+}
+
+func (r *Ram4002) GetClockCount() int {
+	return r.Core.GetClockCount()
+}
+
+func (r *Ram4002) Reset() {
+	r.Core.Reset()
+}
+
+// ClockIn clock in external inputs to the Core
+func (r *Ram4002) ClockIn() {
+	r.Core.ClockIn()
+}
+
+// ClockOut clock external outputs to their respective busses/logic lines
+func (r *Ram4002) ClockOut() {
+	r.Core.ClockOut()
+}
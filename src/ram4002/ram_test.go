@@ -0,0 +1,294 @@
+package ram4002
+
+import (
+	"common"
+	"instruction"
+	"os"
+	"supportcommon"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "ram_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+const testDriver = common.NamedDriver("Jig")
+
+type ramTestJig struct {
+	ram     Ram4002
+	dataBus common.Bus
+	ioBus   common.Bus
+	sync    common.Signal
+	cmRam   common.Signal
+	reset   common.Signal
+}
+
+func createTestJig() *ramTestJig {
+	jig := ramTestJig{}
+	jig.dataBus.Init(4, "JIG external bus")
+	jig.ioBus.Init(4, "RAM output port")
+	jig.ram.Init(&jig.dataBus, &jig.sync, &jig.cmRam)
+	jig.ram.SetIOBus(&jig.ioBus)
+	jig.ram.SetResetLine(&jig.reset)
+	syncRAM(&jig)
+	return &jig
+}
+
+func DumpState(jig *ramTestJig) {
+	rlog.Infof("DBUS=%X, IOBUS=%X, SYNC=%d, CCLK=%d",
+		jig.dataBus.Read(),
+		jig.ioBus.Read(),
+		jig.sync.Level(),
+		jig.ram.GetClockCount())
+}
+
+func syncRAM(jig *ramTestJig) {
+	for i := 0; i < 8; i++ {
+		if (i % 8) == 7 {
+			jig.sync.Drive("Jig", 0)
+		} else {
+			jig.sync.Drive("Jig", 1)
+		}
+		jig.ram.ClockIn()
+		jig.ram.ClockOut()
+		jig.dataBus.Reset()
+		jig.dataBus.Write(testDriver, 0)
+	}
+}
+
+// runCycle runs a complete instruction cycle, with the jig acting as the CPU and the ROM.
+// x2 and x3 are driven in the X2 and X3 cycles, unless this is a read.
+// For reads, the value the RAM drove during X2 is returned
+func runCycle(jig *ramTestJig, inst uint8, x2 uint64, x3 uint64, ioRead bool) uint64 {
+	var data uint64
+	for i := 0; i < 8; i++ {
+		jig.sync.Drive("Jig", 1)
+		jig.dataBus.Reset()
+		switch i {
+		case 0, 1, 2:
+			// The RAM does not care about the address
+			jig.dataBus.Write(testDriver, 0)
+		case 3:
+			jig.dataBus.Write(testDriver, uint64(inst>>4)&0xf)
+		case 4:
+			jig.dataBus.Write(testDriver, uint64(inst)&0xf)
+		case 5:
+			// Precharge the bus so we can tell if the RAM drove it
+			jig.dataBus.Write(testDriver, 0xf)
+		case 6:
+			if !ioRead {
+				jig.dataBus.Write(testDriver, x2&0xf)
+			}
+		case 7:
+			jig.sync.Drive("Jig", 0)
+			jig.dataBus.Write(testDriver, x3&0xf)
+		}
+		DumpState(jig)
+		jig.ram.ClockIn()
+		jig.dataBus.Reset()
+		jig.ram.ClockOut()
+		if i == 5 && ioRead {
+			data = jig.dataBus.Read()
+		}
+	}
+	return data
+}
+
+// sendSRC sends a SRC address to the RAM
+func sendSRC(jig *ramTestJig, chip uint64, reg uint64, char uint64) {
+	runCycle(jig, instruction.SRC, (chip<<2)|reg, char, false)
+}
+
+func writeIO(jig *ramTestJig, inst uint8, value uint64) {
+	runCycle(jig, inst, value, 0, false)
+}
+
+func readIO(jig *ramTestJig, inst uint8) uint64 {
+	return runCycle(jig, inst, 0, 0, true)
+}
+
+// holdReset holds RESET for the given number of instruction cycles. The RAM
+// ignores the cycle after RESET is released, since it waits for SYNC
+func holdReset(jig *ramTestJig, cycles int) {
+	jig.reset.Drive("Jig", 1)
+	for i := 0; i < cycles; i++ {
+		runCycle(jig, instruction.NOP, 0, 0, false)
+	}
+	jig.reset.Drive("Jig", 0)
+	runCycle(jig, instruction.NOP, 0, 0, false)
+}
+
+func TestMainMemory(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+
+	// Fill every character of every register
+	for reg := uint64(0); reg < 4; reg++ {
+		for char := uint64(0); char < 16; char++ {
+			sendSRC(jig, 0, reg, char)
+			writeIO(jig, instruction.WRM, (reg*16+char)%15)
+		}
+	}
+
+	// Now read them all back using all three read instructions
+	readInsts := []uint8{instruction.RDM, instruction.ADM, instruction.SBM}
+	for reg := uint64(0); reg < 4; reg++ {
+		for char := uint64(0); char < 16; char++ {
+			sendSRC(jig, 0, reg, char)
+			exp := (reg*16 + char) % 15
+			data := readIO(jig, readInsts[char%3])
+			if data != exp {
+				t.Errorf("RAM read data mismatch. reg=%d, char=%X, exp %X, got %X", reg, char, exp, data)
+			}
+		}
+	}
+}
+
+func TestStatusCharacters(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+
+	writeInsts := []uint8{instruction.WR0, instruction.WR1, instruction.WR2, instruction.WR3}
+	readInsts := []uint8{instruction.RD0, instruction.RD1, instruction.RD2, instruction.RD3}
+
+	// A single SRC selects the register for all the following I/O instructions
+	sendSRC(jig, 0, 2, 0)
+	for i := range writeInsts {
+		writeIO(jig, writeInsts[i], uint64(0xa+i))
+	}
+	for i := range readInsts {
+		data := readIO(jig, readInsts[i])
+		if data != uint64(0xa+i) {
+			t.Errorf("RAM status read mismatch. char=%d, exp %X, got %X", i, 0xa+i, data)
+		}
+	}
+
+	// The status characters of the other registers should not be touched
+	sendSRC(jig, 0, 1, 0)
+	for i := range readInsts {
+		data := readIO(jig, readInsts[i])
+		if data != 0 {
+			t.Errorf("RAM status read mismatch. char=%d, exp %X, got %X", i, 0, data)
+		}
+	}
+
+	// Neither should main memory
+	sendSRC(jig, 0, 2, 0)
+	data := readIO(jig, instruction.RDM)
+	if data != 0 {
+		t.Errorf("RAM read data mismatch. exp %X, got %X", 0, data)
+	}
+}
+
+func TestOutputPort(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+
+	sendSRC(jig, 0, 0, 0)
+	ioData := uint64(0x6)
+	writeIO(jig, instruction.WMP, ioData)
+	if ioData != jig.ioBus.Read() {
+		t.Errorf("Output port did not match. Exp %X, got %X", ioData, jig.ioBus.Read())
+	}
+
+	// A ROM port write should be ignored by the RAM
+	writeIO(jig, instruction.WRR, 0x9)
+	if ioData != jig.ioBus.Read() {
+		t.Errorf("Output port did not match. Exp %X, got %X", ioData, jig.ioBus.Read())
+	}
+}
+
+func TestChipSelect(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+	jig.ram.SetChipID(2)
+
+	// Select chip 2 and write a known value
+	sendSRC(jig, 2, 1, 3)
+	writeIO(jig, instruction.WRM, 0x5)
+
+	// Select chip 0, and make sure we don't respond
+	sendSRC(jig, 0, 1, 3)
+	writeIO(jig, instruction.WRM, 0x7)
+	data := readIO(jig, instruction.RDM)
+	if data != 0xf {
+		t.Errorf("RAM drove the bus when not selected. Got %X", data)
+	}
+
+	// Select chip 2 with CM-RAM inactive (a different bank)
+	jig.cmRam.Drive("Jig", 1) // active low
+	sendSRC(jig, 2, 1, 3)
+	jig.cmRam.Drive("Jig", 0)
+	writeIO(jig, instruction.WRM, 0x7)
+	data = readIO(jig, instruction.RDM)
+	if data != 0xf {
+		t.Errorf("RAM drove the bus when bank not selected. Got %X", data)
+	}
+
+	// Finally, select us properly. The original value should still be there
+	sendSRC(jig, 2, 1, 3)
+	data = readIO(jig, instruction.RDM)
+	if data != 0x5 {
+		t.Errorf("RAM read data mismatch. exp %X, got %X", 0x5, data)
+	}
+}
+
+func TestTwoCycleInstructions(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+
+	sendSRC(jig, 0, 0, 0)
+	writeIO(jig, instruction.WRM, 0x3)
+
+	// The second byte of a jump looks like a WRM, but must not be executed
+	runCycle(jig, instruction.JUN, 0, 0, false)
+	writeIO(jig, instruction.WRM, 0x8)
+
+	data := readIO(jig, instruction.RDM)
+	if data != 0x3 {
+		t.Errorf("RAM read data mismatch. exp %X, got %X", 0x3, data)
+	}
+}
+
+func TestReset(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+
+	sendSRC(jig, 0, 2, 5)
+	writeIO(jig, instruction.WRM, 0x9)
+	writeIO(jig, instruction.WR1, 0x6)
+	writeIO(jig, instruction.WMP, 0xA)
+
+	// A short RESET clears the output port and the SRC latch, but not the memory
+	holdReset(jig, supportcommon.RamResetCycles-1)
+	if jig.ioBus.Read() != 0 {
+		t.Errorf("Output port was not cleared. Got %X", jig.ioBus.Read())
+	}
+	if data := readIO(jig, instruction.RDM); data != 0xf {
+		t.Errorf("RAM responded without a SRC after RESET. Got %X", data)
+	}
+	sendSRC(jig, 0, 2, 5)
+	if data := readIO(jig, instruction.RDM); data != 0x9 {
+		t.Errorf("RAM read data mismatch after short RESET. Exp 9, got %X", data)
+	}
+	if data := readIO(jig, instruction.RD1); data != 0x6 {
+		t.Errorf("RAM status read mismatch after short RESET. Exp 6, got %X", data)
+	}
+
+	// A full RESET clears the memory
+	holdReset(jig, supportcommon.RamResetCycles)
+	sendSRC(jig, 0, 2, 5)
+	if data := readIO(jig, instruction.RDM); data != 0 {
+		t.Errorf("RAM read data mismatch after RESET. Exp 0, got %X", data)
+	}
+	if data := readIO(jig, instruction.RD1); data != 0 {
+		t.Errorf("RAM status read mismatch after RESET. Exp 0, got %X", data)
+	}
+}
@@ -2,6 +2,7 @@ package rom4001
 
 import (
 	"common"
+	"events"
 	"interfaces"
 	"supportcommon"
 )
@@ -16,7 +17,7 @@ type Rom4001 struct {
 	busInt common.Bus // Internal Data Bus for address/data
 }
 
-func (r *Rom4001) Init(busExt *common.Bus, sync *int, cm *int) {
+func (r *Rom4001) Init(busExt *common.Bus, sync *common.Signal, cm *common.Signal) {
 	r.busInt.Init(BusWidth, "ROM Internal")
 	r.Core.Init(busExt, &r.busInt, sync, cm, BusWidth, Depth)
 }
@@ -29,10 +30,44 @@ func (r *Rom4001) LoadProgram(data []uint8) {
 	r.Core.LoadProgram(data)
 }
 
+// SetBusMonitor attaches a monitor to the internal bus
+func (r *Rom4001) SetBusMonitor(m *common.BusMonitor) {
+	r.Core.SetBusMonitor(m)
+}
+
+// SetEvents raises the chip events through the dispatcher
+func (r *Rom4001) SetEvents(d *events.Dispatcher) {
+	r.Core.SetEvents(d)
+}
+
+// SetResetLine connects the RESET input
+func (r *Rom4001) SetResetLine(reset *common.Signal) {
+	r.Core.SetResetLine(reset)
+}
+
 func (r *Rom4001) SetChipID(id int) {
 	r.Core.SetChipID(id)
 }
 
+func (r *Rom4001) GetChipID() int {
+	return r.Core.GetChipID()
+}
+
+// SetIOMask sets the metal mask options of the I/O port. See RamRom.SetIOMask
+func (r *Rom4001) SetIOMask(outputs uint64, inverted uint64) {
+	r.Core.SetIOMask(outputs, inverted)
+}
+
+// IOOutputs returns the output lines of the I/O port
+func (r *Rom4001) IOOutputs() uint64 {
+	return r.Core.IOOutputs()
+}
+
+// IOInverted returns the inverted lines of the I/O port
+func (r *Rom4001) IOInverted() uint64 {
+	return r.Core.IOInverted()
+}
+
 func (r *Rom4001) GetClockCount() int {
 	return r.Core.GetClockCount()
 }
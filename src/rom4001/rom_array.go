@@ -0,0 +1,140 @@
+package rom4001
+
+import (
+	"common"
+	"events"
+	"fmt"
+)
+
+// MaxChips is how many 4001s fit in the 12-bit address space (4 KB)
+const MaxChips = 16
+
+// RomArray is a set of 4001 ROMs sharing the external data bus. Each chip gets
+// the chip ID of its position in the array, and its own I/O port. The chip
+// selected in A3 drives the instruction, and the chip selected by the last SRC
+// drives the I/O reads, so they do not have to be the same chip
+type RomArray struct {
+	Chips   []Rom4001
+	IOBuses []common.Bus
+}
+
+// Init creates numChips ROMs with chip IDs 0..numChips-1
+func (a *RomArray) Init(numChips int, busExt *common.Bus, sync *common.Signal, cm *common.Signal) {
+	if numChips < 1 || numChips > MaxChips {
+		panic(fmt.Sprintf("RomArray: invalid number of chips %d", numChips))
+	}
+	ids := make([]int, numChips)
+	for i := range ids {
+		ids[i] = i
+	}
+	a.InitIDs(ids, busExt, sync, cm)
+}
+
+// InitIDs creates one ROM for each chip ID. The IDs do not have to be contiguous
+func (a *RomArray) InitIDs(ids []int, busExt *common.Bus, sync *common.Signal, cm *common.Signal) {
+	if len(ids) < 1 || len(ids) > MaxChips {
+		panic(fmt.Sprintf("RomArray: invalid number of chips %d", len(ids)))
+	}
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if id < 0 || id >= MaxChips || seen[id] {
+			panic(fmt.Sprintf("RomArray: invalid or duplicate chip ID %d", id))
+		}
+		seen[id] = true
+	}
+	a.Chips = make([]Rom4001, len(ids))
+	a.IOBuses = make([]common.Bus, len(ids))
+	for i, id := range ids {
+		a.IOBuses[i].Init(BusWidth, fmt.Sprintf("ROM %d I/O bus", id))
+		a.Chips[i].Init(busExt, sync, cm)
+		a.Chips[i].SetChipID(id)
+		a.Chips[i].SetIOBus(&a.IOBuses[i])
+	}
+}
+
+// FindChip returns the index of the chip with the chip ID, or -1
+func (a *RomArray) FindChip(id int) int {
+	for i := range a.Chips {
+		if a.Chips[i].GetChipID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Size returns how many bytes the address space up to the highest chip holds
+func (a *RomArray) Size() int {
+	size := 0
+	for i := range a.Chips {
+		if end := (a.Chips[i].GetChipID() + 1) * Depth; end > size {
+			size = end
+		}
+	}
+	return size
+}
+
+// LoadImage splits a flat program image across the chips, 256 bytes per chip,
+// at the address of each chip ID. Chips past the end of the image are cleared
+func (a *RomArray) LoadImage(data []uint8) error {
+	if len(data) > a.Size() {
+		return fmt.Errorf("ROM image is %d bytes, but the chips only cover %d bytes",
+			len(data), a.Size())
+	}
+	for i := range a.Chips {
+		start := a.Chips[i].GetChipID() * Depth
+		chipData := make([]uint8, Depth)
+		if start < len(data) {
+			copy(chipData, data[start:])
+		}
+		a.Chips[i].LoadProgram(chipData)
+	}
+	return nil
+}
+
+// SetBusMonitor attaches a monitor to the internal buses and the I/O buses of all the chips
+func (a *RomArray) SetBusMonitor(m *common.BusMonitor) {
+	for i := range a.Chips {
+		a.Chips[i].SetBusMonitor(m)
+		a.IOBuses[i].SetMonitor(m)
+	}
+}
+
+// SetEvents raises the events of all the chips and their I/O buses through the dispatcher
+func (a *RomArray) SetEvents(d *events.Dispatcher) {
+	for i := range a.Chips {
+		a.Chips[i].SetEvents(d)
+		a.IOBuses[i].SetEvents(d)
+	}
+}
+
+// SetResetLine connects the RESET input of all the chips
+func (a *RomArray) SetResetLine(reset *common.Signal) {
+	for i := range a.Chips {
+		a.Chips[i].SetResetLine(reset)
+	}
+}
+
+// GetClockCount returns the clock count of the first chip. They all run in lock step
+func (a *RomArray) GetClockCount() int {
+	return a.Chips[0].GetClockCount()
+}
+
+func (a *RomArray) Reset() {
+	for i := range a.Chips {
+		a.Chips[i].Reset()
+	}
+}
+
+// ClockIn clock in external inputs to all the chips
+func (a *RomArray) ClockIn() {
+	for i := range a.Chips {
+		a.Chips[i].ClockIn()
+	}
+}
+
+// ClockOut clock external outputs of all the chips
+func (a *RomArray) ClockOut() {
+	for i := range a.Chips {
+		a.Chips[i].ClockOut()
+	}
+}
@@ -0,0 +1,117 @@
+package rom4001
+
+import (
+	"instruction"
+	"testing"
+)
+
+func createArrayTestJig(numChips int) *romTestJig {
+	jig := romTestJig{}
+	jig.dataBus.Init(4, "JIG external bus")
+	jig.array.Init(numChips, &jig.dataBus, &jig.sync, &jig.cmRom)
+	jig.array.SetResetLine(&jig.reset)
+	jig.chips = &jig.array
+	return &jig
+}
+
+func generateArrayImage(size int) []uint8 {
+	data := make([]uint8, size)
+	for i := range data {
+		data[i] = uint8(i*7 + i>>8)
+	}
+	return data
+}
+
+func TestArrayDataRead(t *testing.T) {
+	SetupLogger()
+	jig := createArrayTestJig(MaxChips)
+	image := generateArrayImage(MaxChips * Depth)
+	if err := jig.array.LoadImage(image); err != nil {
+		t.Fatal(err)
+	}
+	syncROM(jig)
+
+	for _, addr := range []uint64{0x000, 0x0ff, 0x100, 0x512, 0xabc, 0xf00, 0xfff} {
+		data := readROM(jig, addr)
+		if data != image[addr] {
+			t.Errorf("ROM read data mismatch at %03X. exp %02X, got %02X", addr, image[addr], data)
+		}
+	}
+}
+
+func TestArrayLoadImage(t *testing.T) {
+	SetupLogger()
+	jig := createArrayTestJig(4)
+	if err := jig.array.LoadImage(generateArrayImage(4*Depth + 1)); err == nil {
+		t.Error("Expected an error for an image larger than the ROM array")
+	}
+	// A short image clears the chips past its end
+	jig.array.LoadImage(generateArrayImage(4 * Depth))
+	image := generateArrayImage(Depth + 0x10)
+	if err := jig.array.LoadImage(image); err != nil {
+		t.Fatal(err)
+	}
+	syncROM(jig)
+	if data := readROM(jig, 0x10f); data != image[0x10f] {
+		t.Errorf("ROM read data mismatch. exp %02X, got %02X", image[0x10f], data)
+	}
+	for _, addr := range []uint64{0x110, 0x2ff, 0x300} {
+		if data := readROM(jig, addr); data != 0 {
+			t.Errorf("ROM at %03X was not cleared. Got %02X", addr, data)
+		}
+	}
+}
+
+func TestArrayIO(t *testing.T) {
+	SetupLogger()
+	jig := createArrayTestJig(MaxChips)
+	image := make([]uint8, MaxChips*Depth)
+	// The program runs from chip 7, but talks to the I/O port of chip 3
+	image[0x700] = instruction.SRC
+	image[0x701] = instruction.WRR
+	image[0x702] = instruction.RDR
+	image[0x703] = instruction.SRC
+	image[0x704] = instruction.RDR
+	if err := jig.array.LoadImage(image); err != nil {
+		t.Fatal(err)
+	}
+	syncROM(jig)
+
+	before := make([]uint64, len(jig.array.IOBuses))
+	for i := range jig.array.IOBuses {
+		before[i] = jig.array.IOBuses[i].Read()
+	}
+	ioData := uint64(3) // Select chip 3
+	readROMFull(jig, 0x700, &ioData, false)
+	ioData = 0xC
+	if data := readROMFull(jig, 0x701, &ioData, false); data != instruction.WRR {
+		t.Errorf("ROM read data mismatch. exp %02X, got %02X", instruction.WRR, data)
+	}
+	for i := range jig.array.IOBuses {
+		exp := before[i]
+		if i == 3 {
+			exp = 0xC
+		}
+		if jig.array.IOBuses[i].Read() != exp {
+			t.Errorf("I/O port %d mismatch. Exp %X, got %X", i, exp, jig.array.IOBuses[i].Read())
+		}
+	}
+
+	// Only the chip selected by SRC drives the I/O read
+	jig.array.IOBuses[3].Reset()
+	jig.array.IOBuses[3].Write(testDriver, 0xA)
+	jig.array.IOBuses[7].Reset()
+	jig.array.IOBuses[7].Write(testDriver, 0x5)
+	data := readROMFull(jig, 0x702, nil, true)
+	if data != 0xA {
+		t.Errorf("I/O read mismatch. Exp A, got %X", data)
+	}
+
+	// Now select the chip we are running from
+	ioData = 7
+	readROMFull(jig, 0x703, &ioData, false)
+	data = readROMFull(jig, 0x704, nil, true)
+	if data != 0x5 {
+		t.Errorf("I/O read mismatch. Exp 5, got %X", data)
+	}
+}
@@ -18,20 +18,34 @@ func SetupLogger() {
 	rlog.Info("Test starting ***********************")
 }
 
+const testDriver = common.NamedDriver("Jig")
+
+// clockedChip is a single ROM or a ROM array
+type clockedChip interface {
+	ClockIn()
+	ClockOut()
+}
+
 type romTestJig struct {
+	chips   clockedChip // What the jig clocks. Usually the single ROM
 	rom     Rom4001
+	array   RomArray
 	dataBus common.Bus
 	ioBus   common.Bus
-	sync    int
-	cmRom   int
+	sync    common.Signal
+	cmRom   common.Signal
+	reset   common.Signal
 }
 
 func createTestJig() *romTestJig {
 	jig := romTestJig{}
 	jig.dataBus.Init(4, "JIG external bus")
+	jig.dataBus.SetFloat(common.FloatPrecharged) // Like the 4004 data bus
 	jig.ioBus.Init(4, "ROM I/O Bus")
 	jig.rom.Init(&jig.dataBus, &jig.sync, &jig.cmRom)
 	jig.rom.SetIOBus(&jig.ioBus)
+	jig.rom.SetResetLine(&jig.reset)
+	jig.chips = &jig.rom
 	return &jig
 }
 
@@ -39,7 +53,7 @@ func DumpState(jig *romTestJig) {
 	rlog.Infof("DBUS=%X, IOBUS=%X, SYNC=%d, CCLK=%d",
 		jig.dataBus.Read(),
 		jig.ioBus.Read(),
-		jig.sync,
+		jig.sync.Level(),
 		jig.rom.GetClockCount())
 }
 
@@ -51,17 +65,18 @@ func generateBlankROMImage() []uint8 {
 func syncROM(jig *romTestJig) {
 	for i := 0; i < 8; i++ {
 		if (i % 8) == 7 {
-			jig.sync = 0
+			jig.sync.Drive("Jig", 0)
 		} else {
-			jig.sync = 1
+			jig.sync.Drive("Jig", 1)
 		}
 		//DumpState(jig)
-		jig.rom.ClockIn()
-		jig.rom.ClockOut()
+		jig.chips.ClockIn()
+		jig.chips.ClockOut()
 		jig.dataBus.Reset()
-		jig.dataBus.Write(0)
+		jig.dataBus.Write(testDriver, 0)
 	}
 }
+
 func readROM(jig *romTestJig, addr uint64) uint8 {
 	return readROMFull(jig, addr, nil, false)
 }
@@ -70,29 +85,29 @@ func readROMFull(jig *romTestJig, addr uint64, ioData *uint64, ioRead bool) uint
 	var data uint64
 	for i := 0; i < 8; i++ {
 		// Write to ROM block
-		jig.sync = 1
+		jig.sync.Drive("Jig", 1)
 		switch i {
 		case 0:
 			jig.dataBus.Reset()
-			jig.dataBus.Write(addr & 0xf)
+			jig.dataBus.Write(testDriver, addr&0xf)
 		case 1:
 			jig.dataBus.Reset()
-			jig.dataBus.Write((addr >> 4) & 0xf)
+			jig.dataBus.Write(testDriver, (addr>>4)&0xf)
 		case 2:
 			jig.dataBus.Reset()
-			jig.dataBus.Write((addr >> 8) & 0xf)
+			jig.dataBus.Write(testDriver, (addr>>8)&0xf)
 		case 6:
 			if !ioRead && ioData != nil {
 				jig.dataBus.Reset()
-				jig.dataBus.Write(*ioData & 0xf)
+				jig.dataBus.Write(testDriver, *ioData&0xf)
 			}
 		case 7:
-			jig.sync = 0
+			jig.sync.Drive("Jig", 0)
 		}
 		DumpState(jig)
-		jig.rom.ClockIn()
+		jig.chips.ClockIn()
 		jig.dataBus.Reset()
-		jig.rom.ClockOut()
+		jig.chips.ClockOut()
 		// Read from ROM block
 		// NOTE: these indicies are one earlier than the actual clock cycle number
 		switch i {
@@ -150,28 +165,28 @@ func TestChipSelectRead(t *testing.T) {
 	jig.rom.LoadProgram(romImage)
 
 	// Read with CM_ROM enabled
-	jig.cmRom = 0 // active low
+	jig.cmRom.Drive("Jig", 0) // active low
 	data := readROM(jig, 0x012)
 	if data != romImage[0x12] {
 		t.Errorf("ROM read data mismatch. exp %02X, got %02X", romImage[0x12], data)
 	}
 
 	// Read with CM_ROM disabled
-	jig.cmRom = 1 // active low
+	jig.cmRom.Drive("Jig", 1) // active low
 	data = readROM(jig, 0x012)
 	if data != 0xFF {
 		t.Errorf("ROM read data mismatch. exp %02X, got %02X", 0xFF, data)
 	}
 
 	// Read with CM_ROM enabled, but with an address outside of our ROM
-	jig.cmRom = 0 // active low
+	jig.cmRom.Drive("Jig", 0) // active low
 	data = readROM(jig, 0x512)
 	if data != 0xFF {
 		t.Errorf("ROM read data mismatch. exp %02X, got %02X", 0xFF, data)
 	}
 
 	// Finally, verify we can change our chip ID to match this address
-	jig.cmRom = 0 // active low
+	jig.cmRom.Drive("Jig", 0) // active low
 	jig.rom.SetChipID(5)
 	data = readROM(jig, 0x512)
 	if data != romImage[0x12] {
@@ -207,7 +222,7 @@ func TestIOWrite(t *testing.T) {
 	readROMFull(jig, 2, &ioData, false)
 	// Set the I/O bus to our expected value
 	jig.ioBus.Reset()
-	jig.ioBus.Write(0xA)
+	jig.ioBus.Write(testDriver, 0xA)
 	ioData = uint64(readROMFull(jig, 3, &ioData, true))
 	// We should now see the data on the IO bus
 	if ioData != jig.ioBus.Read() {
@@ -215,3 +230,41 @@ func TestIOWrite(t *testing.T) {
 	}
 
 }
+
+func TestReset(t *testing.T) {
+	SetupLogger()
+	jig := createTestJig()
+	romImage := generateBlankROMImage()
+	romImage[0] = instruction.FIM_SRC | 1 // Mark this is a SRC
+	romImage[1] = instruction.WRR         // ROM I/O write
+	jig.rom.LoadProgram(romImage)
+
+	syncROM(jig)
+
+	ioData := uint64(0) // Select chip 0
+	readROMFull(jig, 0, &ioData, false)
+	ioData = 0xC
+	readROMFull(jig, 1, &ioData, false)
+	if jig.ioBus.Read() != ioData {
+		t.Errorf("I/O bus did not match. Exp %X, got %X", ioData, jig.ioBus.Read())
+	}
+
+	// RESET clears the I/O port
+	jig.reset.Drive("Jig", 1)
+	readROM(jig, 0)
+	jig.reset.Drive("Jig", 0)
+	if jig.ioBus.Read() != 0 {
+		t.Errorf("I/O port was not cleared by RESET. Got %X", jig.ioBus.Read())
+	}
+	// The ROM waits for SYNC after RESET is released
+	readROM(jig, 0)
+
+	// The SRC latch was cleared too, so WRR is ignored
+	data := readROMFull(jig, 1, &ioData, false)
+	if data != instruction.WRR {
+		t.Errorf("ROM read data mismatch after RESET. Exp %02X, got %02X", instruction.WRR, data)
+	}
+	if jig.ioBus.Read() != 0 {
+		t.Errorf("WRR without SRC wrote the I/O port. Got %X", jig.ioBus.Read())
+	}
+}
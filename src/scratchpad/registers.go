@@ -7,13 +7,31 @@ import (
 	"github.com/romana/rlog"
 )
 
+// BankedRegisters is how many registers (R0-R7) are switched by SB0/SB1 on the 4040
+const BankedRegisters = 8
+
 type Registers struct {
 	regs       []common.Register
 	index      int
+	bank       int // Register bank for R0-R7. Only used if we have more than 16 registers
 	dataBus    *common.Bus
 	width      int
 	mask       uint64
-	drivingBus bool
+	drivingBus bool // A register drove the data bus during this clock
+}
+
+// Checkpoint is the saved state of the scratch pad
+type Checkpoint struct {
+	Regs       []uint64
+	Index      int
+	Bank       int
+	DrivingBus bool
+}
+
+// State is a snapshot of the scratch pad
+type State struct {
+	Regs       []common.RegisterState
+	DrivingBus bool
 }
 
 func (r *Registers) Init(dataBus *common.Bus, width int, depth int) {
@@ -31,12 +49,91 @@ func (r *Registers) Init(dataBus *common.Bus, width int, depth int) {
 	r.dataBus = dataBus
 }
 
+// Reset clears all the registers
+func (r *Registers) Reset() {
+	for i := 0; i < len(r.regs)/2; i++ {
+		r.ResetPair(i)
+	}
+	r.index = 0
+	r.bank = 0
+}
+
+// SetBank selects the register bank used for R0-R7. The extra registers of
+// bank 1 are at the end of the register file
+func (r *Registers) SetBank(bank int) {
+	if len(r.regs) <= 16 {
+		rlog.Warnf("ScratchPad register banks are not supported with %d registers", len(r.regs))
+		return
+	}
+	r.bank = bank & 0x1
+	rlog.Debugf("Selected ScratchPad register bank %d", r.bank)
+}
+
+// GetBank returns the register bank used for R0-R7
+func (r *Registers) GetBank() int {
+	return r.bank
+}
+
+// ResetPair clears one register pair
+func (r *Registers) ResetPair(pair int) {
+	r.regs[pair*2].WriteDirect(0)
+	r.regs[pair*2+1].WriteDirect(0)
+}
+
+// State returns a snapshot of the scratch pad
+func (r *Registers) State() State {
+	s := State{Regs: make([]common.RegisterState, len(r.regs)), DrivingBus: r.drivingBus}
+	for i := range r.regs {
+		s.Regs[i] = r.regs[i].State()
+	}
+	return s
+}
+
+// Checkpoint returns the state of the scratch pad, to restore it later
+func (r *Registers) Checkpoint() Checkpoint {
+	c := Checkpoint{Regs: make([]uint64, len(r.regs)), Index: r.index, Bank: r.bank, DrivingBus: r.drivingBus}
+	for i := range r.regs {
+		c.Regs[i] = r.regs[i].ReadDirect()
+	}
+	return c
+}
+
+// CheckRestore returns the error Restore would return, without changing anything
+func (r *Registers) CheckRestore(c Checkpoint) error {
+	if len(c.Regs) != len(r.regs) {
+		return fmt.Errorf("scratch pad: checkpoint has %d registers, expected %d", len(c.Regs), len(r.regs))
+	}
+	return nil
+}
+
+// Restore puts the scratch pad back in a saved state
+func (r *Registers) Restore(c Checkpoint) error {
+	if err := r.CheckRestore(c); err != nil {
+		return err
+	}
+	for i := range r.regs {
+		r.regs[i].WriteDirect(c.Regs[i])
+	}
+	r.index = c.Index
+	r.bank = c.Bank
+	r.drivingBus = c.DrivingBus
+	return nil
+}
+
+// ClearDrivingBus is called at the start of each clock
+func (r *Registers) ClearDrivingBus() {
+	r.drivingBus = false
+}
+
 func (r *Registers) Read() {
 	r.regs[r.index].Read()
 	r.drivingBus = true
 }
 
 func (r *Registers) Select(index int) {
+	if r.bank != 0 && index < BankedRegisters {
+		index += len(r.regs) - BankedRegisters
+	}
 	if r.index != index {
 		rlog.Debugf("Selected ScratchPad Register %d", index)
 	}
@@ -55,6 +152,16 @@ func (r *Registers) Inc() {
 	r.regs[r.index].WriteDirect(value)
 }
 
+// GetIndex returns the selected register. Bank 1 of R0-R7 is at the end of the register file
+func (r *Registers) GetIndex() int {
+	return r.index
+}
+
+// ReadDirect returns the value of the selected register without using the bus
+func (r *Registers) ReadDirect() uint64 {
+	return r.regs[r.index].ReadDirect()
+}
+
 func (r *Registers) IsCurrentRegisterZero() bool {
 	return r.regs[r.index].ReadDirect() == 0
 }
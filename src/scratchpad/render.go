@@ -10,7 +10,6 @@ import (
 
 // Renderer contains all the rendering code of our cpu
 type Renderer struct {
-	regs      *Registers
 	dirty     int
 	bounds    image.Rectangle
 	busHeight int
@@ -21,9 +20,8 @@ type Renderer struct {
 	registerRenderers []common.RegisterRenderer
 }
 
-// InitRender Initializes the renderer
-func (r *Renderer) InitRender(regs *Registers, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.regs = regs
+// InitRender Initializes the renderer for a scratch pad of numRegs registers
+func (r *Renderer) InitRender(numRegs int, canvas *canvas.Canvas, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 
@@ -31,15 +29,15 @@ func (r *Renderer) InitRender(regs *Registers, canvas *canvas.Canvas, bounds ima
 	r.busHeight = 80
 	r.busWidth = 20
 
-	r.dataBusRenderer.InitRender(r.regs.dataBus,
+	r.dataBusRenderer.InitRender(
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Min.Y},
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Min.Y + r.busHeight},
 		r.busWidth)
 
-	r.registerRenderers = make([]common.RegisterRenderer, len(regs.regs))
+	r.registerRenderers = make([]common.RegisterRenderer, numRegs)
 
 	for i := range r.registerRenderers {
-		r.registerRenderers[i].InitRender(&r.regs.regs[i], image.Rectangle{
+		r.registerRenderers[i].InitRender(image.Rectangle{
 			image.Point{i%2*int(css.RegisterWidth) + r.bounds.Max.X - 2*int(css.RegisterWidth),
 				(i/2)*int(css.RegisterHeight) + r.bounds.Min.Y + r.busHeight},
 			image.Point{i%2*int(css.RegisterWidth) + r.bounds.Max.X - int(css.RegisterWidth),
@@ -48,13 +46,12 @@ func (r *Renderer) InitRender(regs *Registers, canvas *canvas.Canvas, bounds ima
 	}
 }
 
-// Render the contents to the screen
-func (r *Renderer) Render(canvas *canvas.Canvas) {
-	r.dataBusRenderer.DrivingBus = &r.regs.drivingBus
-	r.dataBusRenderer.Render(canvas)
+// Render the state to the screen. The data bus is the internal bus of the CPU
+func (r *Renderer) Render(canvas *canvas.Canvas, state State, dataBus common.BusState) {
+	r.dataBusRenderer.Render(canvas, dataBus, state.DrivingBus)
 
 	for i := range r.registerRenderers {
-		r.registerRenderers[i].Render(canvas)
+		r.registerRenderers[i].Render(canvas, state.Regs[i])
 	}
 
 	canvas.SetFillStyle("#000")
@@ -0,0 +1,96 @@
+package shift4003
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Chain is a set of 4003s sharing the clock and enable lines, with the serial
+// output of each chip connected to the data input of the next one
+type Chain struct {
+	Chips []Shift4003
+}
+
+// Init creates a chain of numChips 4003s. The data input goes to the first chip
+func (c *Chain) Init(name string, numChips int, clock Line, data Line, enable Line) {
+	c.Chips = make([]Shift4003, numChips)
+	for i := range c.Chips {
+		if i > 0 {
+			data = c.Chips[i-1].SerialOut()
+		}
+		c.Chips[i].Init(fmt.Sprintf("%s %d", name, i), clock, data, enable)
+	}
+}
+
+// SerialOut returns the serial output of the last chip
+func (c *Chain) SerialOut() Line {
+	return c.Chips[len(c.Chips)-1].SerialOut()
+}
+
+// GetOutputs returns the parallel outputs of all the chips. The outputs of the
+// first chip are the lowest 10 bits
+func (c *Chain) GetOutputs() (outputs uint64) {
+	for i := range c.Chips {
+		outputs |= c.Chips[i].GetOutputs() << (uint(i) * Width)
+	}
+	return
+}
+
+// MarshalCheckpoint returns the state of all the chips, for the board checkpoints
+func (c *Chain) MarshalCheckpoint() ([]byte, error) {
+	chips := make([]Checkpoint, len(c.Chips))
+	for i := range c.Chips {
+		chips[i] = c.Chips[i].Checkpoint()
+	}
+	return json.Marshal(chips)
+}
+
+// CheckCheckpoint returns the error UnmarshalCheckpoint would return, without
+// changing anything
+func (c *Chain) CheckCheckpoint(data []byte) error {
+	_, err := c.decodeCheckpoint(data)
+	return err
+}
+
+// UnmarshalCheckpoint restores the chips from a board checkpoint
+func (c *Chain) UnmarshalCheckpoint(data []byte) error {
+	chips, err := c.decodeCheckpoint(data)
+	if err != nil {
+		return err
+	}
+	for i := range c.Chips {
+		c.Chips[i].Restore(chips[i])
+	}
+	return nil
+}
+
+func (c *Chain) decodeCheckpoint(data []byte) ([]Checkpoint, error) {
+	var chips []Checkpoint
+	if err := json.Unmarshal(data, &chips); err != nil {
+		return nil, err
+	}
+	if len(chips) != len(c.Chips) {
+		return nil, fmt.Errorf("checkpoint has %d 4003s, the chain has %d", len(chips), len(c.Chips))
+	}
+	return chips, nil
+}
+
+func (c *Chain) Reset() {
+	for i := range c.Chips {
+		c.Chips[i].Reset()
+	}
+}
+
+// ClockIn samples the inputs of all the chips
+func (c *Chain) ClockIn() {
+	for i := range c.Chips {
+		c.Chips[i].ClockIn()
+	}
+}
+
+// ClockOut shifts all the chips which saw a clock edge
+func (c *Chain) ClockOut() {
+	for i := range c.Chips {
+		c.Chips[i].ClockOut()
+	}
+}
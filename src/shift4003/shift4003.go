@@ -0,0 +1,130 @@
+package shift4003
+
+import (
+	"common"
+	"interfaces"
+
+	"github.com/romana/rlog"
+)
+
+// Width is the number of parallel outputs
+const Width = 10
+
+// Line is a single logic input
+type Line interface {
+	Level() int
+}
+
+// PortLine is one bit of an I/O port, like the output port of a 4001 or 4002
+type PortLine struct {
+	Bus *common.Bus
+	Bit uint
+}
+
+// Level returns the level of the port bit
+func (l PortLine) Level() int {
+	return int((l.Bus.Read() >> l.Bit) & 0x1)
+}
+
+// serialOut is the serial output of a 4003, used to chain them
+type serialOut struct {
+	shift *Shift4003
+}
+
+func (l serialOut) Level() int {
+	return int((l.shift.reg.ReadDirect() >> (Width - 1)) & 0x1)
+}
+
+// Shift4003 is a model of the Intel 4003 10-bit serial-in, parallel-out shift
+// register. Data is shifted in to Q0 on the rising edge of the clock input,
+// and Q9 is the serial output. The parallel outputs are only active while the
+// enable input is high. The serial output is not affected by enable
+type Shift4003 struct {
+	interfaces.ClockedElement
+	Name   string
+	clock  Line
+	data   Line
+	enable Line // nil if always enabled
+	reg    common.Register
+
+	lastClock   int  // Clock level in the previous ClockIn
+	shift       bool // A rising edge was seen in ClockIn
+	dataLatched int  // Data input sampled with the clock edge
+}
+
+// Checkpoint is the saved state of a 4003
+type Checkpoint struct {
+	Reg         uint64
+	LastClock   int
+	Shift       bool
+	DataLatched int
+}
+
+// Init connects the inputs. enable can be nil to keep the outputs always enabled
+func (s *Shift4003) Init(name string, clock Line, data Line, enable Line) {
+	s.Name = name
+	s.clock = clock
+	s.data = data
+	s.enable = enable
+	s.reg.Init(nil, Width, name)
+	s.Reset()
+}
+
+func (s *Shift4003) Reset() {
+	s.reg.WriteDirect(0)
+	s.lastClock = s.clock.Level()
+	s.shift = false
+	s.dataLatched = 0
+}
+
+// Checkpoint returns the state of the chip, to restore it later
+func (s *Shift4003) Checkpoint() Checkpoint {
+	return Checkpoint{Reg: s.reg.ReadDirect(), LastClock: s.lastClock, Shift: s.shift, DataLatched: s.dataLatched}
+}
+
+// Restore puts the chip back in a saved state
+func (s *Shift4003) Restore(c Checkpoint) {
+	s.reg.WriteDirect(c.Reg)
+	s.lastClock = c.LastClock
+	s.shift = c.Shift
+	s.dataLatched = c.DataLatched
+}
+
+// SerialOut returns the Q9 output, to connect to the data input of the next 4003
+func (s *Shift4003) SerialOut() Line {
+	return serialOut{s}
+}
+
+// ReadDirect returns the contents of the shift register, ignoring enable
+func (s *Shift4003) ReadDirect() uint64 {
+	return s.reg.ReadDirect()
+}
+
+// GetOutputs returns the parallel outputs Q0..Q9. They are all low when not enabled
+func (s *Shift4003) GetOutputs() uint64 {
+	if s.enable != nil && s.enable.Level() == 0 {
+		return 0
+	}
+	return s.reg.ReadDirect()
+}
+
+// ClockIn samples the inputs. All the chips in a chain must sample their inputs
+// before any of them shift, since the data input can be the output of another chip
+func (s *Shift4003) ClockIn() {
+	clock := s.clock.Level()
+	s.shift = s.lastClock == 0 && clock != 0
+	s.lastClock = clock
+	if s.shift {
+		s.dataLatched = s.data.Level()
+	}
+}
+
+// ClockOut shifts the register if there was a clock edge
+func (s *Shift4003) ClockOut() {
+	if !s.shift {
+		return
+	}
+	s.shift = false
+	s.reg.WriteDirect(s.reg.ReadDirect()<<1 | uint64(s.dataLatched))
+	rlog.Tracef(0, "4003 %s: shifted in %d. Value=%03X", s.Name, s.dataLatched, s.reg.ReadDirect())
+}
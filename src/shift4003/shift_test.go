@@ -0,0 +1,165 @@
+package shift4003
+
+import (
+	"board"
+	"common"
+	"instruction"
+	"os"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "shift_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+const testDriver = common.NamedDriver("Test")
+
+// Port bits used by the tests
+const (
+	clockBit  = 0
+	dataBit   = 1
+	enableBit = 2
+)
+
+type clockedChip interface {
+	ClockIn()
+	ClockOut()
+}
+
+func createPort() *common.Bus {
+	port := &common.Bus{}
+	port.Init(4, "Test port")
+	writePort(port, 0)
+	return port
+}
+
+func writePort(port *common.Bus, value uint64) {
+	port.Reset()
+	port.Write(testDriver, value)
+}
+
+func tick(chip clockedChip) {
+	chip.ClockIn()
+	chip.ClockOut()
+}
+
+// shiftBit shifts one bit in with the clock low, then high
+func shiftBit(port *common.Bus, chip clockedChip, bit uint64, enable uint64) {
+	writePort(port, bit<<dataBit|enable<<enableBit)
+	tick(chip)
+	writePort(port, bit<<dataBit|enable<<enableBit|1<<clockBit)
+	tick(chip)
+}
+
+func createShift(port *common.Bus) *Shift4003 {
+	s := &Shift4003{}
+	s.Init("Test", PortLine{port, clockBit}, PortLine{port, dataBit}, PortLine{port, enableBit})
+	return s
+}
+
+func TestShift(t *testing.T) {
+	SetupLogger()
+	port := createPort()
+	s := createShift(port)
+	for _, bit := range []uint64{1, 0, 1, 1} {
+		shiftBit(port, s, bit, 1)
+	}
+	if s.GetOutputs() != 0xb {
+		t.Errorf("Output mismatch. Exp 00B, got %03X", s.GetOutputs())
+	}
+	// The first bit comes out of Q9 after 10 clocks
+	for i := 0; i < 6; i++ {
+		shiftBit(port, s, 0, 1)
+	}
+	if s.GetOutputs() != 0x2c0 || s.SerialOut().Level() != 1 {
+		t.Errorf("Output mismatch. Exp 2C0, got %03X. Serial out=%d", s.GetOutputs(), s.SerialOut().Level())
+	}
+	shiftBit(port, s, 0, 1)
+	if s.GetOutputs() != 0x180 || s.SerialOut().Level() != 0 {
+		t.Errorf("Output mismatch. Exp 180, got %03X. Serial out=%d", s.GetOutputs(), s.SerialOut().Level())
+	}
+}
+
+func TestShiftEdge(t *testing.T) {
+	SetupLogger()
+	port := createPort()
+	s := createShift(port)
+	shiftBit(port, s, 1, 1)
+	// Holding the clock high, or the falling edge, does not shift
+	tick(s)
+	writePort(port, 1<<dataBit|1<<enableBit)
+	tick(s)
+	tick(s)
+	if s.GetOutputs() != 0x1 {
+		t.Errorf("Output mismatch. Exp 001, got %03X", s.GetOutputs())
+	}
+}
+
+func TestEnable(t *testing.T) {
+	SetupLogger()
+	port := createPort()
+	s := createShift(port)
+	// Shifting works with the outputs disabled
+	shiftBit(port, s, 1, 0)
+	shiftBit(port, s, 1, 0)
+	if s.GetOutputs() != 0 || s.ReadDirect() != 0x3 {
+		t.Errorf("Disabled output mismatch. Exp 000/003, got %03X/%03X", s.GetOutputs(), s.ReadDirect())
+	}
+	writePort(port, 1<<enableBit)
+	if s.GetOutputs() != 0x3 {
+		t.Errorf("Enabled output mismatch. Exp 003, got %03X", s.GetOutputs())
+	}
+}
+
+func TestChain(t *testing.T) {
+	SetupLogger()
+	port := createPort()
+	c := &Chain{}
+	c.Init("Chain", 2, PortLine{port, clockBit}, PortLine{port, dataBit}, nil)
+	pattern := uint64(0xABCDE)
+	for i := 2*Width - 1; i >= 0; i-- {
+		shiftBit(port, c, (pattern>>uint(i))&0x1, 0)
+	}
+	if c.GetOutputs() != pattern {
+		t.Errorf("Chain output mismatch. Exp %05X, got %05X", pattern, c.GetOutputs())
+	}
+	if c.SerialOut().Level() != 1 {
+		t.Error("Chain serial output mismatch")
+	}
+}
+
+// TestRomPort shifts bits in from a program writing the I/O port of a 4001 with WRR
+func TestRomPort(t *testing.T) {
+	SetupLogger()
+	config := board.DefaultConfig()
+	config.NumRoms = 1
+	config.NumRams = 0
+	b := board.Board{}
+	b.Init(config)
+	port := &b.Roms.IOBuses[0]
+	writePort(port, 0)
+	s := createShift(port)
+	b.AddPeripheral("Shift", s)
+
+	program := []uint8{instruction.SRC | 2} // R2,R3 = 0. Select ROM 0
+	for _, bit := range []uint8{1, 1, 0, 1} {
+		data := bit<<dataBit | 1<<enableBit
+		program = append(program, instruction.LDM|data, instruction.WRR,
+			instruction.LDM|data|1<<clockBit, instruction.WRR)
+	}
+	loop := uint8(len(program))
+	program = append(program, instruction.JUN, loop)
+	b.LoadProgram(program)
+
+	b.Step(8 * (len(program) + 4))
+	if s.GetOutputs() != 0xd {
+		t.Errorf("Output mismatch. Exp 00D, got %03X", s.GetOutputs())
+	}
+}
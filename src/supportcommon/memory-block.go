@@ -0,0 +1,64 @@
+package supportcommon
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+)
+
+// PageSize is how many bytes one page (4-bit chip select) of program memory holds
+const PageSize = 256
+
+// MaxPages is how many pages fit in the 12-bit address space
+const MaxPages = 16
+
+// MemoryBlock is a generic byte-wide ROM or RAM, like the ones connected to the
+// 4004 through a 4008/4009 pair
+type MemoryBlock struct {
+	Name     string
+	data     []uint8
+	writable bool
+}
+
+// Init creates a memory block of size bytes. The size must be a whole number of pages
+func (m *MemoryBlock) Init(size int, writable bool, name string) {
+	if size <= 0 || size%PageSize != 0 || size > MaxPages*PageSize {
+		panic(fmt.Sprintf("MemoryBlock %s: invalid size %d", name, size))
+	}
+	m.Name = name
+	m.data = make([]uint8, size)
+	m.writable = writable
+}
+
+// Load copies an image into the memory block, starting at address 0
+func (m *MemoryBlock) Load(data []uint8) error {
+	if len(data) > len(m.data) {
+		return fmt.Errorf("MemoryBlock %s: image is %d bytes, but the block only holds %d bytes",
+			m.Name, len(data), len(m.data))
+	}
+	copy(m.data, data)
+	return nil
+}
+
+// Size returns the size in bytes
+func (m *MemoryBlock) Size() int {
+	return len(m.data)
+}
+
+// IsWritable returns true for RAM
+func (m *MemoryBlock) IsWritable() bool {
+	return m.writable
+}
+
+func (m *MemoryBlock) Read(addr uint64) uint8 {
+	return m.data[addr]
+}
+
+// Write writes a byte if the memory is writable. Writes to ROM are ignored
+func (m *MemoryBlock) Write(addr uint64, value uint8) {
+	if !m.writable {
+		rlog.Warnf("MemoryBlock %s: write to ROM at %03X ignored", m.Name, addr)
+		return
+	}
+	m.data[addr] = value
+}
@@ -2,55 +2,113 @@ package supportcommon
 
 import (
 	"common"
+	"events"
+	"fmt"
 	"instruction"
 	"interfaces"
 
 	"github.com/romana/rlog"
 )
 
+// Chip types supported by RamRom
+const (
+	ChipTypeRom    = iota // 4001 style ROM with a 4-bit I/O port
+	ChipTypeRam           // 4002 style RAM with a 4-bit output port
+	ChipTypeStdMem        // 4008/4009 pair in front of a generic memory block
+)
+
+// 4002 RAM organization
+const RamRegisters = 4        // Number of registers per RAM chip
+const RamCharacters = 16      // Number of main memory characters per register
+const RamStatusCharacters = 4 // Number of status characters per register
+
+// RamResetCycles is how many instruction cycles RESET must be held to clear the 4002 memory
+const RamResetCycles = 32
+
 // Common support code for RAM/ROM/etc
 type RamRom struct {
 	interfaces.ClockedElement
-	data           []uint8           // Data array
-	chipID         int               // Hard-coded in metal Rom ID or RAM
-	busExt         *common.Bus       // External Data Bus for address/data
-	busInt         *common.Bus       // Internal Data Bus for address/data
-	busBuf         common.Buffer     // Bus i/o buffer
-	cm             *int              // CM-ROM/RAM select from CPU
-	sync           *int              // SYNC signal from CPU
-	syncLatched    int               // SYNC latched with clock
-	syncSeen       bool              // Have we seen the sync flag?
-	clockCount     int               // Internal counter for clock timing
-	instPhase      int               // Instruction phase
-	addressReg     common.Register   // Address shift register
-	instReg        common.Register   // Instruction shift register
-	outputReg      common.Register   // Output data register
-	chipSelected   bool              // We are targeted for this read
-	valueRegisters []common.Register // The values near the current address
-	ioBus          *common.Bus       // Input/Output bus for general purpose IO
-	srcDetected    bool              // SRC command was detected
-	srcRomID       uint64            // The ROM ID sent in the SRC command
-	ioOpDetected   bool              // IO Operation was detected
-	drivingBus     bool              // The ROM is driving the external bus
-}
-
-func (r *RamRom) Init(busExt *common.Bus, busInt *common.Bus, sync *int, cm *int, busWidth int, memDepth int) {
+	chipType      int                // ROM or RAM
+	data          []uint8            // Data array (RAM main memory for RAM chips)
+	statusData    []uint8            // RAM status characters
+	chipID        int                // Hard-coded in metal Rom ID or RAM
+	busExt        *common.Bus        // External Data Bus for address/data
+	busInt        *common.Bus        // Internal Data Bus for address/data
+	busBuf        common.Buffer      // Bus i/o buffer
+	cm            *common.Signal     // CM-ROM/RAM select from CPU
+	sync          *common.Signal     // SYNC signal from CPU
+	reset         *common.Signal     // RESET input (active high)
+	resetClocks   int                // How many clocks RESET has been held
+	syncLatched   int                // SYNC latched with clock
+	syncSeen      bool               // Have we seen the sync flag?
+	clockCount    int                // Internal counter for clock timing
+	instPhase     int                // Instruction phase
+	addressReg    common.Register    // Address shift register
+	instReg       common.Register    // Instruction shift register
+	outputReg     common.Register    // Output data register
+	chipSelected  bool               // We are targeted for this read
+	ioBus         *common.Bus        // Input/Output bus for general purpose IO
+	ioOutputs     uint64             // Mask option: I/O lines which are outputs (ROM only)
+	ioInverted    uint64             // Mask option: I/O lines which are inverted (ROM only)
+	dataCycle     bool               // The current fetch is the second cycle of a two cycle instruction
+	srcDetected   bool               // SRC command was detected
+	srcSelected   bool               // The last SRC command selected this chip
+	srcAddressReg common.Register    // The address sent in the last SRC command
+	ioOpDetected  bool               // IO Operation was detected
+	drivingBus    bool               // The chip is driving the external bus during this clock
+	events        *events.Dispatcher // SRC and I/O port events. May be nil
+
+	// 4008/4009 only
+	memory   *MemoryBlock  // The memory behind the 4008/4009 pair
+	basePage uint64        // The page of the first byte of the memory
+	ports    []*common.Bus // I/O ports selected by the upper 4 bits of the SRC address
+	pagePort int           // I/O port which holds the page for WPM/RPM (-1 if none)
+	pmPage   uint64        // Page for WPM/RPM
+	pmToggle bool          // WPM/RPM toggle. Set after the upper 4 bits were transferred
+	pmUpper  uint8         // Upper 4 bits written by the first WPM
+}
+
+func (r *RamRom) Init(busExt *common.Bus, busInt *common.Bus, sync *common.Signal, cm *common.Signal, busWidth int, memDepth int) {
 	r.busExt = busExt
 	r.busInt = busInt
 	r.sync = sync
 	r.cm = cm
 	r.addressReg.Init(nil, 12, "Addr = ")
 	r.instReg.Init(nil, 8, "Inst = ")
+	r.srcAddressReg.Init(nil, 8, "SRC = ")
 	r.outputReg.Init(r.busInt, busWidth, "")
 	r.busBuf.Init(r.busInt, r.busExt, busWidth, "I/O BUF")
+	r.busBuf.Owner = r
+	r.outputReg.Owner = r
 	r.data = make([]uint8, memDepth)
-	r.valueRegisters = make([]common.Register, 3)
-	for i := range r.valueRegisters {
-		r.valueRegisters[i].Init(nil, 8, "      ")
+	r.chipID = 0
+	r.chipType = ChipTypeRom
+	r.ioOutputs = 0xf
+	r.ioInverted = 0
+}
+
+// SetChipType selects whether we behave like a ROM or a RAM
+func (r *RamRom) SetChipType(chipType int) {
+	r.chipType = chipType
+	if r.chipType == ChipTypeRam {
+		r.statusData = make([]uint8, RamRegisters*RamStatusCharacters)
+	} else {
+		r.statusData = nil
 	}
+	if r.chipType == ChipTypeStdMem {
+		r.ports = make([]*common.Bus, MaxPages)
+		r.pagePort = -1
+	}
+}
 
-	r.calculateValueRegisters()
-	r.chipID = 0
+// GetChipType returns ChipTypeRom or ChipTypeRam
+func (r *RamRom) GetChipType() int {
+	return r.chipType
+}
+
+// SetResetLine connects the RESET input. It is usually shared with the CPU
+func (r *RamRom) SetResetLine(reset *common.Signal) {
+	r.reset = reset
 }
 
 func (r *RamRom) SetIOBus(bus *common.Bus) {
@@ -59,43 +117,275 @@ func (r *RamRom) SetIOBus(bus *common.Bus) {
 
 func (r *RamRom) LoadProgram(data []uint8) {
 	copy(r.data, data)
-	r.calculateValueRegisters()
 }
 
 func (r *RamRom) SetChipID(id int) {
 	r.chipID = id
 }
 
-func (r *RamRom) calculateValueRegisters() {
-	curr := r.addressReg.ReadDirect() & 0xff
+// DriverName returns the chip type and ID, to identify the chip in bus collision reports
+func (r *RamRom) DriverName() string {
+	return fmt.Sprintf("%s #%d", r.typeName(), r.chipID)
+}
 
-	var first uint64
-	r.valueRegisters[0].Selected = false
-	r.valueRegisters[1].Selected = false
-	r.valueRegisters[2].Selected = false
+// SetBusMonitor attaches a monitor to the internal bus
+func (r *RamRom) SetBusMonitor(m *common.BusMonitor) {
+	r.busInt.SetMonitor(m)
+}
+
+// SetEvents raises the SRC and I/O port events, and the internal bus events,
+// through the dispatcher
+func (r *RamRom) SetEvents(d *events.Dispatcher) {
+	r.events = d
+	r.busInt.SetEvents(d)
+}
+
+func (r *RamRom) GetChipID() int {
+	return r.chipID
+}
+
+// SetIOMask sets the metal mask options of the ROM I/O port. Lines set in outputs
+// are driven by WRR, the others are inputs. Lines set in inverted are inverted
+// in both directions. By default all the lines are non-inverted outputs
+func (r *RamRom) SetIOMask(outputs uint64, inverted uint64) {
+	r.ioOutputs = outputs & 0xf
+	r.ioInverted = inverted & 0xf
+}
 
+// IOOutputs returns the lines of the ROM I/O port which are driven by WRR
+func (r *RamRom) IOOutputs() uint64 {
+	return r.ioOutputs
+}
+
+// IOInverted returns the inverted lines of the ROM I/O port
+func (r *RamRom) IOInverted() uint64 {
+	return r.ioInverted
+}
+
+// writeIOPort drives the output lines of the ROM I/O port. Input lines are left alone
+func (r *RamRom) writeIOPort(value uint64) {
+	value = ((value ^ r.ioInverted) & r.ioOutputs) | (r.ioBus.Read() &^ r.ioOutputs)
+	r.writePort(r.ioBus, value)
+}
+
+// writePort drives an output port, and raises an I/O port event if it changed
+func (r *RamRom) writePort(port *common.Bus, value uint64) {
+	changed := port.Value() != value
+	port.Reset()
+	port.Write(r, value)
+	if changed && r.events.Wants(events.KindIOPort) {
+		r.events.Raise(events.IOPort{Chip: r.DriverName(), Port: port.Name, Value: value})
+	}
+}
+
+// State is a snapshot of a RAM or ROM chip
+type State struct {
+	Name       string // Like "ROM 0"
+	Address    common.RegisterState
+	Values     [3]common.RegisterState // The memory around the current address
+	Buffer     common.BufferState      // The buffer between the internal and external buses
+	Bus        common.BusState         // The internal bus
+	DrivingBus bool
+}
+
+// State returns a snapshot of the chip
+func (r *RamRom) State() State {
+	s := State{
+		Name:       fmt.Sprintf("%s %X", r.typeName(), r.chipID),
+		Address:    r.addressReg.State(),
+		Buffer:     r.busBuf.State(),
+		Bus:        r.busInt.State(),
+		DrivingBus: r.drivingBus,
+	}
+	curr := r.fetchIndex()
+	if r.chipType == ChipTypeRam {
+		// Show the main memory characters around the last SRC address
+		curr = r.srcAddressReg.ReadDirect() & 0x3f
+	}
+
+	var first uint64
 	switch curr {
 	case 0:
 		first = 0
-		r.valueRegisters[0].Selected = r.chipSelected
 	case uint64(len(r.data) - 1):
 		first = curr - 2
-		r.valueRegisters[2].Selected = r.chipSelected
 	default:
 		first = curr - 1
-		r.valueRegisters[1].Selected = r.chipSelected
 	}
-	r.valueRegisters[0].WriteDirect(uint64(r.data[first]))
-	r.valueRegisters[1].WriteDirect(uint64(r.data[first+1]))
-	r.valueRegisters[2].WriteDirect(uint64(r.data[first+2]))
+	for i := range s.Values {
+		s.Values[i] = common.RegisterState{
+			Name:     "      ",
+			Value:    uint64(r.data[first+uint64(i)]),
+			Selected: r.chipSelected && first+uint64(i) == curr,
+		}
+	}
+	return s
+}
+
+// Checkpoint is the saved state of a RAM or ROM chip. The I/O ports are saved
+// by their owner
+type Checkpoint struct {
+	Data          []uint8 // ROM contents, RAM main memory or the 4008/4009 memory
+	Status        []uint8 // RAM status characters
+	ResetClocks   int
+	SyncLatched   int
+	SyncSeen      bool
+	ClockCount    int
+	InstPhase     int
+	Address       uint64
+	Inst          uint64
+	Output        uint64
+	SrcAddress    uint64
+	ChipSelected  bool
+	DataCycle     bool
+	SrcDetected   bool
+	SrcSelected   bool
+	IOOpDetected  bool
+	DrivingBus    bool
+	BufferDir     int
+	InternalBus   common.BusCheckpoint
+	ProgramPage   uint64 // WPM/RPM page (4008/4009 only)
+	ProgramToggle bool
+	ProgramUpper  uint8
+}
+
+// Checkpoint returns the state of the chip, to restore it later
+func (r *RamRom) Checkpoint() Checkpoint {
+	return Checkpoint{
+		Data:          append([]uint8(nil), r.data...),
+		Status:        append([]uint8(nil), r.statusData...),
+		ResetClocks:   r.resetClocks,
+		SyncLatched:   r.syncLatched,
+		SyncSeen:      r.syncSeen,
+		ClockCount:    r.clockCount,
+		InstPhase:     r.instPhase,
+		Address:       r.addressReg.ReadDirect(),
+		Inst:          r.instReg.ReadDirect(),
+		Output:        r.outputReg.ReadDirect(),
+		SrcAddress:    r.srcAddressReg.ReadDirect(),
+		ChipSelected:  r.chipSelected,
+		DataCycle:     r.dataCycle,
+		SrcDetected:   r.srcDetected,
+		SrcSelected:   r.srcSelected,
+		IOOpDetected:  r.ioOpDetected,
+		DrivingBus:    r.drivingBus,
+		BufferDir:     r.busBuf.Dir,
+		InternalBus:   r.busInt.Checkpoint(),
+		ProgramPage:   r.pmPage,
+		ProgramToggle: r.pmToggle,
+		ProgramUpper:  r.pmUpper,
+	}
+}
+
+// CheckRestore returns the error Restore would return, without changing anything
+func (r *RamRom) CheckRestore(c Checkpoint) error {
+	if len(c.Data) != len(r.data) || len(c.Status) != len(r.statusData) {
+		return fmt.Errorf("%s: checkpoint has %d bytes of memory and %d status characters, expected %d and %d",
+			r.DriverName(), len(c.Data), len(c.Status), len(r.data), len(r.statusData))
+	}
+	return nil
+}
+
+// Restore puts the chip back in a saved state. The memory sizes must be the same
+func (r *RamRom) Restore(c Checkpoint) error {
+	if err := r.CheckRestore(c); err != nil {
+		return err
+	}
+	copy(r.data, c.Data)
+	copy(r.statusData, c.Status)
+	r.resetClocks = c.ResetClocks
+	r.syncLatched = c.SyncLatched
+	r.syncSeen = c.SyncSeen
+	r.clockCount = c.ClockCount
+	r.instPhase = c.InstPhase
+	r.addressReg.WriteDirect(c.Address)
+	r.instReg.WriteDirect(c.Inst)
+	r.outputReg.WriteDirect(c.Output)
+	r.srcAddressReg.WriteDirect(c.SrcAddress)
+	r.chipSelected = c.ChipSelected
+	r.dataCycle = c.DataCycle
+	r.srcDetected = c.SrcDetected
+	r.srcSelected = c.SrcSelected
+	r.ioOpDetected = c.IOOpDetected
+	r.drivingBus = c.DrivingBus
+	r.busBuf.Dir = c.BufferDir
+	r.busInt.Restore(c.InternalBus)
+	r.pmPage = c.ProgramPage
+	r.pmToggle = c.ProgramToggle
+	r.pmUpper = c.ProgramUpper
+	return nil
 }
 
 func (r *RamRom) GetClockCount() int {
 	return r.clockCount
 }
 
+// Reset is a power-on reset. Everything is cleared right away, as if RESET
+// had been held long enough
 func (r *RamRom) Reset() {
+	r.clearLatches()
+	r.clearPort()
+	if r.chipType == ChipTypeRam {
+		r.clearMemory()
+	}
+	r.syncSeen = false
+	r.resetClocks = 0
+}
+
+// clockReset clocks the chip while RESET is held. The latches and the I/O port
+// are cleared right away, but the 4002 memory is only cleared after RESET has
+// been held for RamResetCycles instruction cycles
+func (r *RamRom) clockReset() {
+	if r.resetClocks == 0 {
+		rlog.Debugf("%s %d: RESET", r.typeName(), r.chipID)
+		r.clearPort()
+	}
+	r.clearLatches()
+	// Wait for the next instruction cycle after RESET is released
+	r.syncSeen = false
+	r.resetClocks++
+	if r.chipType == ChipTypeRam && r.resetClocks == RamResetCycles*8 {
+		rlog.Debugf("RAM %d: Memory cleared", r.chipID)
+		r.clearMemory()
+	}
+}
+
+func (r *RamRom) clearLatches() {
 	r.clockCount = 0
+	r.addressReg.WriteDirect(0)
+	r.instReg.WriteDirect(0)
+	r.srcAddressReg.WriteDirect(0)
+	r.chipSelected = false
+	r.dataCycle = false
+	r.srcDetected = false
+	r.srcSelected = false
+	r.ioOpDetected = false
+	r.pmToggle = false
+}
+
+func (r *RamRom) clearPort() {
+	if r.ioBus != nil {
+		if r.chipType == ChipTypeRom {
+			r.writeIOPort(0)
+		} else {
+			r.writePort(r.ioBus, 0)
+		}
+	}
+	for _, port := range r.ports {
+		if port != nil {
+			r.writePort(port, 0)
+		}
+	}
+	r.pmPage = 0
+}
+
+func (r *RamRom) clearMemory() {
+	for i := range r.data {
+		r.data[i] = 0
+	}
+	for i := range r.statusData {
+		r.statusData[i] = 0
+	}
 }
 
 func (r *RamRom) Calculate() {
@@ -108,9 +398,17 @@ func (r *RamRom) ClockIn() {
 }
 
 func (r *RamRom) updateInternal() {
-	r.syncLatched = *r.sync
-	r.syncSeen = r.syncSeen || (r.syncLatched == 0)
+	r.syncLatched = r.sync.Level()
+	if r.reset != nil && r.reset.Level() != 0 {
+		r.clockReset()
+		return
+	}
+	r.resetClocks = 0
+	// φ1 and φ2 are separate bus cycles for collision detection
+	r.busInt.Reset()
 	if !r.syncSeen {
+		// Wait for SYNC before taking part in any instruction cycles
+		r.syncSeen = r.syncLatched == 0
 		return
 	}
 
@@ -131,7 +429,7 @@ func (r *RamRom) updateInternal() {
 		r.addressReg.WriteDirect(r.addressReg.ReadDirect() | (r.busInt.Read() << (uint(r.clockCount) * 4)))
 		rlog.Tracef(0, "ROM %d: Wrote address register (n2). Curr value=%03X", r.chipID, r.addressReg.ReadDirect())
 		romID := (r.addressReg.ReadDirect() >> 8) & 0xf
-		r.chipSelected = (romID == uint64(r.chipID)) && (*(r.cm) == 0)
+		r.chipSelected = r.isFetchForUs(romID) && (r.cm.Level() == 0)
 		if r.chipSelected {
 			rlog.Tracef(0, "ROM %d: Selected for read access", r.chipID)
 		}
@@ -141,68 +439,190 @@ func (r *RamRom) updateInternal() {
 		if !r.chipSelected {
 			r.busBuf.BtoA()
 		}
-		// Check for IO ops before we update the SRC flag
-		if r.busInt.Read() == (instruction.WRR >> 4) {
-			if r.srcDetected {
-				rlog.Debug("ROM: IO instruction detected")
-				r.ioOpDetected = true
-			} else {
-				r.ioOpDetected = false
-			}
-		} else {
-			r.ioOpDetected = false
-		}
-
-		// NOTE: FIM and SRC have the same upper 4 bits
-		// We won't know which instruction it is until the next cycle
-		if r.busInt.Read() == (instruction.SRC >> 4) {
-			rlog.Debug("ROM: FIM/SRC instruction detected")
-			r.srcDetected = true
-		} else {
-			r.srcDetected = false
-		}
+		// Store the upper 4 bits of the instruction
+		r.instReg.WriteDirect(r.busInt.Read() << 4)
 	case 4:
 		// Copy from the external bus to the internal bus
 		// if we are not writing the bus
 		if !r.chipSelected {
 			r.busBuf.BtoA()
 		}
-		if r.srcDetected {
-			if (r.busInt.Read() & 0x1) == 0 {
-				rlog.Debug("ROM: FIM instruction verified")
-			} else {
-				rlog.Debug("ROM: SRC instruction verified")
-			}
-		}
-		r.instReg.WriteDirect(r.busInt.Read())
+		// Store the lower 4 bits of the instruction
+		r.instReg.WriteDirect(r.instReg.ReadDirect() | r.busInt.Read())
+		r.decodeInstruction()
 	case 6:
 		if r.srcDetected {
 			// Copy the data to the inernal bus
 			r.busBuf.BtoA()
-			r.srcRomID = r.busInt.Read() & 0xf
-			if int(r.srcRomID) != r.chipID {
-				rlog.Debugf("ROM: SRC command was NOT for us. Our chipID=%02X, cmd chipID=%02X",
-					r.chipID, r.srcRomID)
-				r.srcDetected = false
+			// The X2 cycle contains the upper 4 bits of the SRC address
+			r.srcSelected = r.isSrcForUs(r.busInt.Read() & 0xf)
+			if !r.srcSelected {
+				rlog.Debugf("%s: SRC command was NOT for us. Our chipID=%02X, cmd address=%X",
+					r.typeName(), r.chipID, r.busInt.Read())
 			} else {
-				rlog.Debugf("ROM: SRC command WAS for us. Our chipID=%02X",
-					r.chipID)
+				rlog.Debugf("%s: SRC command WAS for us. Our chipID=%02X",
+					r.typeName(), r.chipID)
+				r.srcAddressReg.WriteDirect(r.busInt.Read() << 4)
 			}
 		}
 		if r.ioOpDetected {
 			// Copy the data to the internal bus
 			r.busBuf.BtoA()
-			cmd := r.instReg.ReadDirect()
-			switch cmd {
-			case instruction.WRR & 0xf:
-				// IO Write
-				r.ioBus.Reset()
-				r.ioBus.Write(r.busInt.Read())
+			r.executeIOWrite(r.busInt.Read())
+		}
+	case 7:
+		if r.srcDetected && r.srcSelected {
+			// Copy the data to the inernal bus
+			r.busBuf.BtoA()
+			// The X3 cycle contains the lower 4 bits of the SRC address
+			r.srcAddressReg.WriteDirect(r.srcAddressReg.ReadDirect() | r.busInt.Read())
+			rlog.Debugf("%s: SRC address latched=%02X", r.typeName(), r.srcAddressReg.ReadDirect())
+			if r.events.Wants(events.KindSrc) {
+				r.events.Raise(events.SrcLatch{Chip: r.DriverName(), Address: r.srcAddressReg.ReadDirect()})
 			}
 		}
 	}
 }
 
+// decodeInstruction looks at the instruction the CPU just fetched to see if we
+// need to take part in the execution cycles
+func (r *RamRom) decodeInstruction() {
+	inst := r.instReg.ReadDirect()
+	r.srcDetected = false
+	r.ioOpDetected = false
+	if r.dataCycle {
+		// This is the second byte of a two cycle instruction, and NOT an opcode
+		r.dataCycle = false
+		return
+	}
+	if (inst & 0xf1) == instruction.SRC {
+		rlog.Debugf("%s: SRC instruction detected", r.typeName())
+		r.srcDetected = true
+	} else if (inst & 0xf0) == instruction.IO {
+		// The I/O instructions use the chip selected by the last SRC
+		if r.srcSelected {
+			rlog.Debugf("%s: IO instruction %02X detected", r.typeName(), inst)
+			r.ioOpDetected = true
+		}
+	} else if inst == instruction.RPM && r.chipType == ChipTypeStdMem {
+		// The 4040 reads program memory through the 4009
+		rlog.Debugf("%s: RPM instruction detected", r.typeName())
+		r.ioOpDetected = true
+	} else {
+		r.dataCycle = instruction.IsTwoCycleInstruction(inst)
+	}
+}
+
+// isFetchForUs checks the chip select (upper 4 bits) of the instruction address
+func (r *RamRom) isFetchForUs(romID uint64) bool {
+	switch r.chipType {
+	case ChipTypeRom:
+		return romID == uint64(r.chipID)
+	case ChipTypeStdMem:
+		return r.memory != nil && romID >= r.basePage &&
+			romID < r.basePage+uint64(r.memory.Size()/PageSize)
+	}
+	return false
+}
+
+// fetchIndex returns the index into our data of the instruction address
+func (r *RamRom) fetchIndex() uint64 {
+	addr := r.addressReg.ReadDirect()
+	if r.chipType == ChipTypeStdMem {
+		if !r.isFetchForUs((addr >> 8) & 0xf) {
+			return 0
+		}
+		return addr - r.basePage*PageSize
+	}
+	return addr & 0xff
+}
+
+// isSrcForUs checks the upper nybble of the SRC address against our chip ID
+func (r *RamRom) isSrcForUs(value uint64) bool {
+	if r.chipType == ChipTypeStdMem {
+		// The 4008 latches every SRC address. The upper 4 bits select the I/O port
+		return true
+	}
+	if r.chipType == ChipTypeRam {
+		// The upper 2 bits select the chip. The bank is selected by our CM-RAM line
+		return (int(value>>2) == r.chipID) && (r.cm.Level() == 0)
+	}
+	return int(value) == r.chipID
+}
+
+// executeIOWrite executes the I/O instructions which write to the chip
+func (r *RamRom) executeIOWrite(value uint64) {
+	cmd := r.instReg.ReadDirect()
+	if r.chipType == ChipTypeStdMem {
+		r.executeStdMemWrite(cmd, value)
+		return
+	}
+	if r.chipType == ChipTypeRom {
+		switch cmd {
+		case instruction.WRR:
+			// IO Write
+			r.writeIOPort(value)
+		}
+		return
+	}
+	switch cmd {
+	case instruction.WRM:
+		r.data[r.ramCharacterIndex()] = uint8(value & 0xf)
+	case instruction.WMP:
+		// Output port write
+		r.writePort(r.ioBus, value)
+	case instruction.WR0, instruction.WR1, instruction.WR2, instruction.WR3:
+		r.statusData[r.ramStatusIndex(cmd-instruction.WR0)] = uint8(value & 0xf)
+	}
+}
+
+// readIO returns the value to drive for the I/O instructions which read from the chip
+func (r *RamRom) readIO() (value uint64, ok bool) {
+	cmd := r.instReg.ReadDirect()
+	if r.chipType == ChipTypeStdMem {
+		return r.readStdMem(cmd)
+	}
+	if r.chipType == ChipTypeRom {
+		switch cmd {
+		case instruction.RDR:
+			// IO Read
+			return (r.ioBus.Read() ^ r.ioInverted) & 0xf, true
+		}
+		return 0, false
+	}
+	switch cmd {
+	case instruction.SBM, instruction.RDM, instruction.ADM:
+		return uint64(r.data[r.ramCharacterIndex()]), true
+	case instruction.RD0, instruction.RD1, instruction.RD2, instruction.RD3:
+		return uint64(r.statusData[r.ramStatusIndex(cmd-instruction.RD0)]), true
+	}
+	return 0, false
+}
+
+// ramCharacterIndex returns the main memory index of the character selected by SRC
+func (r *RamRom) ramCharacterIndex() int {
+	addr := r.srcAddressReg.ReadDirect()
+	reg := int(addr>>4) & (RamRegisters - 1)
+	char := int(addr) & (RamCharacters - 1)
+	return reg*RamCharacters + char
+}
+
+// ramStatusIndex returns the index of a status character in the register selected by SRC
+func (r *RamRom) ramStatusIndex(char uint64) int {
+	reg := int(r.srcAddressReg.ReadDirect()>>4) & (RamRegisters - 1)
+	return reg*RamStatusCharacters + int(char)
+}
+
+func (r *RamRom) typeName() string {
+	if r.chipType == ChipTypeRam {
+		return "RAM"
+	}
+	if r.chipType == ChipTypeStdMem {
+		return "4008"
+	}
+	return "ROM"
+}
+
 // ClockOut clock external outputs to their respective busses/logic lines
 func (r *RamRom) ClockOut() {
 	r.busInt.Reset()
@@ -230,9 +650,8 @@ func (r *RamRom) ClockOut() {
 		// r.busExt.Reset()
 		fallthrough
 	case 3:
-		addr := r.addressReg.ReadDirect() & 0xff
+		addr := r.fetchIndex()
 
-		r.calculateValueRegisters()
 		if r.chipSelected {
 			// Write to the external bus from the internal bus
 			data := uint64(r.data[addr])
@@ -240,7 +659,7 @@ func (r *RamRom) ClockOut() {
 				data = data >> 4
 			}
 			// rlog.Debugf("ROM %d: writing %X to bus", r.chipID, data&0xf)
-			r.busInt.Write(data & 0xf)
+			r.busInt.Write(r, data&0xf)
 			r.busBuf.AtoB()
 			r.drivingBus = true
 		} else {
@@ -249,11 +668,8 @@ func (r *RamRom) ClockOut() {
 		}
 	case 5:
 		if r.ioOpDetected {
-			cmd := r.instReg.ReadDirect()
-			switch cmd {
-			case instruction.RDR & 0xf:
-				// IO Read
-				r.busInt.Write(r.ioBus.Read() & 0xf)
+			if value, ok := r.readIO(); ok {
+				r.busInt.Write(r, value)
 				r.busBuf.AtoB()
 				r.drivingBus = true
 			}
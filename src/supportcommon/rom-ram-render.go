@@ -3,7 +3,6 @@ package supportcommon
 import (
 	"common"
 	"css"
-	"fmt"
 	"image"
 
 	"github.com/tfriedel6/canvas"
@@ -11,7 +10,6 @@ import (
 
 // RamRomRenderer contains all the rendering code of our cpu
 type RamRomRenderer struct {
-	core      *RamRom
 	dirty     int
 	bounds    image.Rectangle
 	busHeight int
@@ -25,8 +23,7 @@ type RamRomRenderer struct {
 }
 
 // InitRender Initializes the renderer
-func (r *RamRomRenderer) InitRender(core *RamRom, canvas *canvas.Canvas, bounds image.Rectangle) {
-	r.core = core
+func (r *RamRomRenderer) InitRender(canvas *canvas.Canvas, bounds image.Rectangle) {
 	// Bounds will only contain the top left corner. We will compute the size in here
 	r.bounds = bounds
 	r.dirty = 2
@@ -37,13 +34,13 @@ func (r *RamRomRenderer) InitRender(core *RamRom, canvas *canvas.Canvas, bounds
 
 	r.bounds.Max = r.bounds.Min.Add(image.Point{int(css.RegisterWidth * 2), r.busHeight + 5*int(css.RegisterHeight)})
 
-	r.addrRenderer.InitRender(&r.core.addressReg, image.Rectangle{
+	r.addrRenderer.InitRender(image.Rectangle{
 		image.Point{r.bounds.Min.X, r.bounds.Min.Y},
 		image.Point{r.bounds.Max.X, r.bounds.Min.Y + int(css.RegisterHeight)}})
 
 	r.dataRenderers = make([]common.RegisterRenderer, 3)
 	for i := range r.dataRenderers {
-		r.dataRenderers[i].InitRender(&r.core.valueRegisters[i], image.Rectangle{
+		r.dataRenderers[i].InitRender(image.Rectangle{
 			image.Point{r.bounds.Min.X, (i+1)*int(css.RegisterHeight) + r.bounds.Min.Y},
 			image.Point{r.bounds.Max.X,
 				(i+2)*int(css.RegisterHeight) + r.bounds.Min.Y},
@@ -51,11 +48,11 @@ func (r *RamRomRenderer) InitRender(core *RamRom, canvas *canvas.Canvas, bounds
 		r.dataRenderers[i].ShowUpdates = false
 	}
 
-	r.busBufRenderer.InitRender(&r.core.busBuf, image.Rectangle{
+	r.busBufRenderer.InitRender(image.Rectangle{
 		image.Point{r.bounds.Min.X, r.bounds.Max.Y - r.busHeight - int(css.RegisterHeight)},
 		image.Point{r.bounds.Max.X, r.bounds.Max.Y - r.busHeight}})
 
-	r.dataBusRenderer.InitRender(r.core.busInt,
+	r.dataBusRenderer.InitRender(
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Max.Y - r.busHeight},
 		image.Point{r.bounds.Max.X - int(css.RegisterWidth), r.bounds.Max.Y},
 		r.busWidth)
@@ -66,17 +63,15 @@ func (r *RamRomRenderer) Bounds() image.Rectangle {
 	return r.bounds
 }
 
-// Render the contents to the screen
-func (r *RamRomRenderer) Render(canvas *canvas.Canvas) {
-
-	r.dataBusRenderer.DrivingBus = &r.core.drivingBus
-	r.dataBusRenderer.Render(canvas)
-	r.addrRenderer.Render(canvas)
-	r.busBufRenderer.Render(canvas)
+// Render the state to the screen
+func (r *RamRomRenderer) Render(canvas *canvas.Canvas, state State) {
+	r.dataBusRenderer.Render(canvas, state.Bus, state.DrivingBus)
+	r.addrRenderer.Render(canvas, state.Address)
+	r.busBufRenderer.Render(canvas, state.Buffer)
 	for i := range r.dataRenderers {
-		r.dataRenderers[i].Render(canvas)
+		r.dataRenderers[i].Render(canvas, state.Values[i])
 	}
 
 	canvas.SetFillStyle("#000")
-	canvas.FillText(fmt.Sprintf("ROM %X", r.core.chipID), float64(r.bounds.Min.X+20), float64(r.bounds.Max.Y-20))
+	canvas.FillText(state.Name, float64(r.bounds.Min.X+20), float64(r.bounds.Max.Y-20))
 }
@@ -0,0 +1,91 @@
+package supportcommon
+
+import (
+	"common"
+	"instruction"
+
+	"github.com/romana/rlog"
+)
+
+// The 4008 latches the address of each fetch and sends it to a generic memory,
+// and the 4009 transfers the data between the memory and the 4-bit bus. The
+// pair also decodes the upper 4 bits of the SRC address into 16 I/O ports.
+// WPM and RPM transfer one byte of program memory 4 bits at a time. The first
+// one uses the upper 4 bits, and the second one the lower 4 bits. The address
+// is the SRC address, in the page last written to the page port with WRR.
+// On the Intellec 4, this page came from an I/O port too
+
+// SetMemory connects the memory block. Its first byte is at the start of basePage
+func (r *RamRom) SetMemory(mem *MemoryBlock, basePage int) {
+	r.memory = mem
+	r.basePage = uint64(basePage)
+	r.data = mem.data
+}
+
+// SetPortBus connects the bus for one of the 16 I/O ports
+func (r *RamRom) SetPortBus(port int, bus *common.Bus) {
+	r.ports[port] = bus
+}
+
+// SetPagePort selects the I/O port which holds the page for WPM and RPM.
+// Use -1 to always use page 0
+func (r *RamRom) SetPagePort(port int) {
+	r.pagePort = port
+}
+
+// programMemoryIndex returns the index into the memory for WPM and RPM
+func (r *RamRom) programMemoryIndex() (index uint64, ok bool) {
+	addr := r.pmPage<<8 | r.srcAddressReg.ReadDirect()
+	if r.memory == nil || !r.isFetchForUs(r.pmPage) {
+		rlog.Warnf("%s: program memory address %03X is not in our memory", r.typeName(), addr)
+		return 0, false
+	}
+	return addr - r.basePage*PageSize, true
+}
+
+func (r *RamRom) executeStdMemWrite(cmd uint64, value uint64) {
+	port := r.srcAddressReg.ReadDirect() >> 4
+	switch cmd {
+	case instruction.WRR:
+		if int(port) == r.pagePort {
+			r.pmPage = value & 0xf
+		}
+		if r.ports[port] != nil {
+			r.writePort(r.ports[port], value)
+		}
+	case instruction.WPM:
+		if !r.pmToggle {
+			r.pmUpper = uint8(value&0xf) << 4
+			r.pmToggle = true
+			return
+		}
+		r.pmToggle = false
+		if index, ok := r.programMemoryIndex(); ok {
+			r.memory.Write(index, r.pmUpper|uint8(value&0xf))
+			rlog.Debugf("%s: WPM wrote %02X at %03X", r.typeName(), r.memory.Read(index), index)
+		}
+	}
+}
+
+func (r *RamRom) readStdMem(cmd uint64) (value uint64, ok bool) {
+	switch cmd {
+	case instruction.RDR:
+		port := r.srcAddressReg.ReadDirect() >> 4
+		if r.ports[port] != nil {
+			return r.ports[port].Read() & 0xf, true
+		}
+	case instruction.RPM:
+		index, found := r.programMemoryIndex()
+		upper := !r.pmToggle
+		r.pmToggle = !r.pmToggle
+		if !found {
+			return 0, false
+		}
+		data := uint64(r.memory.Read(index))
+		if upper {
+			return data >> 4, true
+		}
+		return data & 0xf, true
+	}
+	return 0, false
+}
@@ -0,0 +1,348 @@
+package sysconfig
+
+import (
+	"adapter4008"
+	"board"
+	"common"
+	"cpucore"
+	"encoding/json"
+	"fmt"
+	"instruction"
+	"io/ioutil"
+	"path/filepath"
+	"rom4001"
+	"shift4003"
+	"supportcommon"
+)
+
+// A system file describes a complete MCS-4 system in JSON. For example:
+//
+//	{
+//	    "cpu": "4004",
+//	    "crystal": 5185000,
+//	    "float": "precharged",
+//	    "builtin": "LEDCountUsingAdd",
+//	    "roms": [{"id": 0, "ioOutputs": 15}, {"id": 1, "image": "rom1.bin"}],
+//	    "rams": [{"bank": 0, "chip": 0}],
+//	    "peripherals": [{"type": "4003", "name": "LEDs", "chips": 2,
+//	        "port": {"rom": 0}, "clock": 0, "data": 1}]
+//	}
+//
+// Without "roms", the system has a full array of 16 ROMs. Image files are raw
+// binaries, and relative paths are relative to the system file
+
+// System is the contents of a system file
+type System struct {
+	CPU         string          `json:"cpu"`         // "4004" (default) or "4040"
+	Crystal     float64         `json:"crystal"`     // Crystal frequency in Hz
+	Float       string          `json:"float"`       // Undriven data bus reads: "precharged" (default), "hold" or "error"
+	Program     string          `json:"program"`     // Image split across the ROMs by chip ID
+	Builtin     string          `json:"builtin"`     // Built-in program, instead of an image
+	Roms        []Rom           `json:"roms"`        // 4001 ROMs. 16 ROMs with IDs 0-15 if missing
+	Rams        []board.RamChip `json:"rams"`        // 4002 RAMs, as {"bank": 0, "chip": 0}
+	Peripherals []Peripheral    `json:"peripherals"` // Other chips
+}
+
+// Rom is a 4001 ROM
+type Rom struct {
+	ID         int     `json:"id"`         // Chip ID (0-15)
+	Image      string  `json:"image"`      // 256 byte image. Overrides the program
+	IOOutputs  *uint64 `json:"ioOutputs"`  // Mask option: output lines. All outputs if missing
+	IOInverted uint64  `json:"ioInverted"` // Mask option: inverted lines
+}
+
+// Port selects the I/O port of a ROM or a RAM
+type Port struct {
+	Rom *int           `json:"rom"` // Chip ID of the ROM
+	Ram *board.RamChip `json:"ram"` // Position of the RAM
+}
+
+// Peripheral is one of the other chips
+type Peripheral struct {
+	Type string `json:"type"` // "4003" or "4008"
+	Name string `json:"name"`
+
+	// 4003 shift registers
+	Chips  int  `json:"chips"`  // Number of chained chips
+	Port   Port `json:"port"`   // Port driving the lines
+	Clock  int  `json:"clock"`  // Port bit of the clock line
+	Data   int  `json:"data"`   // Port bit of the serial data line
+	Enable *int `json:"enable"` // Port bit of the output enable line. Always enabled if missing
+
+	// 4008/4009 with a memory block
+	Size     int    `json:"size"`     // Bytes. A whole number of pages
+	Writable bool   `json:"writable"` // RAM if true
+	BasePage int    `json:"basePage"` // Page of the first byte
+	PagePort *int   `json:"pagePort"` // Port holding the page for WPM/RPM
+	Image    string `json:"image"`    // Memory image
+	RomBank  int    `json:"romBank"`  // 4040 only: 1 to use CM-ROM1
+}
+
+// Builtins are the programs which can be selected with "builtin"
+var Builtins = map[string]func() []uint8{
+	"LEDCount":         instruction.LEDCount,
+	"LEDCountUsingAdd": instruction.LEDCountUsingAdd,
+	"StackOverflow":    instruction.StackOverflow,
+}
+
+// floatModes are the values of "float"
+var floatModes = map[string]int{
+	"":           common.FloatPrecharged,
+	"precharged": common.FloatPrecharged,
+	"hold":       common.FloatHold,
+	"error":      common.FloatError,
+}
+
+// Load reads a system file and builds the board it describes
+func Load(path string) (*board.Board, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sys := System{}
+	if err := json.Unmarshal(text, &sys); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	b, err := sys.Build(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// Build creates the board. Relative image paths are relative to dir
+func (s *System) Build(dir string) (*board.Board, error) {
+	config := board.DefaultConfig()
+	switch s.CPU {
+	case "", "4004":
+		config.Model = cpucore.Model4004
+	case "4040":
+		config.Model = cpucore.Model4040
+	default:
+		return nil, fmt.Errorf("unknown CPU type %q", s.CPU)
+	}
+	if s.Crystal != 0 {
+		config.Crystal = s.Crystal
+	}
+	float, ok := floatModes[s.Float]
+	if !ok {
+		return nil, fmt.Errorf("unknown floating bus mode %q", s.Float)
+	}
+	config.Float = float
+	for _, rom := range s.Roms {
+		if rom.ID < 0 || rom.ID >= rom4001.MaxChips {
+			return nil, fmt.Errorf("invalid ROM chip ID %d", rom.ID)
+		}
+		config.RomIDs = append(config.RomIDs, rom.ID)
+	}
+	config.NumRams = 0
+	for _, ram := range s.Rams {
+		if ram.Bank < 0 || ram.Bank >= cpucore.NumRamBanks || ram.Chip < 0 || ram.Chip >= board.RamChipsPerBank {
+			return nil, fmt.Errorf("invalid RAM bank %d, chip %d", ram.Bank, ram.Chip)
+		}
+		config.Rams = append(config.Rams, ram)
+	}
+	if err := checkDuplicates(config); err != nil {
+		return nil, err
+	}
+
+	b := &board.Board{}
+	b.Init(config)
+	if err := s.loadRoms(b, dir); err != nil {
+		return nil, err
+	}
+	for _, p := range s.Peripherals {
+		if err := s.addPeripheral(b, p, dir); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func checkDuplicates(config board.Config) error {
+	roms := make(map[int]bool)
+	for _, id := range config.RomIDs {
+		if roms[id] {
+			return fmt.Errorf("duplicate ROM chip ID %d", id)
+		}
+		roms[id] = true
+	}
+	rams := make(map[board.RamChip]bool)
+	for _, ram := range config.Rams {
+		if rams[ram] {
+			return fmt.Errorf("duplicate RAM bank %d, chip %d", ram.Bank, ram.Chip)
+		}
+		rams[ram] = true
+	}
+	return nil
+}
+
+func (s *System) loadRoms(b *board.Board, dir string) error {
+	var program []uint8
+	switch {
+	case s.Program != "" && s.Builtin != "":
+		return fmt.Errorf("only one of program and builtin can be used")
+	case s.Program != "":
+		data, err := readImage(dir, s.Program)
+		if err != nil {
+			return err
+		}
+		program = data
+	case s.Builtin != "":
+		builtin, ok := Builtins[s.Builtin]
+		if !ok {
+			return fmt.Errorf("unknown built-in program %q", s.Builtin)
+		}
+		program = builtin()
+	}
+	if program != nil {
+		if err := b.LoadProgram(program); err != nil {
+			return err
+		}
+	}
+
+	for _, rom := range s.Roms {
+		chip := &b.Roms.Chips[b.Roms.FindChip(rom.ID)]
+		if rom.Image != "" {
+			data, err := readImage(dir, rom.Image)
+			if err != nil {
+				return err
+			}
+			if len(data) > rom4001.Depth {
+				return fmt.Errorf("ROM %d image %s is too large", rom.ID, rom.Image)
+			}
+			chip.LoadProgram(data)
+		}
+		outputs := uint64(0xf)
+		if rom.IOOutputs != nil {
+			outputs = *rom.IOOutputs
+		}
+		chip.SetIOMask(outputs, rom.IOInverted)
+	}
+	return nil
+}
+
+func (s *System) addPeripheral(b *board.Board, p Peripheral, dir string) error {
+	if p.Name == "" {
+		return fmt.Errorf("peripheral of type %q has no name", p.Type)
+	}
+	if _, ok := b.Peripherals[p.Name]; ok {
+		return fmt.Errorf("duplicate peripheral name %q", p.Name)
+	}
+	switch p.Type {
+	case "4003":
+		return addShiftRegister(b, p)
+	case "4008":
+		return addAdapter(b, p, dir)
+	}
+	return fmt.Errorf("peripheral %s: unknown type %q", p.Name, p.Type)
+}
+
+func addShiftRegister(b *board.Board, p Peripheral) error {
+	port, err := findPort(b, p.Port)
+	if err != nil {
+		return fmt.Errorf("peripheral %s: %v", p.Name, err)
+	}
+	chips := p.Chips
+	if chips == 0 {
+		chips = 1
+	}
+	if chips < 0 {
+		return fmt.Errorf("peripheral %s: invalid number of chips %d", p.Name, p.Chips)
+	}
+	if err := checkPortBit(p.Name, "clock", p.Clock); err != nil {
+		return err
+	}
+	if err := checkPortBit(p.Name, "data", p.Data); err != nil {
+		return err
+	}
+	var enable shift4003.Line
+	if p.Enable != nil {
+		if err := checkPortBit(p.Name, "enable", *p.Enable); err != nil {
+			return err
+		}
+		enable = shift4003.PortLine{Bus: port, Bit: uint(*p.Enable)}
+	}
+	chain := &shift4003.Chain{}
+	chain.Init(p.Name, chips, shift4003.PortLine{Bus: port, Bit: uint(p.Clock)},
+		shift4003.PortLine{Bus: port, Bit: uint(p.Data)}, enable)
+	b.AddPeripheral(p.Name, chain)
+	return nil
+}
+
+// checkPortBit returns an error if bit is not one of the I/O port lines
+func checkPortBit(name string, line string, bit int) error {
+	if bit < 0 || bit >= rom4001.BusWidth {
+		return fmt.Errorf("peripheral %s: invalid %s bit %d", name, line, bit)
+	}
+	return nil
+}
+
+func addAdapter(b *board.Board, p Peripheral, dir string) error {
+	// MemoryBlock.Init panics on a bad size
+	if p.Size <= 0 || p.Size%supportcommon.PageSize != 0 || p.Size > supportcommon.MaxPages*supportcommon.PageSize {
+		return fmt.Errorf("peripheral %s: invalid memory size %d", p.Name, p.Size)
+	}
+	if p.BasePage < 0 || p.BasePage >= supportcommon.MaxPages {
+		return fmt.Errorf("peripheral %s: invalid base page %d", p.Name, p.BasePage)
+	}
+	if p.PagePort != nil && (*p.PagePort < 0 || *p.PagePort >= supportcommon.MaxPages) {
+		return fmt.Errorf("peripheral %s: invalid page port %d", p.Name, *p.PagePort)
+	}
+	if p.RomBank != 0 && p.RomBank != 1 {
+		return fmt.Errorf("peripheral %s: invalid ROM bank %d", p.Name, p.RomBank)
+	}
+	if p.RomBank == 1 && b.Core.GetModel() != cpucore.Model4040 {
+		return fmt.Errorf("peripheral %s: ROM bank 1 needs a 4040", p.Name)
+	}
+	memory := &supportcommon.MemoryBlock{}
+	memory.Init(p.Size, p.Writable, p.Name)
+	if p.Image != "" {
+		data, err := readImage(dir, p.Image)
+		if err != nil {
+			return err
+		}
+		if err := memory.Load(data); err != nil {
+			return err
+		}
+	}
+	cm := &b.Core.CmROM
+	if p.RomBank == 1 {
+		cm = &b.Core.CmROM1
+	}
+	adapter := &adapter4008.Adapter4008{}
+	adapter.Init(&b.Core.ExternalDataBus, &b.Core.Sync, cm)
+	adapter.SetMemory(memory, p.BasePage)
+	if p.PagePort != nil {
+		adapter.SetPagePort(*p.PagePort)
+	}
+	adapter.SetResetLine(&b.Core.ResetIn)
+	b.AddPeripheral(p.Name, adapter)
+	return nil
+}
+
+// findPort returns the I/O bus of the ROM or RAM selected by port
+func findPort(b *board.Board, port Port) (*common.Bus, error) {
+	if port.Rom != nil {
+		if i := b.Roms.FindChip(*port.Rom); i >= 0 {
+			return &b.Roms.IOBuses[i], nil
+		}
+		return nil, fmt.Errorf("there is no ROM %d", *port.Rom)
+	}
+	if port.Ram != nil {
+		for i, ram := range b.GetConfig().Rams {
+			if ram == *port.Ram {
+				return &b.RamIOBuses[i], nil
+			}
+		}
+		return nil, fmt.Errorf("there is no RAM bank %d, chip %d", port.Ram.Bank, port.Ram.Chip)
+	}
+	return nil, fmt.Errorf("no port selected")
+}
+
+func readImage(dir string, name string) ([]uint8, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	return ioutil.ReadFile(name)
+}
@@ -0,0 +1,182 @@
+package sysconfig
+
+import (
+	"adapter4008"
+	"common"
+	"cpucore"
+	"instruction"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"shift4003"
+	"testing"
+
+	"github.com/romana/rlog"
+)
+
+func SetupLogger() {
+	// Programmatically change an rlog setting from within the program
+	os.Setenv("RLOG_LOG_LEVEL", "DEBUG")
+	//os.Setenv("RLOG_TRACE_LEVEL", "0")
+	os.Setenv("RLOG_LOG_FILE", "sysconfig_test.log")
+	rlog.UpdateEnv()
+	rlog.Info("Test starting ***********************")
+}
+
+const testDriver = common.NamedDriver("Test")
+
+// writeFiles writes the files into a new directory, and returns the directory
+func writeFiles(t *testing.T, files map[string][]byte) string {
+	dir, err := ioutil.TempDir("", "sysconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	SetupLogger()
+	dir := writeFiles(t, map[string][]byte{
+		"rom0.bin": {instruction.JUN | 0x2, 0x00},
+		"rom2.bin": {instruction.NOP, instruction.NOP},
+		"ram.bin":  {0xAB},
+		"system.json": []byte(`{
+			"cpu": "4040",
+			"crystal": 7000000,
+			"float": "error",
+			"roms": [{"id": 0, "image": "rom0.bin"}, {"id": 2, "image": "rom2.bin"}],
+			"rams": [{"bank": 1, "chip": 2}],
+			"peripherals": [
+				{"type": "4003", "name": "LEDs", "chips": 2, "port": {"ram": {"bank": 1, "chip": 2}},
+				 "clock": 0, "data": 1, "enable": 2},
+				{"type": "4008", "name": "Program RAM", "size": 512, "writable": true,
+				 "basePage": 4, "image": "ram.bin"}
+			]
+		}`),
+	})
+	defer os.RemoveAll(dir)
+
+	b, err := Load(filepath.Join(dir, "system.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Core.GetModel() != cpucore.Model4040 || b.Clock.Frequency() != 1000000 {
+		t.Errorf("CPU mismatch. Model=%d, frequency=%f", b.Core.GetModel(), b.Clock.Frequency())
+	}
+	if b.Core.ExternalDataBus.GetFloat() != common.FloatError {
+		t.Errorf("Floating bus mode mismatch. Got %d", b.Core.ExternalDataBus.GetFloat())
+	}
+	if len(b.Roms.Chips) != 2 || b.Roms.FindChip(2) != 1 || b.Roms.FindChip(1) != -1 {
+		t.Errorf("ROM chips mismatch. Got %d chips", len(b.Roms.Chips))
+	}
+	// Jump from ROM 0 to ROM 2
+	b.Reset()
+	b.StepInstruction()
+	b.StepInstruction()
+	if b.Core.GetProgramCounter() != 0x201 {
+		t.Errorf("ROM image mismatch. Exp PC=201, got %X", b.Core.GetProgramCounter())
+	}
+	if len(b.Rams) != 1 || b.GetConfig().Rams[0].Bank != 1 || b.GetConfig().Rams[0].Chip != 2 {
+		t.Errorf("RAM chips mismatch. Got %v", b.GetConfig().Rams)
+	}
+	if _, ok := b.Peripherals["LEDs"].(*shift4003.Chain); !ok {
+		t.Error("Missing 4003 peripheral")
+	}
+	if _, ok := b.Peripherals["Program RAM"].(*adapter4008.Adapter4008); !ok {
+		t.Error("Missing 4008 peripheral")
+	}
+}
+
+func TestRunBuiltin(t *testing.T) {
+	SetupLogger()
+	dir := writeFiles(t, map[string][]byte{
+		"system.json": []byte(`{"builtin": "LEDCountUsingAdd", "roms": [{"id": 0}]}`),
+	})
+	defer os.RemoveAll(dir)
+
+	b, err := Load(filepath.Join(dir, "system.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	seen := make(map[uint64]bool)
+	for i := 0; i < 100; i++ {
+		b.StepInstruction()
+		seen[b.Roms.IOBuses[0].Read()] = true
+	}
+	if len(seen) < 8 {
+		t.Errorf("The LEDs did not count. Saw %v", seen)
+	}
+}
+
+func TestIOMask(t *testing.T) {
+	SetupLogger()
+	program := []byte{
+		instruction.SRC,       // R0,R1 = 0. Select ROM 0
+		instruction.LDM | 0x6, // 0110
+		instruction.WRR,       // Lines 0-1 are outputs, line 0 is inverted
+		instruction.JUN, 0x03, // Loop here
+	}
+	dir := writeFiles(t, map[string][]byte{
+		"program.bin": program,
+		"system.json": []byte(`{"program": "program.bin",
+			"roms": [{"id": 0, "ioOutputs": 3, "ioInverted": 1}]}`),
+	})
+	defer os.RemoveAll(dir)
+
+	b, err := Load(filepath.Join(dir, "system.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	// Drive the input lines from the outside
+	port := &b.Roms.IOBuses[0]
+	port.Reset()
+	port.Write(testDriver, 0x4|port.Read()&0x3)
+	for i := 0; i < 4; i++ {
+		b.StepInstruction()
+	}
+	if port.Read() != 0x7 {
+		t.Errorf("I/O port mismatch. Exp 7, got %X", port.Read())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	SetupLogger()
+	systems := []string{
+		`{"cpu": "8080"}`,
+		`{"roms": [{"id": 1}, {"id": 1}]}`,
+		`{"roms": [{"id": 16}]}`,
+		`{"rams": [{"bank": 8, "chip": 0}]}`,
+		`{"builtin": "Missing"}`,
+		`{"program": "missing.bin"}`,
+		`{"peripherals": [{"type": "4003", "name": "LEDs", "port": {"rom": 3}}], "roms": [{"id": 0}]}`,
+		`{"peripherals": [{"type": "4003", "name": "LEDs", "port": {"rom": 0}, "chips": -1}]}`,
+		`{"peripherals": [{"type": "4003", "name": "LEDs", "port": {"rom": 0}, "clock": 9}]}`,
+		`{"peripherals": [{"type": "4003", "name": "LEDs", "port": {"rom": 0}, "data": -1}]}`,
+		`{"peripherals": [{"type": "4003", "name": "LEDs", "port": {"rom": 0}, "enable": 4}]}`,
+		`{"peripherals": [{"type": "4008", "name": "Memory", "size": 100}]}`,
+		`{"peripherals": [{"type": "4008", "name": "Memory", "size": 256, "basePage": 16}]}`,
+		`{"peripherals": [{"type": "4008", "name": "Memory", "size": 256, "pagePort": 16}]}`,
+		`{"peripherals": [{"type": "4008", "name": "Memory", "size": 256, "romBank": 2}]}`,
+		`{"cpu": "4040", "peripherals": [{"type": "4008", "name": "Memory", "size": 256, "romBank": -1}]}`,
+		`{"peripherals": [{"type": "4008", "name": "Memory", "size": 256, "romBank": 1}]}`,
+		`{"peripherals": [{"type": "4289", "name": "Interface"}]}`,
+		`{"float": "pulled down"}`,
+		`{"cpu": 4004`,
+	}
+	for _, system := range systems {
+		dir := writeFiles(t, map[string][]byte{"system.json": []byte(system)})
+		if _, err := Load(filepath.Join(dir, "system.json")); err == nil {
+			t.Errorf("Expected an error for %s", system)
+		} else {
+			rlog.Infof("Expected error: %v", err)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -12,16 +12,15 @@ import (
 
 // IoBusRenderer renders an IO bus to the screen
 type IoBusRenderer struct {
-	bus    *common.Bus
 	bounds image.Rectangle
-	dirty  int // needs to be redrawn if non-zero
+	dirty  int    // needs to be redrawn if non-zero
+	value  uint64 // The value rendered last
 	// Child renderers
 	leds []IoBitRenderer
 }
 
 // InitRender initializes the element for rendering
-func (r *IoBusRenderer) InitRender(bus *common.Bus, base int, bounds image.Rectangle) {
-	r.bus = bus
+func (r *IoBusRenderer) InitRender(base int, bounds image.Rectangle) {
 	r.bounds = bounds
 	r.dirty = 2
 	r.leds = make([]IoBitRenderer, 4)
@@ -33,17 +32,17 @@ func (r *IoBusRenderer) InitRender(bus *common.Bus, base int, bounds image.Recta
 	}
 }
 
-// Render the contents to the screen
-func (r *IoBusRenderer) Render(canvas *canvas.Canvas) {
-	if r.bus.Updated {
+// Render the state of the bus to the screen
+func (r *IoBusRenderer) Render(canvas *canvas.Canvas, state common.BusState) {
+	if state.Value != r.value {
 		r.dirty = 2
-		r.bus.Updated = false
+		r.value = state.Value
 	}
 	if r.dirty == 0 {
 		return
 	}
 	for i := range r.leds {
-		data := r.bus.Read()
+		data := state.Value
 		bit := uint8((data >> uint64(i)) & 0x1)
 		r.leds[i].Render(bit, canvas)
 	}
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"common"
+	"board"
 	"cpucore"
 	"css"
+	"flag"
 	"fmt"
+	"functional"
 	"image"
 	"instruction"
 	"os"
-	"rom4001"
 	"supportcommon"
+	"sysconfig"
 
 	"github.com/romana/rlog"
 
@@ -20,6 +22,10 @@ type runFlags struct {
 	StepClock bool // Step one clock
 	StepCycle bool // Step 8 clocks
 	FreeRun   bool // Let 'er rip!
+	BackClock bool // Step back one clock
+	BackInst  bool // Step back one instruction
+	Test      bool // Toggle the TEST pin
+	Reset     bool // Hold the RESET line
 	Halt      bool // Stop the processor
 	Quit      bool // Quit the program
 }
@@ -35,6 +41,14 @@ func KeyDown(scancode int, rn rune, name string) {
 		currentRunFlags.StepCycle = true
 	case "KeyR":
 		currentRunFlags.FreeRun = true
+	case "KeyB":
+		currentRunFlags.BackClock = true
+	case "KeyV":
+		currentRunFlags.BackInst = true
+	case "KeyT":
+		currentRunFlags.Test = true
+	case "KeyX":
+		currentRunFlags.Reset = true
 	case "Escape":
 		fallthrough
 	case "KeyQ":
@@ -43,6 +57,14 @@ func KeyDown(scancode int, rn rune, name string) {
 }
 
 func main() {
+	configFile := flag.String("config", "", "JSON system file describing the chips. Runs a sample program if empty")
+	recordFile := flag.String("record", "", "Recording file to write when quitting, to replay the session with cpumain")
+	backend := flag.String("backend", "cycle", "Free run with cycle: clock every chip, or functional: run whole instructions, much faster, 4004 without peripherals only")
+	flag.Parse()
+	if *backend != "cycle" && *backend != "functional" {
+		fmt.Printf("Unknown backend %q\n", *backend)
+		return
+	}
 
 	enableLog := false
 	// Programmatically change an rlog setting from within the program
@@ -63,19 +85,28 @@ func main() {
 	canvas.SetFont("C:\\Windows\\Fonts\\courbd.ttf", 24)
 	defer wnd.Close()
 
-	core := cpucore.Core{}
-	core.Init()
-
-	ioBus := common.Bus{}
-	ioBus.Init(4, "ROM I/O bus")
-	rom := rom4001.Rom4001{}
-	rom.Init(&core.ExternalDataBus, &core.Sync, &core.CmROM)
-	rom.SetIOBus(&ioBus)
-	WriteROM(&rom)
+	b, err := CreateBoard(*configFile)
+	if err != nil {
+		rlog.Error(err)
+		fmt.Println(err)
+		return
+	}
+	state := b.Snapshot()
+	// Keep the last million clocks, to step back
+	history := b.EnableHistory(1000, 1000)
+	var recorder *board.Recorder
+	if *recordFile != "" {
+		if recorder, err = b.Record(); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+			return
+		}
+	}
 
+	// Only the first ROM and its I/O port are shown
 	romRenderer := supportcommon.RamRomRenderer{}
 	romLeft := int(css.Margin) + 40
-	romRenderer.InitRender(&rom.Core, canvas, image.Rectangle{
+	romRenderer.InitRender(canvas, image.Rectangle{
 		image.Point{romLeft, int(css.Margin)},
 		image.Point{romLeft, int(css.Margin)}})
 	romHeight := romRenderer.Bounds().Dy()
@@ -85,12 +116,12 @@ func main() {
 	led0Left := romLeft + romWidth + 20
 	ledWidth := 120
 	ledHeight := 120
-	led0Renderer.InitRender(&ioBus, 0, image.Rectangle{
+	led0Renderer.InitRender(0, image.Rectangle{
 		image.Point{led0Left, int(css.Margin)},
 		image.Point{led0Left + ledWidth, int(css.Margin) + ledHeight}})
 
 	coreRenderer := cpucore.Renderer{}
-	coreRenderer.InitRender(&core, canvas, image.Rectangle{
+	coreRenderer.InitRender(state.Core, canvas, image.Rectangle{
 		image.Point{int(css.Margin), int(css.Margin) + romHeight},
 		image.Point{canvas.Width() - int(2*css.Margin), canvas.Height() - int(2*css.Margin) - romHeight}})
 
@@ -104,44 +135,79 @@ func main() {
 	// which works out to about 5,300 clocks max before the frame
 	// rate drops. 8192 gives about 20fps on my machine
 	clocksPerRender := 1
+	// The functional backend runs many instructions between renders
+	functionalClocksPerRender := 100000
+	clocksRun := clocksPerRender
 	cycleCount := 0
+	clock := func() {
+		if enableLog {
+			DumpState(b)
+			rlog.Info("SETUP PHASE **************************************************")
+		}
+
+		b.Step(1)
+	}
 	wnd.MainLoop(func() {
 		if currentRunFlags.Quit {
 			wnd.Close()
 		}
-		if currentRunFlags.StepClock || currentRunFlags.StepCycle || currentRunFlags.FreeRun {
+		if currentRunFlags.Reset {
+			// Hold RESET long enough to clear the CPU and the RAMs
+			b.Reset()
+			currentRunFlags.Reset = false
+			cycleCount = 0
+			renderCount = 2
+		}
+		if currentRunFlags.Test {
+			b.Core.Test.Drive("TEST switch", 1-b.Core.Test.Level())
+			currentRunFlags.Test = false
+			renderCount = 2
+		}
+		if currentRunFlags.BackClock || currentRunFlags.BackInst {
+			if currentRunFlags.BackClock {
+				err = history.StepBack(1)
+			} else {
+				err = history.StepBackInstruction()
+			}
+			if err != nil {
+				rlog.Warn(err)
+			}
+			currentRunFlags.BackClock = false
+			currentRunFlags.BackInst = false
+			renderCount = 2
+		}
+		if currentRunFlags.FreeRun && *backend == "functional" {
+			if err := runFunctional(b, functionalClocksPerRender); err != nil {
+				rlog.Warn(err)
+				fmt.Println(err)
+				*backend = "cycle"
+			} else {
+				history.Sync()
+				clocksRun = functionalClocksPerRender
+				renderCount = 2
+			}
+		} else if currentRunFlags.StepClock || currentRunFlags.StepCycle || currentRunFlags.FreeRun {
 			for i := 0; i < clocksPerRender; i++ {
-				if enableLog {
-					DumpState(core, rom, &ioBus)
-					rlog.Info("SETUP PHASE **************************************************")
-				}
-
-				core.Calculate()
-				core.ClockIn()
-				rom.ClockIn()
-				if enableLog {
-					rlog.Info("CLOCK PHASE **************************************************")
-				}
-				core.ClockOut()
-				rom.ClockOut()
-				core.UpdateInternalBus()
+				clock()
 				cycleCount++
 			}
+			clocksRun = clocksPerRender
 			// Render twice because glfw is double buffered
 			renderCount = 2
 		}
 		if renderCount > 0 {
-			coreRenderer.Render(canvas)
-			romRenderer.Render(canvas)
-			led0Renderer.Render(canvas)
+			state = b.Snapshot()
+			coreRenderer.Render(canvas, state.Core)
+			romRenderer.Render(canvas, state.Roms[0])
+			led0Renderer.Render(canvas, state.RomIOBuses[0])
 			canvas.SetFillStyle("#ccc")
 			canvas.FillRect(20, float64(canvas.Height())-70, float64(canvas.Width()), 80)
 			canvas.SetFillStyle("#000")
 			canvas.FillText(fmt.Sprintf("FPS=%3.1f, CPU Clock=%3.2f kHz",
-				wnd.FPS(), (wnd.FPS()*float32(clocksPerRender))/1000),
+				wnd.FPS(), (wnd.FPS()*float32(clocksRun))/1000),
 				20, float64(canvas.Height())-40)
 
-			canvas.FillText(fmt.Sprintf("'C'=Step Clock 'S'=Step Cycle 'R'=Free Run 'Q'=Quit"),
+			canvas.FillText(fmt.Sprintf("'C'=Step Clock 'S'=Step Cycle 'R'=Free Run 'B'=Back Clock 'V'=Back Inst 'T'=TEST 'X'=Reset 'Q'=Quit"),
 				20, float64(canvas.Height())-10)
 			renderCount--
 		}
@@ -152,21 +218,61 @@ func main() {
 		}
 	})
 
+	if recorder != nil {
+		if err := saveRecording(recorder, *recordFile); err != nil {
+			rlog.Error(err)
+			fmt.Println(err)
+		}
+	}
 	rlog.Info("Goodbye")
 }
 
-func DumpState(core cpucore.Core, rom rom4001.Rom4001, romIoBus *common.Bus) {
+// runFunctional runs a number of clocks with the functional backend, and stores
+// the state back to the board to draw it
+func runFunctional(b *board.Board, clocks int) error {
+	m := &functional.Machine{}
+	if err := m.Init(b); err != nil {
+		return err
+	}
+	if err := m.Run(uint64(clocks)); err != nil {
+		return err
+	}
+	return m.Store()
+}
+
+func saveRecording(recorder *board.Recorder, path string) error {
+	rec, err := recorder.Recording()
+	if err != nil {
+		return err
+	}
+	return rec.SaveFile(path)
+}
+
+func DumpState(b *board.Board) {
 	rlog.Infof("PC=%X, DBUS=%X, INST=%X, ROMIO=%X, SYNC=%d, CCLK=%d, ROMCLK=%d",
-		core.GetProgramCounter(),
-		core.ExternalDataBus.Read(),
-		core.GetInstructionRegister(),
-		romIoBus.Read(),
-		core.Sync, core.GetClockCount(),
-		rom.GetClockCount())
+		b.Core.GetProgramCounter(),
+		b.Core.ExternalDataBus.Value(),
+		b.Core.GetInstructionRegister(),
+		b.Roms.IOBuses[0].Value(),
+		b.Core.Sync.Level(), b.Core.GetClockCount(),
+		b.Roms.GetClockCount())
 }
 
-func WriteROM(r *rom4001.Rom4001) {
+// CreateBoard loads the system file, or creates the default system with a sample program
+func CreateBoard(configFile string) (*board.Board, error) {
+	if configFile != "" {
+		return sysconfig.Load(configFile)
+	}
+	b := &board.Board{}
+	b.Init(board.DefaultConfig())
+	WriteROM(b)
+	return b, nil
+}
+
+func WriteROM(b *board.Board) {
 	// Load a sample program into memory
 	data := instruction.LEDCountUsingAdd()
-	r.LoadProgram(data)
+	if err := b.LoadProgram(data); err != nil {
+		rlog.Error(err)
+	}
 }